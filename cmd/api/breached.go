@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	breachedPrefixLength = 5 // hex characters, like the k-anonymity range API
+	breachedBuckets      = 1 << (4 * breachedPrefixLength)
+)
+
+/*
+BreachedPasswords checks the passwords against a local copy of the Pwned Passwords list, without calling any service.
+The file has one `SHA1:COUNT` line per password, ordered by hash, as downloaded from haveibeenpwned.com.
+Only the offset of every 5 character hash prefix is kept in memory, a lookup reads the lines of its prefix from the file.
+*/
+type BreachedPasswords struct {
+	file    *os.File
+	offsets []int64 // offsets[p] is where the lines of prefix p start, and where the lines of prefix p-1 end
+}
+
+/*
+LoadBreachedPasswords opens the file and indexes it by hash prefix, it fails if the file is not ordered by hash.
+*/
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := indexBreachedPasswords(file)
+
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &BreachedPasswords{file: file, offsets: offsets}, nil
+}
+
+func indexBreachedPasswords(r io.Reader) ([]int64, error) {
+	offsets := make([]int64, breachedBuckets+1)
+	reader := bufio.NewReaderSize(r, 1<<20)
+
+	var offset int64
+	next := 0 // the next prefix which has no offset yet
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+
+		if strings.TrimSpace(line) != "" {
+			if len(line) < sha1.Size*2 {
+				return nil, fmt.Errorf("line %d: not a SHA-1 hash", lineNumber)
+			}
+
+			prefix, perr := strconv.ParseUint(line[:breachedPrefixLength], 16, 32)
+
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: not a SHA-1 hash", lineNumber)
+			}
+
+			if int(prefix)+1 < next {
+				return nil, fmt.Errorf("line %d: not ordered by hash", lineNumber)
+			}
+
+			for ; next <= int(prefix); next++ {
+				offsets[next] = offset
+			}
+		}
+
+		offset += int64(len(line))
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for ; next <= breachedBuckets; next++ {
+		offsets[next] = offset
+	}
+
+	return offsets, nil
+}
+
+/*
+Count returns how many times the password appears in the breaches, 0 if it does not.
+*/
+func (b *BreachedPasswords) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	prefix, _ := strconv.ParseUint(hash[:breachedPrefixLength], 16, 32)
+	start, end := b.offsets[prefix], b.offsets[prefix+1]
+
+	bucket := make([]byte, end-start)
+
+	_, err := b.file.ReadAt(bucket, start)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range bytes.Split(bucket, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		found, count, _ := bytes.Cut(line, []byte(":"))
+
+		if !strings.EqualFold(string(found), hash) {
+			continue
+		}
+
+		if len(count) == 0 {
+			return 1, nil
+		}
+
+		return strconv.Atoi(string(count))
+	}
+
+	return 0, nil
+}
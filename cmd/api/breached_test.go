@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+Testing the lookup of the breached passwords
+*/
+func Test_BreachedPasswords(t *testing.T) {
+	breached := loadTestBreachedPasswords(t, map[string]int{
+		"password": 9545824,
+		"123456":   37359195,
+		"qwerty":   3946737,
+	})
+
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{"password", 9545824},
+		{"123456", 37359195},
+		{"qwerty", 3946737},
+		{"correct horse battery staple", 0},
+	}
+
+	for _, test := range tests {
+		count, err := breached.Count(test.password)
+
+		if err != nil {
+			t.Fatalf("Failed to look up %q: %s", test.password, err.Error())
+		}
+
+		if count != test.count {
+			t.Errorf("FAILED: %q Expected %d get %d", test.password, test.count, count)
+		}
+	}
+}
+
+/*
+Testing the loading of a breached passwords file which is not ordered by hash
+*/
+func Test_BreachedPasswordsNotOrdered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+
+	err := os.WriteFile(path, []byte(sha1Hex("123456")+":1\r\n"+sha1Hex("password")+":1\r\n"), 0o600)
+
+	if err != nil {
+		t.Fatalf("Failed to write breached passwords: %s", err.Error())
+	}
+
+	_, err = LoadBreachedPasswords(path)
+
+	if err == nil {
+		t.Errorf("FAILED: Expected an error for a file not ordered by hash")
+	}
+}
+
+/*
+Testing POST /v1/add with a breached password
+*/
+func Test_AddUserBreachedPassword(t *testing.T) {
+	app := testApp
+	app.Breached = loadTestBreachedPasswords(t, map[string]int{"password": 9545824})
+
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(app.routes(), http.MethodPost, "/v1/add", `{"username":"new-user","password":"password"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	var refused struct {
+		Data struct {
+			Violations []passwordViolation `json:"violations"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &refused)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	if len(refused.Data.Violations) != 1 || refused.Data.Violations[0].Rule != "breached" {
+		t.Errorf("FAILED: Expected breached violation get %s", reqRecorder.Body.String())
+	}
+}
+
+/*
+Function to write the passwords with their counts in the Pwned Passwords format and load them
+*/
+func loadTestBreachedPasswords(t *testing.T, passwords map[string]int) *BreachedPasswords {
+	lines := []string{}
+	for password, count := range passwords {
+		lines = append(lines, sha1Hex(password)+":"+strconv.Itoa(count))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600)
+
+	if err != nil {
+		t.Fatalf("Failed to write breached passwords: %s", err.Error())
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+
+	if err != nil {
+		t.Fatalf("Failed to load breached passwords: %s", err.Error())
+	}
+
+	return breached
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
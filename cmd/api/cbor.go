@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+A minimal CBOR (RFC 8949) decoder, just enough for the WebAuthn attestation objects and COSE keys:
+integers, byte and text strings, arrays, maps, booleans and null, with definite lengths only.
+Integers decode to int64, byte strings to []byte, text strings to string, arrays to []any and maps to map[any]any.
+*/
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// how deep arrays and maps can be nested, the WebAuthn structures are only a few levels deep
+const cborMaxDepth = 16
+
+/*
+decodeCBOR decodes the first CBOR item of the data and returns it along with the rest of the data.
+*/
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor: nested too deep")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// simple values: false, true and null
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	argument, data, err := cborArgument(info, data)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(argument), data, nil
+
+	case 1:
+		if argument > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(argument), data, nil
+
+	case 2, 3:
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		value := make([]byte, argument)
+		copy(value, data[:argument])
+
+		if major == 3 {
+			return string(value), data[argument:], nil
+		}
+		return value, data[argument:], nil
+
+	case 4:
+		// every item takes at least a byte, which bounds the allocation
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		items := make([]any, argument)
+
+		for i := range items {
+			items[i], data, err = decodeCBORItem(data, depth+1)
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return items, data, nil
+
+	case 5:
+		if argument > uint64(len(data))/2 {
+			return nil, nil, errCBORTruncated
+		}
+
+		items := make(map[any]any, argument)
+
+		for i := uint64(0); i < argument; i++ {
+			var key, value any
+
+			key, data, err = decodeCBORItem(data, depth+1)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key")
+			}
+
+			value, data, err = decodeCBORItem(data, depth+1)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			items[key] = value
+		}
+
+		return items, data, nil
+
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+}
+
+/*
+cborArgument reads the argument of an item, its value or its length, from the additional information and the data.
+*/
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info >= 24 && info <= 27:
+		return 0, nil, errCBORTruncated
+	default:
+		return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+/*
+Testing the CBOR decoder against the examples of RFC 8949
+
+	-> The malformed, truncated and unsupported items are refused
+*/
+func Test_DecodeCBOR(t *testing.T) {
+	tests := []struct {
+		hex   string
+		value any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	}
+
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.hex)
+		value, rest, err := decodeCBOR(raw)
+
+		if err != nil || len(rest) != 0 || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("FAILED: %s Expected %#v get %#v %v", test.hex, test.value, value, err)
+		}
+	}
+
+	// the rest after the first item is returned
+	_, rest, err := decodeCBOR([]byte{0x01, 0x02})
+
+	if err != nil || len(rest) != 1 || rest[0] != 0x02 {
+		t.Errorf("FAILED: Expected the rest [2] get %v %v", rest, err)
+	}
+
+	invalid := []string{
+		"",                                     // empty
+		"19",                                   // truncated argument
+		"4401",                                 // truncated byte string
+		"830102",                               // truncated array
+		"a2010203",                             // truncated map
+		"5f4101ff",                             // indefinite length
+		"1bffffffffffffffff",                   // integer overflow
+		"a1f601",                               // unsupported map key
+		"f9",                                   // half precision float
+		"c0",                                   // tag
+		"818181818181818181818181818181818181", // nested too deep
+	}
+
+	for _, test := range invalid {
+		raw, _ := hex.DecodeString(test)
+
+		if _, _, err := decodeCBOR(raw); err == nil {
+			t.Errorf("FAILED: Expected %q to be refused", test)
+		}
+	}
+}
+
+/*
+Fuzzing the CBOR decoder, run with `go test -fuzz=FuzzDecodeCBOR ./cmd/api`
+
+	-> Any input is decoded or refused without a panic
+	-> A decoded item leaves the rest of the data, after at least one byte
+*/
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range []string{"00", "3903e7", "f5", "4401020304", "6449455446", "8301820203820405", "a26161016162820203", "1bffffffffffffffff", "5f4101ff"} {
+		raw, _ := hex.DecodeString(seed)
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		_, rest, err := decodeCBOR(raw)
+
+		if err == nil && (len(rest) >= len(raw) || !bytes.Equal(rest, raw[len(raw)-len(rest):])) {
+			t.Errorf("FAILED: Expected the rest to be a suffix of the data get %x for %x", rest, raw)
+		}
+	})
+}
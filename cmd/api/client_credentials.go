@@ -0,0 +1,75 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+/*
+clientCredentialsGrant issues an access token of the machine client for itself, with the requested scopes
+or every one of its scopes if it asks for none. Only the confidential clients can use it, and no refresh token
+is issued, as the client can authenticate again for a new access token.
+*/
+func (app *Config) clientCredentialsGrant(c *gin.Context, client *data.OAuthClient) {
+	if !client.Confidential {
+		sendOAuthError(c, http.StatusBadRequest, "unauthorized_client", "only confidential clients can use the client credentials grant")
+		return
+	}
+
+	requested := strings.Fields(c.PostForm("scope"))
+
+	// only the permissions mean something without a user
+	scopes := []string{}
+	for _, scope := range client.Scopes {
+		if isPermission(scope) && (len(requested) == 0 || hasScope(requested, scope)) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	for _, scope := range requested {
+		if !hasScope(scopes, scope) {
+			sendOAuthError(c, http.StatusBadRequest, "invalid_scope", "scope "+scope+" is not registered for the client")
+			return
+		}
+	}
+
+	accessToken, err := app.newClientAccessToken(*client, scopes)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+/*
+newClientAccessToken creates a short lived JWT access token of the machine client, with the client subject type.
+It has no user and no session, its subject is the client, and the organization of the client is added.
+*/
+func (app *Config) newClientAccessToken(client data.OAuthClient, scopes []string) (string, error) {
+	now := time.Now()
+
+	return app.Keys.Sign(jwt.MapClaims{
+		"sub":       client.ID,
+		"sub_type":  subjectClient,
+		"jti":       uuid.NewString(),
+		"iat":       float64(now.UnixMicro()) / 1e6,
+		"exp":       now.Add(accessTokenTTL).Unix(),
+		"client_id": client.ID,
+		"org_id":    client.OrganizationID,
+		"scope":     strings.Join(scopes, " "),
+		"iss":       app.Issuer,
+	})
+}
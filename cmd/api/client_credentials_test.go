@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+/*
+Testing the client credentials grant of the machine clients
+
+	-> A machine client must be confidential, and needs no redirect URIs
+	-> It gets an access token for itself with its secret, limited to its scopes and without a refresh token
+	-> The token is let through by the permissions of its scopes, but not to the account endpoints nor userinfo
+	-> It can not use the authorization code flow, and its tokens stop working once it is deleted
+*/
+func Test_OAuthClientCredentials(t *testing.T) {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"Job","scopes":["users:read"],"grant_types":["client_credentials"]}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected a public machine client to be refused get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"Job","scopes":["users:read","users:delete"],"grant_types":["client_credentials"],"confidential":true}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	response := responseData(t, reqRecorder)
+	client, _ := response["client"].(map[string]any)
+	clientID, _ := client["id"].(string)
+	secret, _ := response["client_secret"].(string)
+
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {"wrong-secret"}}
+
+	reqRecorder = serveForm(router, "/oauth/token", form)
+
+	if reqRecorder.Code != http.StatusUnauthorized || oauthErrorCode(t, reqRecorder) != "invalid_client" {
+		t.Errorf("FAILED: Expected invalid_client get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	form.Set("client_secret", secret)
+	form.Set("scope", "users:read roles:manage")
+
+	reqRecorder = serveForm(router, "/oauth/token", form)
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_scope" {
+		t.Errorf("FAILED: Expected invalid_scope get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	form.Set("scope", "users:read")
+
+	reqRecorder = serveForm(router, "/oauth/token", form)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+
+	if tokens["scope"] != "users:read" || tokens["refresh_token"] != nil {
+		t.Errorf("FAILED: Expected the users:read scope without a refresh token get %v", tokens)
+	}
+
+	accessToken, _ := tokens["access_token"].(string)
+
+	claims, err := testApp.parseAccessToken(accessToken)
+
+	if err != nil || claims.SubjectType != subjectClient || claims.UserID != "" || claims.ClientID != clientID {
+		t.Errorf("FAILED: Expected a token with the client subject type get %+v %v", claims, err)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodDelete, "/v1/delete", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the scope of the token to limit the client get %d", reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/me", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the account endpoints to need a session get %d", reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/userinfo", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected userinfo to need a user get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+authorizationTestQuery(clientID, "users:read"), "", "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected the machine client to be refused by the authorization endpoint get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"unknown"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+	})
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "unauthorized_client" {
+		t.Errorf("FAILED: Expected unauthorized_client get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/oauth/clients/"+clientID, "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected the token of the deleted client to stop working get %d", reqRecorder.Code)
+	}
+}
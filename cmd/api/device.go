@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	deviceCodeTTL = 10 * time.Minute
+
+	// seconds a device waits between two polls of the token endpoint
+	devicePollInterval = 5
+
+	// consonants only, so that the user codes spell no words and are not mistaken for digits, as RFC 8628 suggests
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+/*
+deviceAuthorization is the device authorization endpoint (RFC 8628). A device which can not open a browser, like a CLI,
+gets a device code to poll the token endpoint with, and a user code along with the page where a logged in user enters it.
+The clients must be registered for the device code grant, the public ones only send their client_id.
+*/
+func (app *Config) deviceAuthorization(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := app.authenticateClient(c)
+
+	if !ok {
+		return
+	}
+
+	if !client.HasGrantType(data.GrantDeviceCode) {
+		sendOAuthError(c, http.StatusBadRequest, "unauthorized_client", "client is not registered for the device code grant")
+		return
+	}
+
+	scopes := strings.Fields(c.PostForm("scope"))
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	for _, scope := range scopes {
+		if !hasScope(client.Scopes, scope) {
+			sendOAuthError(c, http.StatusBadRequest, "invalid_scope", "scope "+scope+" is not allowed for the client")
+			return
+		}
+	}
+
+	deviceCode, err := randomToken()
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	userCode, err := newUserCode()
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	err = app.Repo.InsertDeviceAuthorization(data.DeviceAuthorization{
+		ClientID:       client.ID,
+		Scope:          strings.Join(scopes, " "),
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCodeHash:   hashToken(userCode),
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(deviceCodeTTL),
+	})
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	displayed := userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 displayed,
+		"verification_uri":          APP_URL + "/device",
+		"verification_uri_complete": APP_URL + "/device?" + url.Values{"user_code": {displayed}}.Encode(),
+		"expires_in":                int(deviceCodeTTL.Seconds()),
+		"interval":                  devicePollInterval,
+	})
+}
+
+/*
+getDeviceAuthorization is a handler that returns the client and the scopes of the device authorization of the
+`user_code` query parameter, for the page of the app (`APP_URL/device`) to ask the logged in user about it.
+*/
+func (app *Config) getDeviceAuthorization(c *gin.Context) {
+	user := app.sessionUser(c)
+
+	if user == nil {
+		sendResponse("Log in first", "login required", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	device, client, ok := app.deviceFromUserCode(c, *user, c.Query("user_code"))
+
+	if !ok {
+		return
+	}
+
+	sendResponse("Successfully get device authorization", "", map[string]any{
+		"client_id":   client.ID,
+		"client_name": client.Name,
+		"scopes":      strings.Fields(device.Scope),
+		"expires_at":  device.ExpiresAt,
+	}, c, http.StatusOK)
+}
+
+/*
+decideDeviceAuthorization is a handler that takes the user code and the answer of the user logged in with the session cookie
+from the request body, and approves or denies the device authorization. An approved device gets the scopes the role of the
+user grants at its next poll of the token endpoint.
+*/
+func (app *Config) decideDeviceAuthorization(c *gin.Context) {
+	var reqPayload struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	user := app.sessionUser(c)
+
+	if user == nil {
+		sendResponse("Log in first", "login required", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	device, client, ok := app.deviceFromUserCode(c, *user, reqPayload.UserCode)
+
+	if !ok {
+		return
+	}
+
+	var scopes []string
+
+	if reqPayload.Approve {
+		enrollmentRequired, err := app.mfaEnrollmentRequired(*user)
+
+		if err != nil {
+			sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if enrollmentRequired {
+			sendResponse("MFA enrollment required", "mfa enrollment required", nil, c, http.StatusForbidden)
+			return
+		}
+
+		scopes, err = app.grantedScopes(*user, authorization{Client: *client, Scopes: strings.Fields(device.Scope)})
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = app.Repo.DecideDeviceAuthorization(*device, *user, strings.Join(scopes, " "), reqPayload.Approve)
+
+	if errors.Is(err, data.ErrDeviceAuthorizationDecided) {
+		sendResponse("Invalid or expired user code", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		sendResponse("Failed to save device authorization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !reqPayload.Approve {
+		sendResponse("Successfully denied device", "", nil, c, http.StatusOK)
+		return
+	}
+
+	sendResponse("Successfully approved device", "", map[string]any{
+		"scopes": scopes,
+	}, c, http.StatusOK)
+}
+
+/*
+deviceFromUserCode returns the pending device authorization of the user code and its client, if the client belongs to
+the organization of the user. Otherwise it sends the error response and returns false, the codes of the other
+organizations look like unknown ones.
+*/
+func (app *Config) deviceFromUserCode(c *gin.Context, user data.User, userCode string) (*data.DeviceAuthorization, *data.OAuthClient, bool) {
+	userCode = normalizeUserCode(userCode)
+
+	if userCode == "" {
+		sendResponse("Missing user code in request", "missing user code in request", nil, c, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	device, err := app.Repo.GetDeviceAuthorization(hashToken(userCode))
+
+	if errors.Is(err, data.ErrInvalidUserCode) {
+		sendResponse("Invalid or expired user code", err.Error(), nil, c, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	if err != nil {
+		sendResponse("Failed to get device authorization", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	client, err := app.Repo.GetOAuthClient(device.ClientID)
+
+	if err != nil {
+		sendResponse("Failed to get client", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if client.ID == "" || client.OrganizationID != user.OrganizationID {
+		sendResponse("Invalid or expired user code", data.ErrInvalidUserCode.Error(), nil, c, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return device, client, true
+}
+
+/*
+deviceCodeGrant exchanges the device code of the client for the tokens of the user who approved it, once.
+Until then the device gets authorization_pending, and slow_down if it polls before its interval is over,
+which then grows by 5 seconds. A denied authorization gets access_denied, and an expired one expired_token.
+*/
+func (app *Config) deviceCodeGrant(c *gin.Context, client *data.OAuthClient) {
+	deviceCode := c.PostForm("device_code")
+
+	if deviceCode == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing device_code")
+		return
+	}
+
+	device, err := app.Repo.PollDeviceAuthorization(hashToken(deviceCode), client.ID)
+
+	switch {
+	case errors.Is(err, data.ErrDeviceAuthorizationPending):
+		sendOAuthError(c, http.StatusBadRequest, "authorization_pending", err.Error())
+		return
+	case errors.Is(err, data.ErrDeviceSlowDown):
+		sendOAuthError(c, http.StatusBadRequest, "slow_down", err.Error())
+		return
+	case errors.Is(err, data.ErrDeviceAuthorizationDenied):
+		sendOAuthError(c, http.StatusBadRequest, "access_denied", err.Error())
+		return
+	case errors.Is(err, data.ErrDeviceCodeExpired):
+		sendOAuthError(c, http.StatusBadRequest, "expired_token", err.Error())
+		return
+	case errors.Is(err, data.ErrInvalidDeviceCode):
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	case err != nil:
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	user, err := app.Repo.GetByID(device.UserID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if user.ID == "" || isLocked(*user) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "user does not exist or is locked")
+		return
+	}
+
+	app.issueOAuthTokens(c, *user, *client, device.ID, device.Scope, "")
+}
+
+/*
+newUserCode returns a random user code of userCodeLength characters of the userCodeAlphabet.
+*/
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+/*
+normalizeUserCode returns the user code as it is stored, in upper case and without the dash or the spaces the users type.
+*/
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(userCode)))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testDeviceGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+/*
+Testing the device authorization grant
+
+	-> Only the clients registered for the device code grant get a user code
+	-> The device gets authorization_pending until the code is approved, and slow_down when it polls too often
+	-> A logged in user approves the code, with the scopes their role grants, and the device gets the tokens once
+	-> A denied code gets access_denied
+*/
+func Test_OAuthDeviceFlow(t *testing.T) {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"CLI","scopes":["users:read","users:delete"],"grant_types":["`+testDeviceGrant+`","refresh_token"]}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	client, _ := responseData(t, reqRecorder)["client"].(map[string]any)
+	clientID, _ := client["id"].(string)
+
+	webClientID := createTestOAuthClient(t, `["users:read"]`)
+
+	reqRecorder = serveForm(router, "/oauth/device_authorization", url.Values{"client_id": {webClientID}})
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "unauthorized_client" {
+		t.Errorf("FAILED: Expected unauthorized_client get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	// the device polls before the code is approved, then too often
+	deviceCode, _ := requestTestDeviceCode(t, clientID)
+
+	poll := url.Values{"grant_type": {testDeviceGrant}, "device_code": {deviceCode}, "client_id": {clientID}}
+
+	if reqRecorder = serveForm(router, "/oauth/token", poll); oauthErrorCode(t, reqRecorder) != "authorization_pending" {
+		t.Errorf("FAILED: Expected authorization_pending get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	if reqRecorder = serveForm(router, "/oauth/token", poll); oauthErrorCode(t, reqRecorder) != "slow_down" {
+		t.Errorf("FAILED: Expected slow_down get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	// the member approves a code typed in lower case, the device only gets the scope of their role
+	deviceCode, userCode := requestTestDeviceCode(t, clientID)
+
+	memberToken, err := signJWTTestTokenFor(testApp.Keys, "random-test-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/device", `{"user_code":"`+userCode+`","approve":true}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected the user to log in first get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/device?user_code="+userCode, "", memberToken)
+
+	if response := responseData(t, reqRecorder); reqRecorder.Code != http.StatusOK || response["client_name"] != "CLI" {
+		t.Errorf("FAILED: Expected the client of the code get %d %v", reqRecorder.Code, response)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/device", `{"user_code":"`+strings.ToLower(userCode)+`","approve":true}`, memberToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/device", `{"user_code":"`+userCode+`","approve":true}`, memberToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected the approved code to be used up get %d", reqRecorder.Code)
+	}
+
+	poll.Set("device_code", deviceCode)
+
+	reqRecorder = serveForm(router, "/oauth/token", poll)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+
+	if tokens["scope"] != "users:read" || tokens["refresh_token"] == "" {
+		t.Errorf("FAILED: Expected the tokens with the scope of the member get %v", tokens)
+	}
+
+	accessToken, _ := tokens["access_token"].(string)
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if reqRecorder = serveForm(router, "/oauth/token", poll); oauthErrorCode(t, reqRecorder) != "invalid_grant" {
+		t.Errorf("FAILED: Expected the device code to be used once get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	// the member denies the code
+	deviceCode, userCode = requestTestDeviceCode(t, clientID)
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/device", `{"user_code":"`+userCode+`","approve":false}`, memberToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	poll.Set("device_code", deviceCode)
+
+	if reqRecorder = serveForm(router, "/oauth/token", poll); oauthErrorCode(t, reqRecorder) != "access_denied" {
+		t.Errorf("FAILED: Expected access_denied get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+}
+
+// requestTestDeviceCode starts a device authorization of the client and returns the device code and the user code
+func requestTestDeviceCode(t *testing.T, clientID string) (string, string) {
+	reqRecorder := serveForm(router, "/oauth/device_authorization", url.Values{"client_id": {clientID}})
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	response := oauthTokens(t, reqRecorder)
+	deviceCode, _ := response["device_code"].(string)
+	userCode, _ := response["user_code"].(string)
+
+	if deviceCode == "" || len(userCode) != 9 || response["verification_uri"] != APP_URL+"/device" || response["interval"] != float64(5) {
+		t.Fatalf("FAILED: Expected a device code and a user code get %v", response)
+	}
+
+	return deviceCode, userCode
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"errors"
 	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 func sendResponse(message, err string, data map[string]any, c *gin.Context, code int) {
@@ -24,7 +24,9 @@ func sendResponse(message, err string, data map[string]any, c *gin.Context, code
 
 /*
 Login is a handler that takes the username and password from the request body and checks if the user exists.
-If user exist in the data base then it create a JWT token and set it in the cookie.
+If user exist in the data base then it create a short lived JWT access token and a refresh token and set them in the cookie.
+Users with MFA get an MFA token instead, to exchange along with a code for the session at /v1/login/mfa.
+Failed logins lock the user out for a while, an unknown user, a wrong password and a locked user get the same response.
 */
 func (app *Config) login(c *gin.Context) {
 
@@ -48,58 +50,172 @@ func (app *Config) login(c *gin.Context) {
 		return
 	}
 
+	user, ok := app.authenticatePassword(c, username, password)
+
+	if !ok {
+		return
+	}
+
+	app.firstFactorLogin(c, user)
+}
+
+/*
+authenticatePassword returns the user with the username if the password matches, otherwise it sends the error response
+and returns false. A failed attempt counts towards the lockout of the user, an unknown user, a wrong password
+and a locked user get the same response.
+*/
+func (app *Config) authenticatePassword(c *gin.Context, username, password string) (*data.User, bool) {
 	user, err := app.Repo.GetByUsername(username)
 
 	if err != nil {
 		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
-	if user.ID == "" {
-		// User Does not exist
-		sendResponse("Invalid username of password", "invalid username or password", nil, c, http.StatusBadRequest)
-		return
+	if user.ID == "" || isLocked(*user) {
+		// User Does not exist, or is locked out
+		app.dummyPasswordMatch(password)
+		sendResponse("Invalid username or password", "invalid username or password", nil, c, http.StatusUnauthorized)
+		return nil, false
 	}
 
 	isPasswordMatched, err := app.Repo.PasswordMatch(password, *user)
 
 	if err != nil {
 		sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
 	if !isPasswordMatched {
 		// invalid password
+		err = app.recordFailedLogin(*user)
+
+		if err != nil {
+			log.Println("@LOGIN Failed to record failed login:", err)
+		}
+
 		sendResponse("Invalid username or password", "invalid username or password", nil, c, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return user, true
+}
+
+/*
+firstFactorLogin continues the login of a user who proved who they are with a first factor, like the password.
+Users with MFA get an MFA token to exchange along with their second factor for the session, the others the session.
+*/
+func (app *Config) firstFactorLogin(c *gin.Context, user *data.User) {
+	methods, err := app.mfaMethods(*user)
+
+	if err != nil {
+		sendResponse("Failed to get MFA methods", err.Error(), nil, c, http.StatusInternalServerError)
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": user.ID,
-		"exp":    time.Now().Add(time.Hour).Unix(),
-	})
+	if len(methods) > 0 {
+		// the failed logins are only reset once the code is verified too, so that the code can not be guessed
+		mfaToken, err := app.newMFAChallenge(*user)
+
+		if err != nil {
+			sendResponse("Failed to create MFA token", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
 
-	tokenString, err := token.SignedString([]byte(JWT_SECRET))
+		sendResponse("MFA required", "", map[string]any{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"mfa_methods":  methods,
+		}, c, http.StatusOK)
+		return
+	}
+
+	app.completeLogin(c, user)
+}
+
+/*
+completeLogin clears the failed logins of the user who just proved who they are and sets the session in the cookie.
+It tells the admins who are required to enroll in MFA to do so, until then they can not use the other endpoints.
+*/
+func (app *Config) completeLogin(c *gin.Context, user *data.User) {
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		err := app.Repo.ResetFailedLogins(*user)
+
+		if err != nil {
+			sendResponse("Failed to reset failed logins", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+	}
+
+	enrollmentRequired, err := app.mfaEnrollmentRequired(*user)
 
 	if err != nil {
-		sendResponse("Failed to create JWT Token", err.Error(), nil, c, http.StatusInternalServerError)
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
 		return
 	}
 
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Authorization", tokenString, 3600, "", "", false, true)
+	err = app.startSession(c, *user)
+
+	if err != nil {
+		sendResponse("Failed to create session", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
 
-	sendResponse("User Signed in", "", map[string]any{
+	response := map[string]any{
 		"user": user,
-	}, c, http.StatusOK)
+	}
+
+	if enrollmentRequired {
+		response["mfa_enrollment_required"] = true
+	}
+
+	sendResponse("User Signed in", "", response, c, http.StatusOK)
 }
 
 /*
 Logout is a handler that takes the JWT token from the cookie and set it to empty string.
+If the JWT token is still valid, it is revoked on the server so that it can not be used anymore.
+If a refresh token is present, its whole family is revoked so that it can not be used to get a new access token.
 */
 func (app *Config) logout(c *gin.Context) {
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Authorization", "", -1, "", "", false, true)
+	authTokenString, err := c.Cookie("Authorization")
+
+	if err == nil && authTokenString != "" {
+		// an invalid or expired token does not need to be revoked
+		if claims, err := app.parseAccessToken(authTokenString); err == nil {
+			err = app.Revocations.Revoke(claims.ID, claims.ExpiresAt)
+
+			if err != nil {
+				sendResponse("Failed to revoke auth token", err.Error(), nil, c, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	refreshTokenString, err := c.Cookie(refreshTokenCookie)
+
+	if err == nil && refreshTokenString != "" {
+		storedToken, err := app.Repo.GetRefreshToken(hashToken(refreshTokenString))
+
+		if err != nil {
+			sendResponse("Error while getting refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if storedToken.ID != "" {
+			err = app.Repo.RevokeRefreshTokenFamily(storedToken.FamilyID)
+
+			if err != nil {
+				sendResponse("Failed to revoke refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	clearSessionCookies(c)
 
 	sendResponse("Logged out successfully", "", nil, c, http.StatusOK)
 }
@@ -108,14 +224,7 @@ func (app *Config) logout(c *gin.Context) {
 AllUsers is a handler that return all other users in the organization.
 */
 func (app *Config) allUsers(c *gin.Context) {
-	userId, _ := c.Get("userId")
-
-	user, err := app.Repo.GetByID(userId.(string))
-
-	if err != nil {
-		sendResponse("User does not exist", err.Error(), nil, c, http.StatusBadRequest)
-		return
-	}
+	user := currentUser(c)
 
 	users, err := app.Repo.GetAllOtherUsersInOrg(*user)
 
@@ -131,29 +240,21 @@ func (app *Config) allUsers(c *gin.Context) {
 
 /*
 AddUser is a handler that takes the username and password from the request body and add a new user in the organization.
-It can only be called by an admin.
+It can only be called by a user with the users:create permission.
+Deprecated: the admin chooses the password of the user, the invitations let the user set it instead.
 */
 func (app *Config) addUser(c *gin.Context) {
-	currentUserId, _ := c.Get("userId")
-
-	currentUser, err := app.Repo.GetByID(currentUserId.(string))
+	currentUser := currentUser(c)
 
-	if err != nil {
-		sendResponse("User does not exist", err.Error(), nil, c, http.StatusBadRequest)
-		return
-	}
-
-	if currentUser.Role != "admin" {
-		sendResponse("Not Authorized", "not authorized", nil, c, http.StatusUnauthorized)
-		return
-	}
+	c.Header("Deprecation", "true")
+	c.Header("Link", `</v1/invitations>; rel="successor-version"`)
 
 	var reqPayload struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
 
-	err = c.Bind(&reqPayload)
+	err := c.Bind(&reqPayload)
 
 	if err != nil {
 		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
@@ -175,9 +276,24 @@ func (app *Config) addUser(c *gin.Context) {
 		Role:           "member",
 	}
 
+	policy, err := app.passwordPolicy(currentUser.OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkPassword(c, policy, userToAdd, password) {
+		return
+	}
+
 	err = app.Repo.Insert(userToAdd)
 
 	if err != nil {
+		if errors.Is(err, data.ErrDuplicateUsername) {
+			sendResponse("Username already taken", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
 		sendResponse("Failed to add new user", err.Error(), nil, c, http.StatusInternalServerError)
 		return
 	}
@@ -189,28 +305,17 @@ func (app *Config) addUser(c *gin.Context) {
 
 /*
 DeleteUser is a handler that takes the username from the request body and delete the user from the organization.
-It can only be called by an admin.
+It can only be called by a user with the users:delete permission, who holds every permission of the role of the user,
+like for taking the role away, see canGrantRole.
 */
 func (app *Config) deleteUser(c *gin.Context) {
-	currentUserId, _ := c.Get("userId")
-
-	currentUser, err := app.Repo.GetByID(currentUserId.(string))
-
-	if err != nil {
-		sendResponse("Failed to get user", err.Error(), nil, c, http.StatusBadRequest)
-		return
-	}
-
-	if currentUser.Role != "admin" {
-		sendResponse("Not Authorized", "not authorized", nil, c, http.StatusUnauthorized)
-		return
-	}
+	currentUser := currentUser(c)
 
 	var reqPayload struct {
 		Username string `json:"username"`
 	}
 
-	err = c.Bind(&reqPayload)
+	err := c.Bind(&reqPayload)
 
 	if err != nil {
 		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
@@ -236,12 +341,57 @@ func (app *Config) deleteUser(c *gin.Context) {
 		return
 	}
 
+	role, err := app.Repo.GetRole(userToDelete.OrganizationID, userToDelete.Role)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := app.canGrantRole(c, *role)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !allowed {
+		sendResponse("Not Authorized", "role "+role.Name+" grants more than your permissions", nil, c, http.StatusForbidden)
+		return
+	}
+
 	err = app.Repo.Delete(*userToDelete)
 
 	if err != nil {
-		sendResponse("Failed to delete user", err.Error(), nil, c, http.StatusBadRequest)
+		if errors.Is(err, data.ErrLastAdmin) {
+			sendResponse("Can not delete the last admin of the organization", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to delete user", err.Error(), nil, c, http.StatusInternalServerError)
 		return
 	}
 
 	sendResponse("Successfully delete user from organization", "", nil, c, http.StatusOK)
 }
+
+/*
+userFromPath returns the user from the `id` path parameter, if it belongs to the organization of the current user.
+Otherwise it sends the error response and returns false.
+*/
+func (app *Config) userFromPath(c *gin.Context) (*data.User, bool) {
+	currentUser := currentUser(c)
+
+	user, err := app.Repo.GetByID(c.Param("id"))
+
+	if err != nil {
+		sendResponse("Failed to get user", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if user.ID == "" || currentUser.OrganizationID != user.OrganizationID {
+		sendResponse("Not Authorized", "not authorized", nil, c, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return user, true
+}
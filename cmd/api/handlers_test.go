@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 /*
@@ -369,16 +370,108 @@ func Test_DeleteUserBadRequest_MissingCred(t *testing.T) {
 Function to get a dummy JWT Token for testing Handlers
 */
 func getJWTTestToken() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": "test-user-id",
+	return signJWTTestToken(testApp.Keys)
+}
+
+/*
+Function to get a dummy JWT Token signed by the current key of the key ring
+*/
+func signJWTTestToken(keys *KeyRing) (string, error) {
+	return signJWTTestTokenFor(keys, "test-user-id")
+}
+
+/*
+Function to get a dummy JWT Token of the user, signed by the current key of the key ring
+*/
+func signJWTTestTokenFor(keys *KeyRing, userID string) (string, error) {
+	tokenString, err := keys.Sign(jwt.MapClaims{
+		"userId": userID,
+		"jti":    uuid.NewString(),
+		"iat":    time.Now().Unix(),
 		"exp":    time.Now().Add(time.Hour).Unix(),
 	})
 
-	tokenString, err := token.SignedString([]byte(JWT_SECRET))
-
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+/*
+Testing  POST /v1/add
+
+	-> Username already taken
+*/
+func Test_AddUserConflict(t *testing.T) {
+	jwtToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Errorf("Failed to create test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/add", `{"username":"test-username","password":"user-password"}`, jwtToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
+
+/*
+Testing  DELETE /v1/delete with a custom role
+
+	-> A user of a custom role with users:delete can not delete an admin
+	-> They can delete a user whose role's permissions they hold
+*/
+func Test_DeleteUserEscalation(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/roles", `{"name":"user-deleter","permissions":["users:read","users:delete"]}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	defer serve(router, http.MethodDelete, "/v1/roles/user-deleter", "", adminToken)
+
+	deleterToken, err := signJWTTestTokenFor(testApp.Keys, "user-deleter-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/delete", `{"username":"last-admin"}`, deleterToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/delete", `{"username":"test-username"}`, deleterToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing  DELETE /v1/delete
+
+	-> Deleting the last admin of the organization
+*/
+func Test_DeleteUserLastAdmin(t *testing.T) {
+	jwtToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Errorf("Failed to create test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodDelete, "/v1/delete", `{"username":"last-admin"}`, jwtToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+introspect is the token introspection endpoint (RFC 7662), which lets the resource servers ask whether a token is active.
+Only the confidential clients can call it, and only learn about the tokens of their own organization.
+The access tokens are checked like AuthorizationMiddleware does, so the revoked ones and the ones of deleted users
+and clients are not active. A refresh token is only active for the client it was issued to.
+The token_type_hint is not needed, the access tokens are JWTs and the refresh tokens are not.
+*/
+func (app *Config) introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := app.authenticateClient(c)
+
+	if !ok {
+		return
+	}
+
+	if !client.Confidential {
+		sendOAuthError(c, http.StatusUnauthorized, "invalid_client", "only confidential clients can introspect tokens")
+		return
+	}
+
+	token := c.PostForm("token")
+
+	if token == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	var response gin.H
+	var err error
+
+	if claims, parseErr := app.parseAccessToken(token); parseErr == nil {
+		response, err = app.introspectAccessToken(*claims)
+	} else {
+		response, err = app.introspectRefreshToken(*client, token)
+	}
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if response == nil || response["org_id"] != client.OrganizationID {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+introspectAccessToken returns the introspection response of a valid access token, or nil if its user or its client
+does not exist anymore. The scopes of a machine client are limited to the ones still registered for it.
+*/
+func (app *Config) introspectAccessToken(claims accessTokenClaims) (gin.H, error) {
+	response := gin.H{
+		"active":     true,
+		"token_type": "Bearer",
+		"sub_type":   claims.SubjectType,
+		"jti":        claims.ID,
+		"iat":        claims.IssuedAt.Unix(),
+		"exp":        claims.ExpiresAt.Unix(),
+		"iss":        app.Issuer,
+	}
+
+	scopes := claims.Scopes
+
+	if claims.ClientID != "" {
+		tokenClient, err := app.Repo.GetOAuthClient(claims.ClientID)
+
+		if err != nil || tokenClient.ID == "" {
+			return nil, err
+		}
+
+		response["client_id"] = tokenClient.ID
+
+		if claims.SubjectType == subjectClient {
+			if !tokenClient.HasGrantType(data.GrantClientCredentials) {
+				return nil, nil
+			}
+
+			registered := []string{}
+			for _, scope := range scopes {
+				if hasScope(tokenClient.Scopes, scope) {
+					registered = append(registered, scope)
+				}
+			}
+
+			scopes = registered
+			response["sub"] = tokenClient.ID
+			response["org_id"] = tokenClient.OrganizationID
+		}
+	}
+
+	if claims.SubjectType == subjectUser {
+		user, err := app.Repo.GetByID(claims.UserID)
+
+		if err != nil || user.ID == "" {
+			return nil, err
+		}
+
+		response["sub"] = user.ID
+		response["org_id"] = user.OrganizationID
+		response["username"] = user.Username
+	}
+
+	// the tokens of the login sessions have no scope
+	if scopes != nil {
+		response["scope"] = strings.Join(scopes, " ")
+	}
+
+	return response, nil
+}
+
+/*
+introspectRefreshToken returns the introspection response of a refresh token issued to the client,
+or nil if it was not, or is used, revoked or expired.
+*/
+func (app *Config) introspectRefreshToken(client data.OAuthClient, token string) (gin.H, error) {
+	storedToken, err := app.Repo.GetRefreshToken(hashToken(token))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if storedToken.ID == "" || storedToken.ClientID != client.ID || storedToken.UsedAt != nil ||
+		storedToken.RevokedAt != nil || time.Now().After(storedToken.ExpiresAt) {
+		return nil, nil
+	}
+
+	user, err := app.Repo.GetByID(storedToken.UserID)
+
+	if err != nil || user.ID == "" {
+		return nil, err
+	}
+
+	return gin.H{
+		"active":    true,
+		"sub":       user.ID,
+		"sub_type":  subjectUser,
+		"org_id":    user.OrganizationID,
+		"username":  user.Username,
+		"client_id": client.ID,
+		"scope":     storedToken.Scope,
+		"iat":       storedToken.CreatedAt.Unix(),
+		"exp":       storedToken.ExpiresAt.Unix(),
+		"iss":       app.Issuer,
+	}, nil
+}
+
+/*
+revoke is the token revocation endpoint (RFC 7009), which lets a client revoke its own tokens, like on a logout.
+Revoking a refresh token revokes its whole family along with the access tokens of the session, revoking an access
+token only revokes it. As the RFC asks, the unknown tokens and the tokens of other clients get a 200 too, and are left alone.
+*/
+func (app *Config) revoke(c *gin.Context) {
+	client, ok := app.authenticateClient(c)
+
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+
+	if token == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	if claims, err := app.parseAccessToken(token); err == nil {
+		if claims.ClientID == client.ID {
+			err = app.Revocations.Revoke(claims.ID, claims.ExpiresAt)
+
+			if err != nil {
+				sendOAuthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+				return
+			}
+		}
+
+		c.Status(http.StatusOK)
+		return
+	}
+
+	storedToken, err := app.Repo.GetRefreshToken(hashToken(token))
+
+	if err != nil {
+		sendOAuthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+		return
+	}
+
+	if storedToken.ID != "" && storedToken.ClientID == client.ID && storedToken.RevokedAt == nil {
+		err = app.revokeSession(storedToken.FamilyID)
+
+		if err != nil {
+			log.Println("@OAUTH Failed to revoke the session of a refresh token:", err)
+			sendOAuthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
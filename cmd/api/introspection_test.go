@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+/*
+Testing the token introspection and revocation endpoints
+
+	-> Only the confidential clients can introspect, an unknown token is not active
+	-> The access tokens of the users, of the login sessions and of the machine clients are active with their claims
+	-> A refresh token is active for its client until it is revoked
+	-> A revoked access token is not active anymore, and is refused by the API too
+*/
+func Test_OAuthIntrospection(t *testing.T) {
+	adminToken, err := signJWTTestTokenFor(testApp.Keys, "introspect-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"Resource","redirect_uris":["`+testRedirectURI+`"],"scopes":["users:read"],`+
+			`"grant_types":["authorization_code","refresh_token","client_credentials"],"confidential":true}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	response := responseData(t, reqRecorder)
+	client, _ := response["client"].(map[string]any)
+	clientID, _ := client["id"].(string)
+	secret, _ := response["client_secret"].(string)
+
+	introspect := func(token string) map[string]any {
+		reqRecorder := serveForm(router, "/oauth/introspect", url.Values{
+			"token": {token}, "client_id": {clientID}, "client_secret": {secret},
+		})
+
+		if reqRecorder.Code != http.StatusOK {
+			t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+		}
+
+		return oauthTokens(t, reqRecorder)
+	}
+
+	publicClientID := createTestOAuthClient(t, `["users:read"]`)
+
+	reqRecorder = serveForm(router, "/oauth/introspect", url.Values{"token": {adminToken}, "client_id": {publicClientID}})
+
+	if reqRecorder.Code != http.StatusUnauthorized || oauthErrorCode(t, reqRecorder) != "invalid_client" {
+		t.Errorf("FAILED: Expected invalid_client for a public client get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	if result := introspect("unknown-token"); result["active"] != false || len(result) != 1 {
+		t.Errorf("FAILED: Expected an inactive token get %v", result)
+	}
+
+	if result := introspect(adminToken); result["active"] != true || result["sub"] != "introspect-admin-id" ||
+		result["org_id"] != "test-org-1" || result["sub_type"] != subjectUser || result["scope"] != nil {
+		t.Errorf("FAILED: Expected the active session token without a scope get %v", result)
+	}
+
+	code := approveTestAuthorization(t, clientID, "users:read", "", adminToken)
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"code_verifier": {testCodeVerifier},
+	})
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+	accessToken, _ := tokens["access_token"].(string)
+	refreshToken, _ := tokens["refresh_token"].(string)
+
+	if result := introspect(accessToken); result["active"] != true || result["sub"] != "introspect-admin-id" ||
+		result["scope"] != "users:read" || result["client_id"] != clientID || result["exp"] == nil {
+		t.Errorf("FAILED: Expected the active access token with its scope get %v", result)
+	}
+
+	if result := introspect(refreshToken); result["active"] != true || result["scope"] != "users:read" {
+		t.Errorf("FAILED: Expected the active refresh token get %v", result)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {secret},
+	})
+
+	clientToken, _ := oauthTokens(t, reqRecorder)["access_token"].(string)
+
+	if result := introspect(clientToken); result["active"] != true || result["sub"] != clientID ||
+		result["sub_type"] != subjectClient || result["org_id"] != "test-org-1" || result["scope"] != "users:read" {
+		t.Errorf("FAILED: Expected the active client token get %v", result)
+	}
+
+	// revoking the access token of a session only revokes that token
+	reqRecorder = serveForm(router, "/oauth/revoke", url.Values{"token": {accessToken}, "client_id": {clientID}, "client_secret": {secret}})
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if result := introspect(accessToken); result["active"] != false {
+		t.Errorf("FAILED: Expected the revoked access token to be inactive get %v", result)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected the revoked access token to be refused get %d", reqRecorder.Code)
+	}
+
+	if result := introspect(refreshToken); result["active"] != true {
+		t.Errorf("FAILED: Expected the refresh token to stay active get %v", result)
+	}
+}
+
+/*
+Testing the revocation of the refresh tokens
+
+	-> A client can not revoke the tokens of another client, the request still succeeds
+	-> A public client revokes its refresh token, which can not be exchanged anymore
+*/
+func Test_OAuthRevocation(t *testing.T) {
+	clientID := createTestOAuthClient(t, `["users:read"]`)
+	otherClientID := createTestOAuthClient(t, `["users:read"]`)
+
+	adminToken, err := signJWTTestTokenFor(testApp.Keys, "revoke-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	code := approveTestAuthorization(t, clientID, "users:read", "", adminToken)
+
+	reqRecorder := exchangeTestCode(clientID, code, testCodeVerifier)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+	accessToken, _ := tokens["access_token"].(string)
+	refreshToken, _ := tokens["refresh_token"].(string)
+
+	reqRecorder = serveForm(router, "/oauth/revoke", url.Values{"token": {refreshToken}, "client_id": {otherClientID}})
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected the tokens of another client to be left alone get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/revoke", url.Values{"client_id": {clientID}})
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_request" {
+		t.Errorf("FAILED: Expected invalid_request get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	reqRecorder = serveForm(router, "/oauth/revoke", url.Values{"token": {refreshToken}, "client_id": {clientID}})
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "client_id": {clientID},
+	})
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_grant" {
+		t.Errorf("FAILED: Expected the revoked refresh token to be refused get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected the access tokens of the session to be revoked too get %d", reqRecorder.Code)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+/*
+inviteUser is a handler that takes the username, the email and the role of a new user from the request body,
+and sends an invitation link to the email to join the organization of the current user. The invitee sets their own password.
+If the link can not be sent, the invitation is still created and the answer is 502 with `sent` false, to resend it.
+Inviting with another role than member also needs the users:update_role permission, and every permission of the role.
+*/
+func (app *Config) inviteUser(c *gin.Context) {
+	currentUser := currentUser(c)
+
+	var reqPayload struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Role     string `json:"role"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Username == "" {
+		sendResponse("Missing Username in request", "missing username in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if reqPayload.Email == "" {
+		sendResponse("Missing Email in request", "missing email in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	email, err := normalizeEmail(reqPayload.Email)
+
+	if err != nil {
+		sendResponse("Invalid email", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if reqPayload.Role == "" {
+		reqPayload.Role = "member"
+	}
+
+	role, err := app.Repo.GetRole(currentUser.OrganizationID, reqPayload.Role)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if role.Name == "" {
+		sendResponse("Role does not exist", "role does not exist", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if role.Name != "member" {
+		held, err := app.heldPermissions(c)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if !hasScope(held, data.PermUsersUpdateRole) {
+			sendResponse("Not Authorized", "missing permission "+data.PermUsersUpdateRole, nil, c, http.StatusForbidden)
+			return
+		}
+
+		allowed, err := app.canGrantRole(c, *role)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if !allowed {
+			sendResponse("Not Authorized", "role "+role.Name+" grants more than your permissions", nil, c, http.StatusForbidden)
+			return
+		}
+	}
+
+	existing, err := app.Repo.GetByUsername(reqPayload.Username)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if existing.ID != "" {
+		sendResponse("Username already taken", data.ErrDuplicateUsername.Error(), nil, c, http.StatusConflict)
+		return
+	}
+
+	pending, err := app.Repo.GetPendingInvitations(currentUser.OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get invitations", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	for _, invitation := range pending {
+		if invitation.Username == reqPayload.Username {
+			sendResponse("User already invited", "user already invited", nil, c, http.StatusConflict)
+			return
+		}
+	}
+
+	token, err := randomToken()
+
+	if err != nil {
+		sendResponse("Failed to create invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	invitation, err := app.Repo.InsertInvitation(data.Invitation{
+		OrganizationID: currentUser.OrganizationID,
+		Username:       reqPayload.Username,
+		Email:          email,
+		Role:           role.Name,
+		InvitedBy:      currentUser.ID,
+		TokenHash:      hashToken(token),
+		ExpiresAt:      time.Now().Add(invitationTTL),
+	})
+
+	if err != nil {
+		sendResponse("Failed to create invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	// the invitation is kept when the link can not be sent, so that it can be resent rather than invited again
+	err = app.sendInvitation(*invitation, token)
+
+	if err != nil {
+		log.Println("@INVITATIONS Failed to send invitation:", err)
+
+		sendResponse("Invited user, but failed to send the invitation, resend it", err.Error(), map[string]any{
+			"invitation": invitation,
+			"sent":       false,
+		}, c, http.StatusBadGateway)
+		return
+	}
+
+	sendResponse("Successfully invited user", "", map[string]any{
+		"invitation": invitation,
+		"sent":       true,
+	}, c, http.StatusOK)
+}
+
+/*
+sendInvitation sends the invitation link to the email of the invitee.
+*/
+func (app *Config) sendInvitation(invitation data.Invitation, token string) error {
+	body := fmt.Sprintf("You are invited to join as %s. Set your password with this link, it is valid for %s:\n\n"+
+		"%s/accept-invitation?token=%s\n\nIf you did not expect it, you can ignore this message.",
+		invitation.Username, invitationTTL, APP_URL, token)
+
+	return app.Notifier.Notify(invitation.Email, "You are invited", body)
+}
+
+/*
+allInvitations is a handler that returns the pending invitations of the organization of the current user.
+*/
+func (app *Config) allInvitations(c *gin.Context) {
+	invitations, err := app.Repo.GetPendingInvitations(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get invitations", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get invitations", "", map[string]any{
+		"invitations": invitations,
+	}, c, http.StatusOK)
+}
+
+/*
+resendInvitation is a handler that sends the invitation from the `id` path parameter again, with a new link
+valid for as long as a new invitation. The previous link stops working.
+If the link can not be sent, the answer is 502 with `sent` false, like for a new invitation.
+*/
+func (app *Config) resendInvitation(c *gin.Context) {
+	invitation, ok := app.invitationFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	token, err := randomToken()
+
+	if err != nil {
+		sendResponse("Failed to resend invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(invitationTTL)
+
+	err = app.Repo.RenewInvitation(*invitation, hashToken(token), expiresAt)
+
+	if err != nil {
+		if errors.Is(err, data.ErrInvitationNotActive) {
+			sendResponse("Invitation is not pending", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to resend invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	invitation.ExpiresAt = expiresAt
+
+	err = app.sendInvitation(*invitation, token)
+
+	if err != nil {
+		log.Println("@INVITATIONS Failed to send invitation:", err)
+
+		sendResponse("Renewed invitation, but failed to send it, resend it", err.Error(), map[string]any{
+			"invitation": invitation,
+			"sent":       false,
+		}, c, http.StatusBadGateway)
+		return
+	}
+
+	sendResponse("Successfully resent invitation", "", map[string]any{
+		"invitation": invitation,
+		"sent":       true,
+	}, c, http.StatusOK)
+}
+
+/*
+revokeInvitation is a handler that revokes the invitation from the `id` path parameter, its link stops working.
+*/
+func (app *Config) revokeInvitation(c *gin.Context) {
+	invitation, ok := app.invitationFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	err := app.Repo.RevokeInvitation(*invitation)
+
+	if err != nil {
+		if errors.Is(err, data.ErrInvitationNotActive) {
+			sendResponse("Invitation is not pending", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to revoke invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully revoked invitation", "", nil, c, http.StatusOK)
+}
+
+/*
+acceptInvitation is a handler that takes the invitation token and the password of the invitee from the request body,
+and creates the user with the username and the role of the invitation. The token can only be used once.
+*/
+func (app *Config) acceptInvitation(c *gin.Context) {
+	var reqPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Token == "" || reqPayload.Password == "" {
+		sendResponse("Missing Token or Password in request", "missing token or password in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := hashToken(reqPayload.Token)
+
+	invitation, err := app.Repo.GetInvitationByToken(tokenHash)
+
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidInvitation) {
+			sendResponse("Invalid or expired invitation", err.Error(), nil, c, http.StatusBadRequest)
+			return
+		}
+		sendResponse("Failed to get invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	policy, err := app.passwordPolicy(invitation.OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkPassword(c, policy, data.User{Username: invitation.Username}, reqPayload.Password) {
+		return
+	}
+
+	user, err := app.Repo.AcceptInvitation(tokenHash, reqPayload.Password)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidInvitation):
+			sendResponse("Invalid or expired invitation", err.Error(), nil, c, http.StatusBadRequest)
+		case errors.Is(err, data.ErrDuplicateUsername):
+			sendResponse("Username already taken", err.Error(), nil, c, http.StatusConflict)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			sendResponse("Email already taken", err.Error(), nil, c, http.StatusConflict)
+		case errors.Is(err, data.ErrInvitationRole):
+			sendResponse("Role of the invitation no longer exists", err.Error(), nil, c, http.StatusConflict)
+		default:
+			sendResponse("Failed to accept invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	sendResponse("Successfully accepted invitation", "", map[string]any{
+		"user": user,
+	}, c, http.StatusOK)
+}
+
+/*
+invitationFromPath returns the invitation from the `id` path parameter, if it belongs to the organization of the current user.
+Otherwise it sends the error response and returns false.
+*/
+func (app *Config) invitationFromPath(c *gin.Context) (*data.Invitation, bool) {
+	invitation, err := app.Repo.GetInvitation(c.Param("id"))
+
+	if err != nil {
+		sendResponse("Failed to get invitation", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if invitation.ID == "" || invitation.OrganizationID != currentUser(c).OrganizationID {
+		sendResponse("Invitation does not exist", "invitation does not exist", nil, c, http.StatusNotFound)
+		return nil, false
+	}
+
+	return invitation, true
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+Testing the invitations, from the invitation to the acceptance
+
+	-> The link is sent to the email of the invitee, and a user can not be invited twice
+	-> A taken username can not be invited, and an invitation takes an email
+	-> A resend sends a new link, the previous one stops working
+	-> The invitee sets their own password, checked against the password policy
+	-> The invitation can only be accepted once, and is no longer pending
+*/
+func Test_InvitationLifecycle(t *testing.T) {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"invitee@example.com"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	invitation, _ := responseData(t, reqRecorder)["invitation"].(map[string]any)
+	id, _ := invitation["id"].(string)
+
+	if invitation["role"] != "member" {
+		t.Errorf("FAILED: Expected the member role get %v", invitation["role"])
+	}
+
+	firstToken := invitationTestToken(t, "invitee@example.com")
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"other@example.com"}`, token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations", `{"username":"test-username","email":"other@example.com"}`, token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations", `{"username":"other-user"}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/invitations", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if !hasTestInvitation(t, reqRecorder, id) {
+		t.Errorf("FAILED: Expected the invitation to be pending")
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/"+id+"/resend", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	secondToken := invitationTestToken(t, "invitee@example.com")
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+firstToken+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+secondToken+`","password":"short"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+secondToken+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	user, _ := responseData(t, reqRecorder)["user"].(map[string]any)
+
+	if user["username"] != "unknown-user" || user["role"] != "member" || user["organization_id"] != "test-org-1" ||
+		user["email"] != "invitee@example.com" || user["email_verified_at"] == nil {
+		t.Errorf("FAILED: Expected the invited user get %v", user)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+secondToken+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/invitations", "", token)
+
+	if hasTestInvitation(t, reqRecorder, id) {
+		t.Errorf("FAILED: Expected the accepted invitation not to be pending")
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/invitations/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the revocation of an invitation
+
+	-> The link of a revoked invitation stops working, and it can not be resent
+	-> The invitations of the organization can not be managed without the users:create permission
+	-> An unknown invitation
+*/
+func Test_InvitationRevoke(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "invitations-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"revoked@example.com","role":"admin"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	invitation, _ := responseData(t, reqRecorder)["invitation"].(map[string]any)
+	id, _ := invitation["id"].(string)
+	invitationToken := invitationTestToken(t, "revoked@example.com")
+
+	memberToken, err := signJWTTestTokenFor(testApp.Keys, "random-test-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/invitations/"+id, "", memberToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/invitations/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+invitationToken+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/"+id+"/resend", "", token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/invitations/unknown-invitation", "", token)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the invitations which can not go through
+
+	-> An invitation whose custom role was deleted since can not be accepted
+	-> An invitation whose link can not be sent is kept, the answer tells it was not sent, for a resend too
+*/
+func Test_InvitationFailures(t *testing.T) {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/roles", `{"name":"invited-role","permissions":["users:read"]}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"invited-role@example.com","role":"invited-role"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	invitation, _ := responseData(t, reqRecorder)["invitation"].(map[string]any)
+	id, _ := invitation["id"].(string)
+	defer serve(router, http.MethodDelete, "/v1/invitations/"+id, "", token)
+
+	invitationToken := invitationTestToken(t, "invited-role@example.com")
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/roles/invited-role", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations/accept", `{"token":"`+invitationToken+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/invitations/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	app := testApp
+	app.Notifier = failingNotifier{}
+
+	reqRecorder = serve(app.routes(), http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"unsent@example.com"}`, token)
+
+	if reqRecorder.Code != http.StatusBadGateway {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusBadGateway, reqRecorder.Code)
+	}
+
+	response := responseData(t, reqRecorder)
+	invitation, _ = response["invitation"].(map[string]any)
+	id, _ = invitation["id"].(string)
+	defer serve(router, http.MethodDelete, "/v1/invitations/"+id, "", token)
+
+	if response["sent"] != false {
+		t.Errorf("FAILED: Expected the invitation not to be sent get %v", response)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/invitations", "", token)
+
+	if !hasTestInvitation(t, reqRecorder, id) {
+		t.Errorf("FAILED: Expected the unsent invitation to be pending")
+	}
+
+	reqRecorder = serve(app.routes(), http.MethodPost, "/v1/invitations/"+id+"/resend", "", token)
+
+	if reqRecorder.Code != http.StatusBadGateway {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusBadGateway, reqRecorder.Code)
+	}
+
+	if response := responseData(t, reqRecorder); response["sent"] != false {
+		t.Errorf("FAILED: Expected the invitation not to be sent get %v", response)
+	}
+}
+
+// failingNotifier can not deliver any message
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(to, subject, body string) error {
+	return errors.New("notifier unavailable")
+}
+
+/*
+Function to get the token of the last invitation link sent to the address
+*/
+func invitationTestToken(t *testing.T, to string) string {
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last(to)
+
+	if !ok || !strings.Contains(message.Body, "/accept-invitation?token=") {
+		t.Fatalf("FAILED: Expected an invitation message to %s", to)
+	}
+
+	_, token, _ := strings.Cut(message.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	return token
+}
+
+/*
+Function to check whether the invitation is in the listed invitations
+*/
+func hasTestInvitation(t *testing.T, reqRecorder *httptest.ResponseRecorder, id string) bool {
+	invitations, _ := responseData(t, reqRecorder)["invitations"].([]any)
+
+	for _, invitation := range invitations {
+		if invitation.(map[string]any)["id"] == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// previous keys keep verifying tokens for this long after they stopped signing
+	keyVerificationPeriod = 24 * time.Hour
+
+	// how often every replica reloads the keys from the store
+	keyReloadInterval = time.Minute
+
+	// a token with an unknown kid triggers a reload, but not more often than this
+	keyReloadOnMissInterval = 10 * time.Second
+)
+
+/*
+KeyRing holds the current key signing new tokens and every key which still verifies tokens, selected by `kid`.
+The keys live in a data.KeyStore shared by all the replicas, every replica reloads them periodically
+and whenever a token is signed by a key it does not know yet.
+*/
+type KeyRing struct {
+	store data.KeyStore
+	alg   string // algorithm of the generated keys
+
+	mu       sync.RWMutex
+	current  *SigningKey
+	keys     map[string]*SigningKey
+	records  []data.JWTKey
+	loadedAt time.Time
+}
+
+/*
+NewKeyRing initializes the store with the initial key, or with a generated one if initial is nil, unless
+the store already has keys, and loads the keys.
+*/
+func NewKeyRing(store data.KeyStore, alg string, initial *SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{
+		store: store,
+		alg:   alg,
+	}
+
+	var err error
+
+	if initial == nil {
+		initial, err = GenerateSigningKey(alg)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := initial.record()
+
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := ring.generateRecord()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.InitKeys(current, next)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ring, ring.Load()
+}
+
+/*
+Load reads the keys from the store.
+*/
+func (r *KeyRing) Load() error {
+	records, err := r.store.GetKeys()
+
+	if err != nil {
+		return err
+	}
+
+	var current *SigningKey
+	keys := make(map[string]*SigningKey, len(records))
+
+	for _, record := range records {
+		key, err := signingKeyFromRecord(record)
+
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.ID, err)
+		}
+
+		keys[record.ID] = key
+
+		if record.Status == data.KeyStatusCurrent {
+			current = key
+		}
+	}
+
+	if current == nil {
+		return errors.New("no current signing key")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current = current
+	r.keys = keys
+	r.records = records
+	r.loadedAt = time.Now()
+
+	return nil
+}
+
+/*
+Current returns the key signing new tokens.
+*/
+func (r *KeyRing) Current() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+/*
+Sign signs the claims with the current key.
+*/
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	return r.Current().Sign(claims)
+}
+
+/*
+Keyfunc is the jwt.Keyfunc which returns the key from the `kid` header of the token.
+If the key is unknown, another replica may have rotated the keys, so they are reloaded before giving up.
+*/
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		return nil, errors.New("missing signing key id")
+	}
+
+	key, loadedAt := r.key(kid)
+
+	if key == nil && time.Since(loadedAt) > keyReloadOnMissInterval {
+		err := r.Load()
+
+		if err != nil {
+			return nil, err
+		}
+
+		key, _ = r.key(kid)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+func (r *KeyRing) key(kid string) (*SigningKey, time.Time) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.keys[kid], r.loadedAt
+}
+
+/*
+Records returns the keys as stored, without their private part.
+*/
+func (r *KeyRing) Records() []data.JWTKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]data.JWTKey, len(r.records))
+	copy(records, r.records)
+	return records
+}
+
+/*
+JWKS returns the public keys of the ring as JSON Web Keys, including the next key which does not sign yet.
+*/
+func (r *KeyRing) JWKS() []map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []map[string]string{}
+
+	for _, record := range r.records {
+		if jwk, ok := r.keys[record.ID].JWK(); ok {
+			keys = append(keys, jwk)
+		}
+	}
+
+	return keys
+}
+
+/*
+Rotate rotates the keys if the current key was activated before dueBefore, and returns whether it did.
+*/
+func (r *KeyRing) Rotate(dueBefore time.Time) (bool, error) {
+	if !r.rotationDue(dueBefore) {
+		return false, nil
+	}
+
+	next, err := r.generateRecord()
+
+	if err != nil {
+		return false, err
+	}
+
+	rotated, err := r.store.RotateKeys(next, dueBefore)
+
+	if err != nil {
+		return false, err
+	}
+
+	return rotated, r.Load()
+}
+
+// rotationDue checks the loaded keys first, so that no key is generated when the rotation is not due
+func (r *KeyRing) rotationDue(dueBefore time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, record := range r.records {
+		if record.Status == data.KeyStatusCurrent && record.ActivatedAt != nil {
+			return record.ActivatedAt.Before(dueBefore)
+		}
+	}
+	return true
+}
+
+/*
+Retire retires a previous key, tokens signed by it are not accepted anymore.
+*/
+func (r *KeyRing) Retire(kid string) error {
+	err := r.store.RetireKey(kid)
+
+	if err != nil {
+		return err
+	}
+
+	return r.Load()
+}
+
+/*
+Maintain reloads the keys, retires the previous keys past their verification period and
+rotates the keys every rotationInterval (never if it is 0). It runs until the program exits.
+*/
+func (r *KeyRing) Maintain(rotationInterval time.Duration) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := r.store.RetireKeysRotatedBefore(time.Now().Add(-keyVerificationPeriod))
+
+		if err != nil {
+			log.Println("@KEYS Failed to retire signing keys:", err)
+		}
+
+		if rotationInterval > 0 {
+			rotated, err := r.Rotate(time.Now().Add(-rotationInterval))
+
+			if err != nil {
+				log.Println("@KEYS Failed to rotate signing keys:", err)
+			} else if rotated {
+				log.Println("@KEYS Rotated signing keys, now signing with", r.Current().ID)
+			}
+		}
+
+		err = r.Load()
+
+		if err != nil {
+			log.Println("@KEYS Failed to reload signing keys:", err)
+		}
+	}
+}
+
+func (r *KeyRing) generateRecord() (data.JWTKey, error) {
+	key, err := GenerateSigningKey(r.alg)
+
+	if err != nil {
+		return data.JWTKey{}, err
+	}
+
+	return key.record()
+}
+
+/*
+jwks is a handler that publishes the public signing keys as a JSON Web Key Set,
+so that other services can verify our tokens offline.
+*/
+func (app *Config) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": app.Keys.JWKS(),
+	})
+}
+
+/*
+listKeys is a handler that lists the signing keys which are not retired.
+It can only be called by a platform admin.
+*/
+func (app *Config) listKeys(c *gin.Context) {
+	sendResponse("Successfully get signing keys", "", map[string]any{
+		"keys": app.Keys.Records(),
+	}, c, http.StatusOK)
+}
+
+/*
+rotateKeys is a handler that rotates the signing keys right away.
+It can only be called by a platform admin.
+*/
+func (app *Config) rotateKeys(c *gin.Context) {
+	_, err := app.Keys.Rotate(time.Now().Add(time.Hour))
+
+	if err != nil {
+		sendResponse("Failed to rotate signing keys", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully rotated signing keys", "", map[string]any{
+		"keys": app.Keys.Records(),
+	}, c, http.StatusOK)
+}
+
+/*
+retireKey is a handler that retires a previous signing key, tokens signed by it are not accepted anymore.
+It can only be called by a platform admin.
+*/
+func (app *Config) retireKey(c *gin.Context) {
+	err := app.Keys.Retire(c.Param("kid"))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrKeyNotFound):
+			sendResponse("Signing key does not exist", err.Error(), nil, c, http.StatusNotFound)
+		case errors.Is(err, data.ErrKeyInUse):
+			sendResponse("Signing key is in use", err.Error(), nil, c, http.StatusConflict)
+		default:
+			sendResponse("Failed to retire signing key", err.Error(), nil, c, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	sendResponse("Successfully retired signing key", "", nil, c, http.StatusOK)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Testing POST /v1/keys/rotate and DELETE /v1/keys/:kid
+
+	-> Tokens signed before the rotation stay valid until their key is retired
+	-> New tokens are signed by the new current key
+	-> Current key can not be retired
+*/
+func Test_KeyRotation(t *testing.T) {
+	testRouter, keys := newTestRouter(t, algES256)
+
+	oldKid := keys.Current().ID
+
+	adminToken, err := signJWTTestToken(keys)
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(testRouter, http.MethodPost, "/v1/keys/rotate", "", adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if keys.Current().ID == oldKid {
+		t.Fatal("FAILED: Current key was not rotated")
+	}
+
+	// signed by the previous key
+	reqRecorder = serve(testRouter, http.MethodGet, "/v1/keys", "", adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	var response struct {
+		Data struct {
+			Keys []data.JWTKey `json:"keys"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &response)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	statuses := map[string]string{}
+	for _, key := range response.Data.Keys {
+		statuses[key.ID] = key.Status
+	}
+
+	if len(statuses) != 3 || statuses[oldKid] != data.KeyStatusPrevious || statuses[keys.Current().ID] != data.KeyStatusCurrent {
+		t.Errorf("FAILED: Expected previous, current and next keys get %v", statuses)
+	}
+
+	newAdminToken, err := signJWTTestToken(keys)
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(testRouter, http.MethodDelete, "/v1/keys/"+keys.Current().ID, "", newAdminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(testRouter, http.MethodDelete, "/v1/keys/"+oldKid, "", newAdminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(testRouter, http.MethodGet, "/v1/keys", "", adminToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(testRouter, http.MethodDelete, "/v1/keys/unknown-kid", "", newAdminToken)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing GET /v1/keys
+
+	-> Only platform admins can manage the keys
+*/
+func Test_KeysNotPlatformAdmin(t *testing.T) {
+	keys, err := NewKeyRing(data.NewMemoryKeyStore(), algHS256, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %s", err.Error())
+	}
+
+	testApp := Config{
+		Repo:        data.NewPostgresTestRepository(nil),
+		Revocations: data.NewMemoryRevocationStore(),
+		Keys:        keys, // no PlatformAdmins
+	}
+
+	adminToken, err := signJWTTestToken(keys)
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(testApp.routes(), http.MethodGet, "/v1/keys", "", adminToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the encryption of the private keys at rest
+
+	-> The store only gets the private keys encrypted, and the key ring still signs with them
+	-> The keys can not be loaded without the key encryption key, nor with another one
+*/
+func Test_EncryptedKeyStore(t *testing.T) {
+	store := data.NewMemoryKeyStore()
+	encryptionKey := []byte("0123456789abcdef0123456789abcdef")
+
+	encrypted, err := data.NewEncryptedKeyStore(store, encryptionKey)
+
+	if err != nil {
+		t.Fatalf("Failed to create encrypted key store: %s", err.Error())
+	}
+
+	keys, err := NewKeyRing(encrypted, algES256, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %s", err.Error())
+	}
+
+	_, err = keys.Rotate(time.Now().Add(time.Hour))
+
+	if err != nil {
+		t.Fatalf("Failed to rotate keys: %s", err.Error())
+	}
+
+	stored, _ := store.GetKeys()
+
+	for _, key := range stored {
+		if !strings.HasPrefix(key.PrivateKey, data.EncryptedKeyPrefix) || strings.Contains(key.PrivateKey, "PRIVATE KEY") {
+			t.Errorf("FAILED: Expected the private key of %s to be encrypted get %q", key.ID, key.PrivateKey)
+		}
+	}
+
+	token, err := signJWTTestToken(keys)
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	if _, err := jwt.Parse(token, keys.Keyfunc); err != nil {
+		t.Errorf("FAILED: Expected the token to be verified get %s", err.Error())
+	}
+
+	if _, err := NewKeyRing(store, algES256, nil); !errors.Is(err, data.ErrKeyEncryptionKey) {
+		t.Errorf("FAILED: Expected the keys to need the key encryption key get %v", err)
+	}
+
+	other, _ := data.NewEncryptedKeyStore(store, []byte("fedcba9876543210fedcba9876543210"))
+
+	if _, err := NewKeyRing(other, algES256, nil); !errors.Is(err, data.ErrKeyEncryptionKey) {
+		t.Errorf("FAILED: Expected another key encryption key to be refused get %v", err)
+	}
+
+	if _, err := data.NewEncryptedKeyStore(store, []byte("short")); err == nil {
+		t.Errorf("FAILED: Expected a short key encryption key to be refused")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Supported values of JWT_SIGNING_ALG
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algES256 = "ES256"
+	algEdDSA = "EdDSA"
+)
+
+/*
+SigningKey is the key used to sign and verify the JWT tokens.
+
+For HS256 it is a shared secret, for RS256, ES256 and EdDSA it is a private key whose public part
+is published on /.well-known/jwks.json, so that other services can verify our tokens without holding any secret.
+The ID of the key is sent in the `kid` header of every token, the KeyRing uses it to find the key to verify with.
+*/
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   any // []byte for HMAC, crypto.Signer otherwise
+	verifyKey any // []byte for HMAC, crypto.PublicKey otherwise
+}
+
+/*
+NewHMACKey returns a HS256 SigningKey for the shared secret.
+*/
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+/*
+NewSigningKey returns a SigningKey for the private key, the signing method is chosen from the type of the key.
+The ID of the key is its RFC 7638 JWK thumbprint.
+*/
+func NewSigningKey(privateKey crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{
+		signKey:   privateKey,
+		verifyKey: privateKey.Public(),
+	}
+
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("rsa signing key must be at least 2048 bits")
+		}
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ecdsa signing key must use the P-256 curve")
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", privateKey)
+	}
+
+	thumbprint, err := key.thumbprint()
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = thumbprint
+	return key, nil
+}
+
+/*
+GenerateSigningKey generates a new private key for the algorithm.
+*/
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch alg {
+	case algHS256:
+		secret := make([]byte, 32)
+
+		_, err = rand.Read(secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewHMACKey(uuid.NewString(), secret), nil
+	case algRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("can not generate a key for signing algorithm %q", alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(privateKey)
+}
+
+/*
+signingKeyFromRecord returns the SigningKey for a key stored in the database.
+*/
+func signingKeyFromRecord(record data.JWTKey) (*SigningKey, error) {
+	if strings.HasPrefix(record.PrivateKey, data.EncryptedKeyPrefix) {
+		return nil, data.ErrKeyEncryptionKey
+	}
+
+	if record.Algorithm == algHS256 {
+		secret, err := base64.StdEncoding.DecodeString(record.PrivateKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewHMACKey(record.ID, secret), nil
+	}
+
+	privateKey, err := parsePrivateKey([]byte(record.PrivateKey))
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewSigningKey(privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = record.ID
+	return key, nil
+}
+
+/*
+record returns the key as stored in the database.
+*/
+func (k *SigningKey) record() (data.JWTKey, error) {
+	record := data.JWTKey{
+		ID:        k.ID,
+		Algorithm: k.Method.Alg(),
+	}
+
+	if secret, ok := k.signKey.([]byte); ok {
+		record.PrivateKey = base64.StdEncoding.EncodeToString(secret)
+		return record, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.signKey)
+
+	if err != nil {
+		return data.JWTKey{}, err
+	}
+
+	record.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return record, nil
+}
+
+/*
+LoadSigningKey reads a PEM encoded private key (PKCS #8, PKCS #1 or SEC 1) and checks that it matches the algorithm.
+*/
+func LoadSigningKey(alg, path string) (*SigningKey, error) {
+	pemBytes, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := parsePrivateKey(pemBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewSigningKey(privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("signing key in %s is a %s key, expected %s", path, key.Method.Alg(), alg)
+	}
+
+	return key, nil
+}
+
+func parsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var privateKey any
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	return signer, nil
+}
+
+/*
+Sign signs the claims with the key and sets the `kid` header.
+*/
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+
+	return token.SignedString(k.signKey)
+}
+
+/*
+JWK returns the public key as a JSON Web Key (RFC 7517).
+HMAC keys are secret and have no public JWK, for them ok is false.
+*/
+func (k *SigningKey) JWK() (jwk map[string]string, ok bool) {
+	jwk = k.publicJWK()
+
+	if jwk == nil {
+		return nil, false
+	}
+
+	jwk["kid"] = k.ID
+	jwk["alg"] = k.Method.Alg()
+	jwk["use"] = "sig"
+	return jwk, true
+}
+
+// publicJWK returns only the members of the JWK which describe the public key
+func (k *SigningKey) publicJWK() map[string]string {
+	switch publicKey := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": publicKey.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(publicKey),
+		}
+	}
+	return nil
+}
+
+/*
+thumbprint returns the RFC 7638 JWK thumbprint of the public key.
+encoding/json sorts the keys of a map, which gives the lexicographic order required by the RFC.
+*/
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk := k.publicJWK()
+
+	if jwk == nil {
+		return "", errors.New("no public key to compute the thumbprint of")
+	}
+
+	jwkBytes, err := json.Marshal(jwk)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(jwkBytes)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+/*
+Testing asymmetric signing keys
+
+	-> Tokens signed by login are accepted by the AuthorizationMiddleware
+	-> The public key is published on /.well-known/jwks.json
+	-> HS256 tokens signed with JWT_SECRET are rejected
+*/
+func Test_AsymmetricSigningKeys(t *testing.T) {
+	for _, alg := range []string{algRS256, algES256, algEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			testRouter, keys := newTestRouter(t, alg)
+
+			reqRecorder := serve(testRouter, http.MethodPost, "/v1/login", `{"username":"username","password":"password"}`, "")
+
+			if reqRecorder.Code != http.StatusOK {
+				t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+			}
+
+			accessToken := getCookie(reqRecorder, "Authorization")
+
+			reqRecorder = serve(testRouter, http.MethodGet, "/v1/users", "", accessToken)
+
+			if reqRecorder.Code != http.StatusOK {
+				t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+			}
+
+			// same claims, signed with JWT_SECRET and claiming to be the current key
+			hmacKey := NewHMACKey(keys.Current().ID, []byte(JWT_SECRET))
+			hmacToken, err := hmacKey.Sign(jwt.MapClaims{
+				"userId": "test-user-id",
+				"jti":    uuid.NewString(),
+				"iat":    time.Now().Unix(),
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			})
+
+			if err != nil {
+				t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+			}
+
+			reqRecorder = serve(testRouter, http.MethodGet, "/v1/users", "", hmacToken)
+
+			if reqRecorder.Code != http.StatusUnauthorized {
+				t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+			}
+
+			reqRecorder = serve(testRouter, http.MethodGet, "/.well-known/jwks.json", "", "")
+
+			var jwks struct {
+				Keys []map[string]string `json:"keys"`
+			}
+
+			err = json.Unmarshal(reqRecorder.Body.Bytes(), &jwks)
+
+			if err != nil {
+				t.Fatalf("Failed to unmarshal JWKS: %s", err.Error())
+			}
+
+			// the current key and the next key are published
+			if len(jwks.Keys) != 2 || jwks.Keys[0]["kid"] != keys.Current().ID || jwks.Keys[0]["alg"] != alg {
+				t.Errorf("FAILED: Expected JWKS with key %s get %v", keys.Current().ID, jwks.Keys)
+			}
+		})
+	}
+}
+
+/*
+Testing GET /.well-known/jwks.json
+
+	-> HS256 keys are secret and never published
+*/
+func Test_JWKSWithoutPublicKeys(t *testing.T) {
+	reqRecorder := serve(router, http.MethodGet, "/.well-known/jwks.json", "", "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if !bytes.Equal(reqRecorder.Body.Bytes(), []byte(`{"keys":[]}`)) {
+		t.Errorf("FAILED: Expected empty JWKS get %s", reqRecorder.Body.String())
+	}
+}
+
+/*
+Testing LoadSigningKey
+
+	-> PKCS #8 PEM file with a P-256 key
+	-> Key type does not match the algorithm
+*/
+func Test_LoadSigningKey(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err.Error())
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "signing-key.pem")
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write key: %s", err.Error())
+	}
+
+	key, err := LoadSigningKey(algES256, path)
+
+	if err != nil {
+		t.Fatalf("FAILED: Expected key to load get %s", err.Error())
+	}
+
+	if key.Method.Alg() != algES256 || key.ID == "" {
+		t.Errorf("FAILED: Expected ES256 key with an ID get %s %q", key.Method.Alg(), key.ID)
+	}
+
+	_, err = LoadSigningKey(algRS256, path)
+
+	if err == nil {
+		t.Error("FAILED: Expected error loading an ES256 key as RS256")
+	}
+}
+
+/*
+Function to create a router backed by the mock repository and its own key ring generating alg keys
+*/
+func newTestRouter(t *testing.T, alg string) (*gin.Engine, *KeyRing) {
+	keys, err := NewKeyRing(data.NewMemoryKeyStore(), alg, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create %s key ring: %s", alg, err.Error())
+	}
+
+	testApp := Config{
+		Repo:           data.NewPostgresTestRepository(nil),
+		Revocations:    data.NewMemoryRevocationStore(),
+		Keys:           keys,
+		PlatformAdmins: []string{"test-username"},
+		Notifier:       NewMemoryNotifier(),
+	}
+
+	return testApp.routes(), keys
+}
+
+/*
+Function to send a request with an optional JSON body and an optional access token in the Authorization cookie
+*/
+func serve(testRouter *gin.Engine, method, url, body, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader([]byte(body)))
+
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if accessToken != "" {
+		req.Header.Set("Cookie", fmt.Sprintf("Authorization=%s", accessToken))
+	}
+
+	reqRecorder := httptest.NewRecorder()
+
+	testRouter.ServeHTTP(reqRecorder, req)
+
+	return reqRecorder
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	errInvalidLink     = errors.New("invalid or expired link")
+	errNoVerifiedEmail = errors.New("user has no verified email to send to")
+)
+
+/*
+newSignedLink creates the token of a link sent to the user, like a login link. It is signed like the access tokens
+and bound to the user, and its id is stored as a one time token of the purpose, so that the link can only be used once.
+A new login link replaces the previous ones of the user, like for the password reset, so that a single one can log in.
+The claims are added to the token, to be checked when the link is used.
+*/
+func (app *Config) newSignedLink(user data.User, purpose string, ttl time.Duration, claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	tokenId := uuid.NewString()
+
+	store := app.Repo.InsertOneTimeToken
+	if purpose == data.PurposeMagicLink {
+		store = app.Repo.ReplaceOneTimeToken
+	}
+
+	err := store(data.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(tokenId),
+		ExpiresAt: now.Add(ttl),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	linkClaims := jwt.MapClaims{}
+
+	for name, value := range claims {
+		linkClaims[name] = value
+	}
+
+	linkClaims["sub"] = user.ID
+	linkClaims["purpose"] = purpose
+	linkClaims["jti"] = tokenId
+	linkClaims["iat"] = now.Unix()
+	linkClaims["exp"] = now.Add(ttl).Unix()
+
+	return app.Keys.Sign(linkClaims)
+}
+
+/*
+consumeSignedLink checks the signature, the purpose and the expiration of the token of a link, and marks it as used.
+It returns the claims of the token, the id of the user is "sub". An invalid, expired or used link gives errInvalidLink.
+*/
+func (app *Config) consumeSignedLink(tokenString, purpose string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, app.Keys.Keyfunc)
+
+	if err != nil {
+		return nil, errInvalidLink
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok || !token.Valid {
+		return nil, errInvalidLink
+	}
+
+	userId, _ := claims["sub"].(string)
+	tokenPurpose, _ := claims["purpose"].(string)
+	tokenId, _ := claims["jti"].(string)
+	expirationTime, _ := claims["exp"].(float64)
+
+	if userId == "" || tokenPurpose != purpose || tokenId == "" || expirationTime == 0 {
+		return nil, errInvalidLink
+	}
+
+	// a single update marks it as used, so that concurrent requests can not both use the link
+	oneTimeToken, err := app.Repo.ConsumeOneTimeToken(purpose, hashToken(tokenId))
+
+	if errors.Is(err, data.ErrInvalidOneTimeToken) {
+		return nil, errInvalidLink
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if oneTimeToken.UserID != userId {
+		return nil, errInvalidLink
+	}
+
+	return claims, nil
+}
+
+/*
+notifyAddress returns where to send the messages to the user, which is their email once verified.
+ok is false for the users without one, the messages are not sent to an address which may not be theirs.
+*/
+func notifyAddress(user data.User) (to string, ok bool) {
+	if user.Email != nil && user.EmailVerifiedAt != nil {
+		return *user.Email, true
+	}
+
+	return "", false
+}
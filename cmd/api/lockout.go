@@ -0,0 +1,115 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Lockout is how failed logins lock a user out. After Threshold failed logins in a row the user is locked for Duration,
+and every further failed login doubles it, up to MaxDuration. A Threshold of 0 never locks.
+*/
+type Lockout struct {
+	Threshold   int
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+/*
+lockDuration returns for how long the user is locked after the failed logins in a row, 0 if not at all.
+*/
+func (l Lockout) lockDuration(attempts int) time.Duration {
+	if l.Threshold <= 0 || attempts < l.Threshold {
+		return 0
+	}
+
+	duration := l.Duration
+
+	for i := l.Threshold; i < attempts && duration < l.MaxDuration; i++ {
+		duration *= 2
+	}
+
+	if duration > l.MaxDuration {
+		return l.MaxDuration
+	}
+
+	return duration
+}
+
+/*
+isLocked reports whether the user is locked out right now.
+*/
+func isLocked(user data.User) bool {
+	return user.LockedUntil != nil && user.LockedUntil.After(time.Now())
+}
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+/*
+dummyPasswordMatch takes as long as checking a real password, so that the time of a failed login
+does not tell whether the user exists or is locked.
+*/
+func (app *Config) dummyPasswordMatch(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), 12)
+
+		if err != nil {
+			log.Println("@LOGIN Failed to hash dummy password:", err)
+		}
+
+		dummyPasswordHash = hash
+	})
+
+	app.Repo.PasswordMatch(password, data.User{Password: string(dummyPasswordHash)})
+}
+
+/*
+recordFailedLogin counts the failed login of the user and locks the user when it is one too many.
+*/
+func (app *Config) recordFailedLogin(user data.User) error {
+	attempts, err := app.Repo.RecordFailedLogin(user)
+
+	if err != nil {
+		return err
+	}
+
+	duration := app.Lockout.lockDuration(attempts)
+
+	if duration == 0 {
+		return nil
+	}
+
+	log.Printf("@LOGIN Locked user %s for %s after %d failed logins", user.ID, duration, attempts)
+
+	return app.Repo.LockUser(user, time.Now().Add(duration))
+}
+
+/*
+unlockUser is a handler that unlocks the user from the `id` path parameter and clears its failed logins.
+It can only be called by a user of the same organization with the users:unlock permission.
+*/
+func (app *Config) unlockUser(c *gin.Context) {
+	user, ok := app.userFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	err := app.Repo.ResetFailedLogins(*user)
+
+	if err != nil {
+		sendResponse("Failed to unlock user", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully unlocked user", "", nil, c, http.StatusOK)
+}
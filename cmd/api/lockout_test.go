@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+/*
+Testing the exponential backoff of the lockout
+*/
+func Test_LockDuration(t *testing.T) {
+	lockout := Lockout{Threshold: 3, Duration: time.Minute, MaxDuration: time.Hour}
+
+	tests := []struct {
+		attempts int
+		duration time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{9, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, test := range tests {
+		if duration := lockout.lockDuration(test.attempts); duration != test.duration {
+			t.Errorf("FAILED: %d attempts Expected %s get %s", test.attempts, test.duration, duration)
+		}
+	}
+
+	if duration := (Lockout{}).lockDuration(1000); duration != 0 {
+		t.Errorf("FAILED: Expected no lockout get %s", duration)
+	}
+}
+
+/*
+Testing POST /v1/login with failed logins
+
+	-> An unknown user and a wrong password get the same response
+	-> The user is locked out after too many failed logins, even with the right password
+	-> Admin unlocks the user
+*/
+func Test_LoginLockout(t *testing.T) {
+	unknown := serve(router, http.MethodPost, "/v1/login", `{"username":"unknown-user","password":"password"}`, "")
+	wrong := serve(router, http.MethodPost, "/v1/login", `{"username":"lockout-user","password":"wrong-password"}`, "")
+
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d and %d", http.StatusUnauthorized, unknown.Code, wrong.Code)
+	}
+
+	if unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("FAILED: Expected the same response get %s and %s", unknown.Body.String(), wrong.Body.String())
+	}
+
+	for i := 1; i < testApp.Lockout.Threshold; i++ {
+		serve(router, http.MethodPost, "/v1/login", `{"username":"lockout-user","password":"wrong-password"}`, "")
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/login", `{"username":"lockout-user","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	if reqRecorder.Body.String() != wrong.Body.String() {
+		t.Errorf("FAILED: Expected the same response get %s", reqRecorder.Body.String())
+	}
+
+	memberToken, _ := loginTestUser(t)
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/users/lockout-user-id/unlock", "", memberToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/users/lockout-user-id/unlock", "", adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login", `{"username":"lockout-user","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+/*
+requestMagicLink is a handler that takes the username from the request body and sends a login link to the user.
+It answers the same whether the user exists or not, so that it can not be used to find out the usernames.
+*/
+func (app *Config) requestMagicLink(c *gin.Context) {
+	var reqPayload struct {
+		Username string `json:"username"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Username == "" {
+		sendResponse("Missing Username in request", "missing username in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Repo.GetByUsername(reqPayload.Username)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	// in the background and only logged, the time of the response or an error would tell that the user exists
+	if user.ID != "" && !isLocked(*user) {
+		app.notifyInBackground("@LOGIN Failed to send magic link:", func() error {
+			return app.sendMagicLink(*user)
+		})
+	}
+
+	sendResponse("If the user exists, a login link has been sent", "", nil, c, http.StatusOK)
+}
+
+/*
+sendMagicLink sends a login link to the verified email of the user, which can only be used once.
+Nothing is sent without a verified email.
+*/
+func (app *Config) sendMagicLink(user data.User) error {
+	to, ok := notifyAddress(user)
+
+	if !ok {
+		return errNoVerifiedEmail
+	}
+
+	token, err := app.newSignedLink(user, data.PurposeMagicLink, magicLinkTTL, nil)
+
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Log in with this link, it is valid for %s and can be used once:\n\n%s/login/magic?token=%s\n\n"+
+		"If you did not ask for it, you can ignore this message.", magicLinkTTL, APP_URL, url.QueryEscape(token))
+
+	return app.Notifier.Notify(to, "Your login link", body)
+}
+
+/*
+verifyMagicLink is a handler that takes the token of a login link from the request body and logs the user in,
+with the same session as the login. Users with MFA still get an MFA token to exchange for the session.
+The link can only be used once, it is consumed even if the login does not complete.
+*/
+func (app *Config) verifyMagicLink(c *gin.Context) {
+	var reqPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Token == "" {
+		sendResponse("Missing Token in request", "missing token in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.consumeSignedLink(reqPayload.Token, data.PurposeMagicLink)
+
+	if err != nil {
+		if errors.Is(err, errInvalidLink) {
+			sendResponse("Invalid or expired link", err.Error(), nil, c, http.StatusUnauthorized)
+			return
+		}
+		sendResponse("Failed to verify link", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	userId, _ := claims["sub"].(string)
+
+	user, err := app.Repo.GetByID(userId)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if user.ID == "" || isLocked(*user) {
+		sendResponse("Invalid or expired link", errInvalidLink.Error(), nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	app.firstFactorLogin(c, user)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Testing /v1/login/magic and /v1/login/magic/verify
+
+	-> The login link is sent to the user, and gives the same session as the login
+	-> The link can only be used once, and a new link replaces the previous one
+	-> Nothing is sent for an unknown user, nor for a user without a verified email, but the answer is the same
+*/
+func Test_MagicLinkLogin(t *testing.T) {
+	previousToken := requestTestMagicLink(t)
+	token := requestTestMagicLink(t)
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/login/magic/verify", `{"token":"`+previousToken+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic/verify", `{"token":"`+token+`"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	accessToken := getCookie(reqRecorder, "Authorization")
+
+	if accessToken == "" {
+		t.Fatal("FAILED: Authorization Cookie absent")
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic/verify", `{"token":"`+token+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic", `{"username":"unknown-user"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	waitNotifications()
+
+	if _, ok := testApp.Notifier.(*MemoryNotifier).Last("unknown-user"); ok {
+		t.Errorf("FAILED: Expected no message to an unknown user")
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic", `{"username":"passkey-user"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	waitNotifications()
+
+	if _, ok := testApp.Notifier.(*MemoryNotifier).Last("passkey-user"); ok {
+		t.Errorf("FAILED: Expected no message to the username of a user without a verified email")
+	}
+}
+
+/*
+Testing POST /v1/login/magic/verify with forged links
+
+	-> A link re-signed for another user is refused
+	-> Another signed token, like an MFA token, is not a link
+	-> Missing token
+*/
+func Test_MagicLinkForged(t *testing.T) {
+	token := requestTestMagicLink(t)
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+
+	if err != nil {
+		t.Fatalf("Failed to parse magic link: %s", err.Error())
+	}
+
+	claims["sub"] = "mfa-user-id"
+	forged, err := testApp.Keys.Sign(claims)
+
+	if err != nil {
+		t.Fatalf("Failed to sign forged link: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/login/magic/verify", `{"token":"`+forged+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	mfaToken, err := testApp.Keys.Sign(jwt.MapClaims{
+		"sub":     "random-test-id",
+		"purpose": mfaChallengePurpose,
+		"jti":     "mfa-token-id",
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to sign MFA token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic/verify", `{"token":"`+mfaToken+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/magic/verify", `{}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
+
+/*
+Function to ask for a login link for the mocked member and return the token of the link sent to their email
+*/
+func requestTestMagicLink(t *testing.T) string {
+	reqRecorder := serve(router, http.MethodPost, "/v1/login/magic", `{"username":"test-username"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	waitNotifications()
+
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last(testUserEmail)
+
+	if !ok || !strings.Contains(message.Body, "/login/magic?token=") {
+		t.Fatalf("FAILED: Expected a login link message")
+	}
+
+	_, token, _ := strings.Cut(message.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	return token
+}
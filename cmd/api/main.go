@@ -1,25 +1,100 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"houseware---backend-engineering-octernship-KunalSin9h/data"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	// the IANA timezones of the users, so that they do not depend on the system
+	_ "time/tzdata"
 )
 
 /*
 data.Repository is an interface which we will use to put a real Postgres database when deploying in production
 and put a mock database for testing
+
+data.RevocationStore is the store of revoked tokens, it is backed by Postgres in production and by memory for testing
+
+Keys is the ring of keys used to sign and verify the JWT tokens, they are stored in Postgres in production and in memory for testing.
+
+PlatformAdmins are the usernames of the users who manage the whole platform, not only their organization.
+
+Notifier delivers the messages to the users, like the password reset links, by email in production and in memory for testing.
+
+Breached is the list of the passwords known from data breaches which can not be used, nil if it is not configured.
+
+Lockout is how many failed logins lock a user out, and for how long.
+
+RateLimits are the rate limits of the route groups, counted in the RateLimitStore, which is Postgres in production
+so that every replica counts the same, and memory for testing.
+
+TrustedProxies are the proxies which can set the client IP with the X-Forwarded-For header.
+
+MFAIssuer is the name the authenticator apps show next to the TOTP codes of the users.
+
+WebAuthn is the relying party the passkeys of the users are registered with.
+
+Issuer is the URL of this service, the issuer of the OpenID Connect tokens, which the discovery document is published under.
 */
 type Config struct {
-	Repo data.Repository
+	Repo           data.Repository
+	Revocations    data.RevocationStore
+	Keys           *KeyRing
+	PlatformAdmins []string
+	Notifier       Notifier
+	Breached       *BreachedPasswords
+	Lockout        Lockout
+	RateLimits     map[string]data.RateLimit
+	RateLimitStore data.RateLimitStore
+	TrustedProxies []string
+	MFAIssuer      string
+	WebAuthn       WebAuthn
+	Issuer         string
 }
 
 var (
 	PORT       = os.Getenv("PORT")
 	DSN        = os.Getenv("DSN")
 	JWT_SECRET = os.Getenv("JWT_SECRET")
+
+	JWT_SIGNING_ALG           = os.Getenv("JWT_SIGNING_ALG")
+	JWT_SIGNING_KEY_FILE      = os.Getenv("JWT_SIGNING_KEY_FILE")
+	JWT_KEY_ROTATION_INTERVAL = os.Getenv("JWT_KEY_ROTATION_INTERVAL")
+	JWT_KEY_ENCRYPTION_KEY    = os.Getenv("JWT_KEY_ENCRYPTION_KEY")
+
+	PLATFORM_ADMINS = os.Getenv("PLATFORM_ADMINS")
+
+	APP_URL       = os.Getenv("APP_URL")
+	SMTP_ADDR     = os.Getenv("SMTP_ADDR")
+	SMTP_FROM     = os.Getenv("SMTP_FROM")
+	SMTP_USERNAME = os.Getenv("SMTP_USERNAME")
+	SMTP_PASSWORD = os.Getenv("SMTP_PASSWORD")
+
+	BREACHED_PASSWORDS_FILE = os.Getenv("BREACHED_PASSWORDS_FILE")
+
+	LOGIN_LOCKOUT_THRESHOLD = os.Getenv("LOGIN_LOCKOUT_THRESHOLD")
+	LOGIN_LOCKOUT_DURATION  = os.Getenv("LOGIN_LOCKOUT_DURATION")
+
+	RATE_LIMIT_LOGIN = os.Getenv("RATE_LIMIT_LOGIN")
+	RATE_LIMIT_AUTH  = os.Getenv("RATE_LIMIT_AUTH")
+	RATE_LIMIT_USERS = os.Getenv("RATE_LIMIT_USERS")
+	TRUSTED_PROXIES  = os.Getenv("TRUSTED_PROXIES")
+
+	MFA_ISSUER = os.Getenv("MFA_ISSUER")
+
+	WEBAUTHN_RP_ID  = os.Getenv("WEBAUTHN_RP_ID")
+	WEBAUTHN_ORIGIN = os.Getenv("WEBAUTHN_ORIGIN")
+
+	OIDC_ISSUER = os.Getenv("OIDC_ISSUER")
 )
 
 func init() {
@@ -38,16 +113,147 @@ func init() {
 		JWT_SECRET = "$ecret"
 	}
 
+	if JWT_SIGNING_ALG == "" {
+		log.Println("@MAIN Missing JWT Signing Algorithm in Env. Using HS256")
+		JWT_SIGNING_ALG = algHS256
+	}
+
+	if JWT_KEY_ROTATION_INTERVAL == "" {
+		log.Println("@MAIN Missing JWT Key Rotation Interval in Env. Using 720h")
+		JWT_KEY_ROTATION_INTERVAL = "720h"
+	}
+
+	if JWT_KEY_ENCRYPTION_KEY == "" {
+		log.Println("@MAIN Missing JWT Key Encryption Key in Env. Storing the private signing keys unencrypted")
+	}
+
+	if APP_URL == "" {
+		log.Println("@MAIN Missing App URL in Env. Using http://localhost:5000")
+		APP_URL = "http://localhost:5000"
+	}
+
+	if SMTP_ADDR == "" {
+		log.Println("@MAIN Missing SMTP Server Address in Env. Using localhost:25")
+		SMTP_ADDR = "localhost:25"
+	}
+
+	if LOGIN_LOCKOUT_THRESHOLD == "" {
+		log.Println("@MAIN Missing Login Lockout Threshold in Env. Using 5")
+		LOGIN_LOCKOUT_THRESHOLD = "5"
+	}
+
+	if LOGIN_LOCKOUT_DURATION == "" {
+		log.Println("@MAIN Missing Login Lockout Duration in Env. Using 1m")
+		LOGIN_LOCKOUT_DURATION = "1m"
+	}
+
+	if RATE_LIMIT_LOGIN == "" {
+		log.Println("@MAIN Missing Login Rate Limit in Env. Using 10/1m")
+		RATE_LIMIT_LOGIN = "10/1m"
+	}
+
+	if RATE_LIMIT_AUTH == "" {
+		log.Println("@MAIN Missing Auth Rate Limit in Env. Using 30/1m")
+		RATE_LIMIT_AUTH = "30/1m"
+	}
+
+	if RATE_LIMIT_USERS == "" {
+		log.Println("@MAIN Missing Users Rate Limit in Env. Using 300/1m")
+		RATE_LIMIT_USERS = "300/1m"
+	}
+
+	if SMTP_FROM == "" {
+		log.Println("@MAIN Missing SMTP Sender Address in Env. Using no-reply@localhost")
+		SMTP_FROM = "no-reply@localhost"
+	}
+
+	if MFA_ISSUER == "" {
+		log.Println("@MAIN Missing MFA Issuer in Env. Using Houseware")
+		MFA_ISSUER = "Houseware"
+	}
+
+	if OIDC_ISSUER == "" {
+		log.Println("@MAIN Missing OIDC Issuer in Env. Using", APP_URL)
+		OIDC_ISSUER = APP_URL
+	}
+
+	// the endpoints in the discovery document are appended to it
+	OIDC_ISSUER = strings.TrimSuffix(OIDC_ISSUER, "/")
+
+	if appURL, err := url.Parse(APP_URL); err == nil {
+		if WEBAUTHN_RP_ID == "" {
+			log.Println("@MAIN Missing WebAuthn RP ID in Env. Using", appURL.Hostname())
+			WEBAUTHN_RP_ID = appURL.Hostname()
+		}
+
+		if WEBAUTHN_ORIGIN == "" {
+			log.Println("@MAIN Missing WebAuthn Origin in Env. Using", appURL.Scheme+"://"+appURL.Host)
+			WEBAUTHN_ORIGIN = appURL.Scheme + "://" + appURL.Host
+		}
+	}
+
 }
 
 func main() {
 
 	pool := data.ConnectDatabase(DSN)
 
+	rotationInterval, err := time.ParseDuration(JWT_KEY_ROTATION_INTERVAL)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid JWT Key Rotation Interval: %s", err.Error())
+	}
+
+	lockoutThreshold, err := strconv.Atoi(LOGIN_LOCKOUT_THRESHOLD)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid Login Lockout Threshold: %s", err.Error())
+	}
+
+	lockoutDuration, err := time.ParseDuration(LOGIN_LOCKOUT_DURATION)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid Login Lockout Duration: %s", err.Error())
+	}
+
+	keys, err := NewKeyRing(keyStore(pool), JWT_SIGNING_ALG, loadSigningKey())
+
+	if err != nil {
+		log.Fatalf("@MAIN Failed to load JWT signing keys: %s", err.Error())
+	}
+
+	go keys.Maintain(rotationInterval)
+
 	app := Config{
-		Repo: data.NewPostgresRepository(pool), // Real Postgres database connection
+		Repo:           data.NewPostgresRepository(pool), // Real Postgres database connection
+		Revocations:    data.NewPostgresRevocationStore(pool),
+		Keys:           keys,
+		PlatformAdmins: splitList(PLATFORM_ADMINS),
+		Notifier:       NewSMTPNotifier(SMTP_ADDR, SMTP_FROM, SMTP_USERNAME, SMTP_PASSWORD),
+		Breached:       loadBreachedPasswords(),
+		Lockout: Lockout{
+			Threshold:   lockoutThreshold,
+			Duration:    lockoutDuration,
+			MaxDuration: 24 * time.Hour,
+		},
+		RateLimits: map[string]data.RateLimit{
+			rateLimitLogin: mustParseRateLimit(RATE_LIMIT_LOGIN),
+			rateLimitAuth:  mustParseRateLimit(RATE_LIMIT_AUTH),
+			rateLimitUsers: mustParseRateLimit(RATE_LIMIT_USERS),
+		},
+		RateLimitStore: data.NewPostgresRateLimitStore(pool),
+		TrustedProxies: splitList(TRUSTED_PROXIES),
+		MFAIssuer:      MFA_ISSUER,
+		WebAuthn: WebAuthn{
+			RPID:   WEBAUTHN_RP_ID,
+			RPName: MFA_ISSUER,
+			Origin: WEBAUTHN_ORIGIN,
+		},
+		Issuer: OIDC_ISSUER,
 	}
 
+	log.Printf("@MAIN Signing tokens with %s key %s", keys.Current().Method.Alg(), keys.Current().ID)
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", PORT),
 		Handler: app.routes(),
@@ -56,3 +262,96 @@ func main() {
 	log.Println("Starting Authentication server at port:", PORT)
 	log.Fatal(server.ListenAndServe())
 }
+
+/*
+keyStore returns the store of the signing keys in the database, which encrypts the private keys
+with JWT_KEY_ENCRYPTION_KEY if it is set.
+*/
+func keyStore(pool *gorm.DB) data.KeyStore {
+	store := data.NewPostgresKeyStore(pool)
+
+	if JWT_KEY_ENCRYPTION_KEY == "" {
+		return store
+	}
+
+	key, err := base64.StdEncoding.DecodeString(JWT_KEY_ENCRYPTION_KEY)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid JWT Key Encryption Key: %s", err.Error())
+	}
+
+	encrypted, err := data.NewEncryptedKeyStore(store, key)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid JWT Key Encryption Key: %s", err.Error())
+	}
+
+	return encrypted
+}
+
+/*
+loadSigningKey returns the initial key to sign the tokens with, it is only used when there are no keys in the database yet.
+HS256 uses JWT_SECRET, the other algorithms read the private key from JWT_SIGNING_KEY_FILE.
+If it returns nil, the key ring generates the initial key.
+*/
+func loadSigningKey() *SigningKey {
+	if JWT_SIGNING_ALG == algHS256 {
+		return NewHMACKey("default", []byte(JWT_SECRET))
+	}
+
+	if JWT_SIGNING_KEY_FILE == "" {
+		return nil
+	}
+
+	key, err := LoadSigningKey(JWT_SIGNING_ALG, JWT_SIGNING_KEY_FILE)
+
+	if err != nil {
+		log.Fatalf("@MAIN Failed to load JWT signing key: %s", err.Error())
+	}
+
+	return key
+}
+
+/*
+splitList splits a comma separated list from the environment, ignoring blank items.
+*/
+func splitList(list string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+/*
+loadBreachedPasswords loads the breached passwords from BREACHED_PASSWORDS_FILE, it returns nil if it is not set.
+*/
+func loadBreachedPasswords() *BreachedPasswords {
+	if BREACHED_PASSWORDS_FILE == "" {
+		log.Println("@MAIN Missing Breached Passwords File in Env. Passwords are not checked against breaches")
+		return nil
+	}
+
+	breached, err := LoadBreachedPasswords(BREACHED_PASSWORDS_FILE)
+
+	if err != nil {
+		log.Fatalf("@MAIN Failed to load breached passwords: %s", err.Error())
+	}
+
+	log.Println("@MAIN Loaded breached passwords from", BREACHED_PASSWORDS_FILE)
+	return breached
+}
+
+func mustParseRateLimit(s string) data.RateLimit {
+	limit, err := parseRateLimit(s)
+
+	if err != nil {
+		log.Fatalf("@MAIN Invalid Rate Limit: %s", err.Error())
+	}
+
+	return limit
+}
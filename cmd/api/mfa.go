@@ -0,0 +1,647 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mfaChallengeTTL     = 5 * time.Minute
+	mfaChallengePurpose = "mfa"
+
+	// pixels per module of the QR code
+	qrCodeScale = 6
+)
+
+/*
+newMFAChallenge creates the short lived token which a user with MFA gets from the login, instead of the session,
+and exchanges along with a code for the session. It has no userId claim, so it can not be used as an access token.
+*/
+func (app *Config) newMFAChallenge(user data.User) (string, error) {
+	now := time.Now()
+
+	return app.Keys.Sign(jwt.MapClaims{
+		"sub":     user.ID,
+		"purpose": mfaChallengePurpose,
+		"jti":     uuid.NewString(),
+		"iat":     float64(now.UnixMicro()) / 1e6,
+		"exp":     now.Add(mfaChallengeTTL).Unix(),
+	})
+}
+
+/*
+parseMFAChallenge checks the signature, the purpose and the expiration of the MFA challenge token,
+and that it was not used already. It returns the claims of the token, with the id of the user as UserID.
+*/
+func (app *Config) parseMFAChallenge(tokenString string) (*accessTokenClaims, error) {
+	token, err := jwt.Parse(tokenString, app.Keys.Keyfunc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid mfa token")
+	}
+
+	userId, _ := claims["sub"].(string)
+	purpose, _ := claims["purpose"].(string)
+	tokenId, _ := claims["jti"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	expirationTime, _ := claims["exp"].(float64)
+
+	if userId == "" || purpose != mfaChallengePurpose || tokenId == "" || issuedAt == 0 || expirationTime == 0 {
+		return nil, errors.New("invalid mfa token")
+	}
+
+	challengeClaims := &accessTokenClaims{
+		UserID:    userId,
+		ID:        tokenId,
+		IssuedAt:  time.UnixMicro(int64(math.Round(issuedAt * 1e6))),
+		ExpiresAt: time.Unix(int64(expirationTime), 0),
+	}
+
+	revoked, err := app.Revocations.IsRevoked([]string{tokenId}, userId, challengeClaims.IssuedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("mfa token already used")
+	}
+
+	return challengeClaims, nil
+}
+
+/*
+loginMFA is a handler that completes the login of a user with MFA. It takes the MFA token given by the login
+and either a TOTP code or a recovery code from the request body, and sets the session in the cookie.
+Wrong codes count as failed logins, so that guessing the codes locks the user out.
+*/
+func (app *Config) loginMFA(c *gin.Context) {
+	var reqPayload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.MFAToken == "" || (reqPayload.Code == "" && reqPayload.RecoveryCode == "") {
+		sendResponse("Missing MFA Token or Code in request", "missing mfa token or code in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.parseMFAChallenge(reqPayload.MFAToken)
+
+	if err != nil {
+		sendResponse("Invalid or expired MFA token", err.Error(), nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Repo.GetByID(claims.UserID)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if user.ID == "" || user.TOTPEnabledAt == nil || isLocked(*user) {
+		sendResponse("Invalid MFA code", "invalid mfa code", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := app.useMFACode(*user, reqPayload.Code, reqPayload.RecoveryCode)
+
+	if err != nil {
+		sendResponse("Error while verifying MFA code", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		err = app.recordFailedLogin(*user)
+
+		if err != nil {
+			log.Println("@LOGIN Failed to record failed login:", err)
+		}
+
+		sendResponse("Invalid MFA code", "invalid mfa code", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Revocations.Revoke(claims.ID, claims.ExpiresAt)
+
+	if err != nil {
+		sendResponse("Failed to revoke MFA token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	app.completeLogin(c, user)
+}
+
+/*
+useTOTPCode checks the TOTP code of the user and marks its time step as used, so that a code can not be replayed.
+*/
+func (app *Config) useTOTPCode(user data.User, code string) (bool, error) {
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+
+	if !ok {
+		return false, nil
+	}
+
+	return app.Repo.UseTOTPStep(user, step)
+}
+
+/*
+useMFACode checks the TOTP code of the user, or the recovery code when there is no TOTP code, and uses it up.
+*/
+func (app *Config) useMFACode(user data.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return app.useTOTPCode(user, code)
+	}
+
+	return app.Repo.ConsumeRecoveryCode(user, hashRecoveryCode(recoveryCode))
+}
+
+/*
+reauthenticate checks that the current user proves who they are again before changing how they log in:
+their password, along with a TOTP code or a recovery code once TOTP is enabled, so that a stolen session is not enough.
+Wrong passwords and codes count as failed logins, like in the login. Otherwise it sends the error response and returns false.
+*/
+func (app *Config) reauthenticate(c *gin.Context, user data.User, password, code, recoveryCode string) bool {
+	if password == "" {
+		sendResponse("Missing Current Password in request", "missing current password in request", nil, c, http.StatusUnauthorized)
+		return false
+	}
+
+	if isLocked(user) {
+		app.dummyPasswordMatch(password)
+		sendResponse("Invalid current password", "invalid current password", nil, c, http.StatusUnauthorized)
+		return false
+	}
+
+	isPasswordMatched, err := app.Repo.PasswordMatch(password, user)
+
+	if err != nil {
+		sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
+		return false
+	}
+
+	if !isPasswordMatched {
+		app.recordFailedReauthentication(user)
+		sendResponse("Invalid current password", "invalid current password", nil, c, http.StatusUnauthorized)
+		return false
+	}
+
+	if user.TOTPEnabledAt == nil {
+		return true
+	}
+
+	if code == "" && recoveryCode == "" {
+		sendResponse("Missing Code in request", "missing code in request", nil, c, http.StatusBadRequest)
+		return false
+	}
+
+	valid, err := app.useMFACode(user, code, recoveryCode)
+
+	if err != nil {
+		sendResponse("Error while verifying MFA code", err.Error(), nil, c, http.StatusInternalServerError)
+		return false
+	}
+
+	if !valid {
+		app.recordFailedReauthentication(user)
+		sendResponse("Invalid MFA code", "invalid mfa code", nil, c, http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// recordFailedReauthentication counts a wrong password or code of reauthenticate as a failed login
+func (app *Config) recordFailedReauthentication(user data.User) {
+	err := app.recordFailedLogin(user)
+
+	if err != nil {
+		log.Println("@MFA Failed to record failed login:", err)
+	}
+}
+
+/*
+mfaMethods returns the second factors the user can log in with: "totp" and "webauthn" for the passkeys.
+*/
+func (app *Config) mfaMethods(user data.User) ([]string, error) {
+	methods := []string{}
+
+	if user.TOTPEnabledAt != nil {
+		methods = append(methods, "totp")
+	}
+
+	credentials, err := app.Repo.GetWebAuthnCredentials(user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(credentials) > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	return methods, nil
+}
+
+/*
+adminMFARequired reports whether the user is an admin of an organization which requires MFA for its admins.
+*/
+func (app *Config) adminMFARequired(user data.User) (bool, error) {
+	if user.Role != data.AdminRole {
+		return false, nil
+	}
+
+	org, err := app.Repo.GetOrganizationByID(user.OrganizationID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return org.RequireAdminMFA, nil
+}
+
+/*
+mfaEnrollmentRequired reports whether the user is an admin of an organization which requires MFA for its admins,
+and has not enabled it yet.
+*/
+func (app *Config) mfaEnrollmentRequired(user data.User) (bool, error) {
+	required, err := app.adminMFARequired(user)
+
+	if err != nil || !required {
+		return false, err
+	}
+
+	methods, err := app.mfaMethods(user)
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(methods) == 0, nil
+}
+
+/*
+tokenUser returns the user of the access token. Unlike RequirePermission, it lets the admins who still have to
+enroll in MFA through, so that they can do so.
+*/
+func (app *Config) tokenUser(c *gin.Context) (*data.User, bool) {
+	userId, _ := c.Get("userId")
+
+	user, err := app.Repo.GetByID(userId.(string))
+
+	if err != nil || user.ID == "" {
+		sendResponse("User does not exist", "user does not exist", nil, c, http.StatusBadRequest)
+		return nil, false
+	}
+
+	return user, true
+}
+
+/*
+enrollTOTP is a handler that starts the TOTP enrollment of the current user with a new secret.
+It returns the secret, its otpauth URI and the QR code of the URI as a PNG data URI, to scan with an authenticator app.
+A URI too long for a QR code is returned without it.
+TOTP is only enabled once confirmed with a first code.
+It takes the password of the current user from the request body, so that a stolen session can not enroll
+a secret the owner does not have, see reauthenticate.
+*/
+func (app *Config) enrollTOTP(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		sendResponse("TOTP already enabled", data.ErrTOTPAlreadyEnabled.Error(), nil, c, http.StatusConflict)
+		return
+	}
+
+	var reqPayload struct {
+		Password string `json:"password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.Password, "", "") {
+		return
+	}
+
+	secret, err := newTOTPSecret()
+
+	if err != nil {
+		sendResponse("Failed to create TOTP secret", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	uri := totpURI(app.MFAIssuer, user.Username, secret)
+
+	enrollment := map[string]any{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	}
+
+	qrCode, err := encodeQRCode([]byte(uri))
+
+	switch {
+	case errors.Is(err, errQRCodeTooLong):
+		// the URI of a long username does not fit in the QR codes we draw, it can still be entered in the app
+		log.Println("@MFA No QR code for the otpauth URI:", err)
+	case err != nil:
+		sendResponse("Failed to create QR code", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	default:
+		image, err := qrCode.PNG(qrCodeScale)
+
+		if err != nil {
+			sendResponse("Failed to create QR code", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		enrollment["qr_code"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+	}
+
+	err = app.Repo.SetTOTPSecret(*user, secret)
+
+	if err != nil {
+		if errors.Is(err, data.ErrTOTPAlreadyEnabled) {
+			sendResponse("TOTP already enabled", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to save TOTP secret", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Scan the QR code and confirm with a code", "", enrollment, c, http.StatusOK)
+}
+
+/*
+confirmTOTP is a handler that takes the first code of the authenticator app from the request body and enables TOTP.
+It returns the recovery codes, which are only shown this once.
+*/
+func (app *Config) confirmTOTP(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Code == "" {
+		sendResponse("Missing Code in request", "missing code in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		sendResponse("TOTP already enabled", data.ErrTOTPAlreadyEnabled.Error(), nil, c, http.StatusConflict)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		sendResponse("TOTP enrollment not started", "totp enrollment not started", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, reqPayload.Code, time.Now())
+
+	if !ok {
+		sendResponse("Invalid TOTP code", "invalid totp code", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+
+	if err != nil {
+		sendResponse("Failed to create recovery codes", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Repo.EnableTOTP(*user, step, hashes)
+
+	if err != nil {
+		if errors.Is(err, data.ErrTOTPAlreadyEnabled) {
+			sendResponse("TOTP already enabled", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to enable TOTP", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully enabled TOTP, keep the recovery codes somewhere safe", "", map[string]any{
+		"recovery_codes": codes,
+	}, c, http.StatusOK)
+}
+
+/*
+disableTOTP is a handler that takes the password of the current user from the request body and disables TOTP.
+Once TOTP is enabled, a TOTP code or a recovery code is required too, so that a stolen password and session
+are not enough to remove the second factor, see reauthenticate.
+The admins of an organization which requires MFA for its admins can not disable it.
+*/
+func (app *Config) disableTOTP(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.Password, reqPayload.Code, reqPayload.RecoveryCode) {
+		return
+	}
+
+	required, err := app.adminMFARequired(*user)
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if required {
+		// an admin with a passkey still has a second factor without TOTP
+		credentials, err := app.Repo.GetWebAuthnCredentials(user.ID)
+
+		if err != nil {
+			sendResponse("Failed to get passkeys", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if len(credentials) == 0 {
+			sendResponse("MFA is required for the admins of the organization", "mfa required for admins", nil, c, http.StatusConflict)
+			return
+		}
+	}
+
+	err = app.Repo.DisableTOTP(*user)
+
+	if err != nil {
+		sendResponse("Failed to disable TOTP", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully disabled TOTP", "", nil, c, http.StatusOK)
+}
+
+/*
+regenerateRecoveryCodes is a handler that takes the password and a TOTP code of the current user from the request body
+and replaces their recovery codes, the old ones can not be used anymore, see reauthenticate.
+*/
+func (app *Config) regenerateRecoveryCodes(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		sendResponse("TOTP not enabled", "totp not enabled", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.Password, reqPayload.Code, "") {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+
+	if err != nil {
+		sendResponse("Failed to create recovery codes", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Repo.ReplaceRecoveryCodes(*user, hashes)
+
+	if err != nil {
+		sendResponse("Failed to save recovery codes", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully created new recovery codes", "", map[string]any{
+		"recovery_codes": codes,
+	}, c, http.StatusOK)
+}
+
+/*
+getMFAPolicy is a handler that returns whether the organization of the current user requires MFA for its admins.
+*/
+func (app *Config) getMFAPolicy(c *gin.Context) {
+	org, err := app.Repo.GetOrganizationByID(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get MFA policy", "", map[string]any{
+		"require_admin_mfa": org.RequireAdminMFA,
+	}, c, http.StatusOK)
+}
+
+/*
+updateMFAPolicy is a handler that takes from the request body whether the organization requires MFA for its admins.
+Once required, the admins without MFA can only enroll until they do.
+It can only be called by a user with the mfa_policy:manage permission.
+*/
+func (app *Config) updateMFAPolicy(c *gin.Context) {
+	org, err := app.Repo.GetOrganizationByID(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if org.ID == "" {
+		sendResponse("Organization does not exist", "organization does not exist", nil, c, http.StatusNotFound)
+		return
+	}
+
+	var reqPayload struct {
+		RequireAdminMFA *bool `json:"require_admin_mfa"`
+	}
+
+	err = c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.RequireAdminMFA == nil {
+		sendResponse("Missing require_admin_mfa in request", "missing require_admin_mfa in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.SetRequireAdminMFA(*org, *reqPayload.RequireAdminMFA)
+
+	if err != nil {
+		sendResponse("Failed to update MFA policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully updated MFA policy", "", map[string]any{
+		"require_admin_mfa": *reqPayload.RequireAdminMFA,
+	}, c, http.StatusOK)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Testing the TOTP codes against the SHA1 test vectors of RFC 6238
+
+	-> The codes of the previous and the next step are accepted, not the ones further away
+*/
+func Test_TOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		if code := hotp(key, uint64(test.time/totpPeriod), 8); code != test.code {
+			t.Errorf("FAILED: At %d Expected %s get %s", test.time, test.code, code)
+		}
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code := hotp(key, uint64(totpStep(now)+offset), totpDigits)
+
+		if step, ok := verifyTOTP(secret, code, now); !ok || step != totpStep(now)+offset {
+			t.Errorf("FAILED: Offset %d Expected step %d get %d %t", offset, totpStep(now)+offset, step, ok)
+		}
+	}
+
+	if _, ok := verifyTOTP(secret, hotp(key, uint64(totpStep(now)+2), totpDigits), now); ok {
+		t.Errorf("FAILED: Expected the code two steps ahead to be refused")
+	}
+}
+
+/*
+Testing the TOTP enrollment and the login with MFA
+
+	-> The enrollment returns the otpauth URI and the QR code, and is only enabled with a valid code
+	-> The login returns an MFA token instead of the session, which is not an access token
+	-> The MFA token and a valid code give the session, a code and the MFA token can only be used once
+	-> A recovery code replaces the TOTP code, and can only be used once
+	-> Enrolling takes the password
+	-> Disabling TOTP takes a TOTP or recovery code along with the password
+*/
+func Test_TOTPEnrollmentAndLogin(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "mfa-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/me/mfa/totp", "", token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp", `{"password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	enrollment := responseData(t, reqRecorder)
+	secret, _ := enrollment["secret"].(string)
+	uri, _ := enrollment["otpauth_uri"].(string)
+	qrCode, _ := enrollment["qr_code"].(string)
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Houseware%20Test:mfa-user?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("FAILED: Unexpected otpauth URI %s", uri)
+	}
+
+	if !strings.HasPrefix(qrCode, "data:image/png;base64,") {
+		t.Errorf("FAILED: Expected a PNG data URI get %.40s", qrCode)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp/confirm", `{"code":"000000"}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	confirmationCode := testTOTPCode(t, secret, 0)
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp/confirm", `{"code":"`+confirmationCode+`"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	recoveryCodes, _ := responseData(t, reqRecorder)["recovery_codes"].([]any)
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("FAILED: Expected %d recovery codes get %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp", "", token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	mfaToken := loginMFAUser(t)
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", mfaToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// the code of the confirmation was used already
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/mfa", `{"mfa_token":"`+mfaToken+`","code":"`+confirmationCode+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/mfa", `{"mfa_token":"`+mfaToken+`","code":"`+testTOTPCode(t, secret, 1)+`"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if getCookie(reqRecorder, "Authorization") == "" {
+		t.Errorf("FAILED: Authorization Cookie absent")
+	}
+
+	recoveryCode := recoveryCodes[0].(string)
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/mfa", `{"mfa_token":"`+mfaToken+`","recovery_code":"`+recoveryCode+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	mfaToken = loginMFAUser(t)
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/mfa", `{"mfa_token":"`+mfaToken+`","recovery_code":"`+strings.ToUpper(recoveryCode)+`"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	mfaToken = loginMFAUser(t)
+	reqRecorder = serve(router, http.MethodPost, "/v1/login/mfa", `{"mfa_token":"`+mfaToken+`","recovery_code":"`+recoveryCode+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"wrong-password"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// the password alone does not disable the second factor
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","code":"000000"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","recovery_code":"`+recoveryCodes[1].(string)+`"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/login", `{"username":"mfa-user","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK || getCookie(reqRecorder, "Authorization") == "" {
+		t.Errorf("FAILED: Expected %d and a session get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/me/mfa/recovery-codes
+
+	-> Regenerating the recovery codes takes the password along with a TOTP code
+	-> The old recovery codes can not be used anymore
+*/
+func Test_RegenerateRecoveryCodes(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "mfa-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/me/mfa/totp", `{"password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	secret, _ := responseData(t, reqRecorder)["secret"].(string)
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp/confirm", `{"code":"`+testTOTPCode(t, secret, 0)+`"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	recoveryCodes, _ := responseData(t, reqRecorder)["recovery_codes"].([]any)
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("FAILED: Expected %d recovery codes get %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	code := testTOTPCode(t, secret, 1)
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/recovery-codes", `{"code":"`+code+`"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/recovery-codes", `{"password":"password","code":"`+code+`"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	newRecoveryCodes, _ := responseData(t, reqRecorder)["recovery_codes"].([]any)
+
+	if len(newRecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("FAILED: Expected %d recovery codes get %d", recoveryCodeCount, len(newRecoveryCodes))
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","recovery_code":"`+recoveryCodes[0].(string)+`"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","recovery_code":"`+newRecoveryCodes[0].(string)+`"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the TOTP enrollment of a URI too long for a QR code
+
+	-> The enrollment still returns the secret and the otpauth URI, without the QR code
+*/
+func Test_TOTPEnrollmentWithoutQRCode(t *testing.T) {
+	app := testApp
+	app.MFAIssuer = strings.Repeat("Houseware", 30)
+
+	token, err := signJWTTestTokenFor(app.Keys, "random-test-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(app.routes(), http.MethodPost, "/v1/me/mfa/totp", `{"password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	enrollment := responseData(t, reqRecorder)
+
+	if uri, _ := enrollment["otpauth_uri"].(string); !strings.HasPrefix(uri, "otpauth://totp/Houseware") {
+		t.Errorf("FAILED: Unexpected otpauth URI %s", uri)
+	}
+
+	if _, ok := enrollment["qr_code"]; ok {
+		t.Errorf("FAILED: Expected no QR code")
+	}
+}
+
+/*
+Testing PATCH /v1/mfa/policy
+
+	-> Once MFA is required for the admins, the admins without it can only enroll
+	-> The admins can not disable their MFA while it is required
+*/
+func Test_RequireAdminMFA(t *testing.T) {
+	mfaAdminToken, err := signJWTTestTokenFor(testApp.Keys, "mfa-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	memberToken, _ := loginTestUser(t)
+
+	reqRecorder := serve(router, http.MethodPatch, "/v1/mfa/policy", `{"require_admin_mfa":true}`, memberToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp", `{"password":"password"}`, mfaAdminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	secret, _ := responseData(t, reqRecorder)["secret"].(string)
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp/confirm", `{"code":"`+testTOTPCode(t, secret, 0)+`"}`, mfaAdminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	recoveryCodes, _ := responseData(t, reqRecorder)["recovery_codes"].([]any)
+
+	if len(recoveryCodes) < 2 {
+		t.Fatalf("FAILED: Expected %d recovery codes get %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	defer serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","recovery_code":"`+recoveryCodes[0].(string)+`"}`, mfaAdminToken)
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/mfa/policy", `{"require_admin_mfa":true}`, mfaAdminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	defer serve(router, http.MethodPatch, "/v1/mfa/policy", `{"require_admin_mfa":false}`, mfaAdminToken)
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/users", "", adminToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/users", "", memberToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/users", "", mfaAdminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/mfa/totp", `{"password":"password"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/me/mfa/totp", `{"password":"password","recovery_code":"`+recoveryCodes[1].(string)+`"}`, mfaAdminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
+
+/*
+Function to login as the mocked user with MFA and return the MFA token
+*/
+func loginMFAUser(t *testing.T) string {
+	reqRecorder := serve(router, http.MethodPost, "/v1/login", `{"username":"mfa-user","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if getCookie(reqRecorder, "Authorization") != "" {
+		t.Errorf("FAILED: Expected no Authorization Cookie before the MFA code")
+	}
+
+	mfaToken, _ := responseData(t, reqRecorder)["mfa_token"].(string)
+
+	if mfaToken == "" {
+		t.Fatal("FAILED: MFA token absent")
+	}
+
+	return mfaToken
+}
+
+/*
+Function to get the TOTP code of the secret, steps after the current one
+*/
+func testTOTPCode(t *testing.T, secret string, steps int64) string {
+	key, err := totpEncoding.DecodeString(secret)
+
+	if err != nil {
+		t.Fatalf("Failed to decode TOTP secret: %s", err.Error())
+	}
+
+	return hotp(key, uint64(totpStep(time.Now())+steps), totpDigits)
+}
+
+/*
+Function to get the data of a response
+*/
+func responseData(t *testing.T, reqRecorder *httptest.ResponseRecorder) map[string]any {
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+
+	err := json.Unmarshal(reqRecorder.Body.Bytes(), &response)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	return response.Data
+}
@@ -2,63 +2,339 @@ package main
 
 import (
 	"errors"
-	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Subject types of the access tokens, a user or a machine client acting for itself
+const (
+	subjectUser   = "user"
+	subjectClient = "client"
+)
+
 func unAuthorizedResponse(c *gin.Context, err error) {
 	sendResponse("UnAuthorized", err.Error(), nil, c, http.StatusUnauthorized)
 	c.Abort()
 }
 
 /*
-AuthorizationMiddleware is a middleware that checks for the Authorization header in the Cookie.
-It checks for the validity of the token and if it is valid, it sets the userId in the context.
+AuthorizationMiddleware is a middleware that checks for the Authorization header in the Cookie,
+or for a bearer token in the Authorization header of the request, which is how the OAuth clients send theirs.
+It checks for the validity of the token and if it is valid, it sets the subjectType and the userId in the context.
+Along with the userId, the id of the token (jti), of its session (sid) and its expiration time are set in the context.
+The tokens issued to OAuth clients also set the clientId and the granted scopes.
+The tokens of the machine clients have the client subject type, and set the clientId and the scopes but no userId.
 */
 func (app *Config) AuthorizationMiddleware(c *gin.Context) {
 
-	authTokenString, err := c.Cookie("Authorization")
+	authTokenString, ok := bearerToken(c)
+
+	if !ok {
+		var err error
+		authTokenString, err = c.Cookie("Authorization")
+
+		if err != nil {
+			unAuthorizedResponse(c, err)
+			return
+		}
+	}
+
+	claims, err := app.parseAccessToken(authTokenString)
 
 	if err != nil {
 		unAuthorizedResponse(c, err)
 		return
 	}
 
-	token, err := jwt.Parse(authTokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	c.Set("subjectType", claims.SubjectType)
+
+	if claims.SubjectType == subjectUser {
+		c.Set("userId", claims.UserID)
+	}
+
+	c.Set("jti", claims.ID)
+	c.Set("sessionId", claims.SessionID)
+	c.Set("tokenExpiresAt", claims.ExpiresAt)
+
+	if claims.Scopes != nil {
+		c.Set("clientId", claims.ClientID)
+		c.Set("scopes", claims.Scopes)
+	}
+
+	c.Next()
+}
+
+/*
+bearerToken returns the token of the `Authorization: Bearer` header, ok is false if there is none.
+*/
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+/*
+accessTokenClaims are the claims of a valid access token.
+ClientID and Scopes are only set for the tokens issued to OAuth clients, Scopes is nil for the ones of a login session.
+The tokens of the machine clients have the client SubjectType and no UserID.
+*/
+type accessTokenClaims struct {
+	SubjectType string
+	UserID      string
+	ID          string
+	SessionID   string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+	ClientID    string
+	Scopes      []string
+}
+
+/*
+parseAccessToken checks the signature and the expiration of the access token and
+that neither the token, its session nor all the tokens of its user were revoked.
+*/
+func (app *Config) parseAccessToken(authTokenString string) (*accessTokenClaims, error) {
+	token, err := jwt.Parse(authTokenString, app.Keys.Keyfunc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid auth token")
+	}
+
+	userId, _ := claims["userId"].(string)
+	tokenId, _ := claims["jti"].(string)
+	sessionId, _ := claims["sid"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	expirationTime, _ := claims["exp"].(float64)
+
+	// the tokens of the login sessions have no subject type, they are all of users
+	subjectType := subjectUser
+	subject := userId
+
+	if claims["sub_type"] == subjectClient {
+		subjectType = subjectClient
+		subject, _ = claims["sub"].(string)
+
+		if _, ok := claims["scope"].(string); !ok || subject != claims["client_id"] {
+			return nil, errors.New("invalid auth token")
 		}
-		return []byte(JWT_SECRET), nil
-	})
+	}
+
+	if subject == "" || tokenId == "" || issuedAt == 0 || expirationTime == 0 {
+		return nil, errors.New("invalid auth token")
+	}
+
+	if float64(time.Now().Unix()) > expirationTime {
+		return nil, errors.New("auth token expired")
+	}
+
+	accessClaims := &accessTokenClaims{
+		SubjectType: subjectType,
+		UserID:      userId,
+		ID:          tokenId,
+		SessionID:   sessionId,
+		IssuedAt:    time.UnixMicro(int64(math.Round(issuedAt * 1e6))),
+		ExpiresAt:   time.Unix(int64(expirationTime), 0),
+	}
+
+	if scope, ok := claims["scope"].(string); ok {
+		accessClaims.ClientID, _ = claims["client_id"].(string)
+		accessClaims.Scopes = strings.Fields(scope)
+	}
+
+	ids := []string{tokenId}
+	if sessionId != "" {
+		ids = append(ids, sessionId)
+	}
+
+	revoked, err := app.Revocations.IsRevoked(ids, subject, accessClaims.IssuedAt)
 
 	if err != nil {
-		unAuthorizedResponse(c, err)
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("auth token revoked")
+	}
+
+	return accessClaims, nil
+}
+
+/*
+PlatformAdminMiddleware is a middleware that only lets platform admins through.
+It must run after the AuthorizationMiddleware.
+*/
+func (app *Config) PlatformAdminMiddleware(c *gin.Context) {
+	userId, _ := c.Get("userId")
+
+	user, err := app.Repo.GetByID(userId.(string))
+
+	if err != nil {
+		sendResponse("User does not exist", err.Error(), nil, c, http.StatusBadRequest)
+		c.Abort()
+		return
+	}
+
+	for _, username := range app.PlatformAdmins {
+		if user.ID != "" && user.Username == username {
+			c.Next()
+			return
+		}
+	}
+
+	sendResponse("Not Authorized", "not authorized", nil, c, http.StatusUnauthorized)
+	c.Abort()
+}
+
+/*
+RequireSession is a middleware that only lets the tokens of a login session through, not the ones issued to OAuth clients.
+It guards the endpoints of the user's own account, which no scope grants. It must run after the AuthorizationMiddleware.
+*/
+func (app *Config) RequireSession(c *gin.Context) {
+	if _, ok := c.Get("scopes"); ok {
+		sendResponse("Not Authorized", "a login session is required", nil, c, http.StatusForbidden)
+		c.Abort()
 		return
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userId := claims["userId"]
-		expirationTime := claims["exp"]
+	c.Next()
+}
 
-		if userId == nil || expirationTime == nil {
-			unAuthorizedResponse(c, errors.New("invalid auth token"))
+/*
+RequirePermission returns a middleware that only lets the users whose role grants every one of the permissions through.
+The tokens issued to OAuth clients must also have been granted every one of the permissions as scopes.
+It must run after the AuthorizationMiddleware, and it sets the currentUser in the context for the handlers.
+The admins who are required to enroll in MFA are not let through until they do.
+The machine clients are let through by requireClientPermission instead.
+*/
+func (app *Config) RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subjectType, _ := c.Get("subjectType"); subjectType == subjectClient {
+			app.requireClientPermission(c, permissions)
+			return
+		}
+
+		userId, _ := c.Get("userId")
+
+		user, err := app.Repo.GetByID(userId.(string))
+
+		if err != nil {
+			sendResponse("User does not exist", err.Error(), nil, c, http.StatusBadRequest)
+			c.Abort()
 			return
 		}
 
-		if float64(time.Now().Unix()) > expirationTime.(float64) {
-			unAuthorizedResponse(c, errors.New("auth token expired"))
+		if user.ID == "" {
+			unAuthorizedResponse(c, errors.New("user does not exist"))
 			return
 		}
 
-		c.Set("userId", userId)
+		role, err := app.Repo.GetRole(user.OrganizationID, user.Role)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+
+		enrollmentRequired, err := app.mfaEnrollmentRequired(*user)
+
+		if err != nil {
+			sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+
+		if enrollmentRequired {
+			sendResponse("MFA enrollment required", "mfa enrollment required", nil, c, http.StatusForbidden)
+			c.Abort()
+			return
+		}
+
+		scopes, scoped := c.Get("scopes")
+
+		for _, permission := range permissions {
+			if !role.HasPermission(permission) {
+				sendResponse("Not Authorized", "missing permission "+permission, nil, c, http.StatusForbidden)
+				c.Abort()
+				return
+			}
+
+			if scoped && !hasScope(scopes.([]string), permission) {
+				sendResponse("Not Authorized", "missing scope "+permission, nil, c, http.StatusForbidden)
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("currentUser", user)
 
 		c.Next()
-	} else {
-		unAuthorizedResponse(c, errors.New("invalid auth token"))
+	}
+}
+
+/*
+requireClientPermission only lets the machine client through if every one of the permissions is both registered for
+the client and granted to its token, so that narrowing or deleting the client takes effect right away.
+The handlers get the client as the currentUser: a user of the organization of the client, with its id and name and no role.
+*/
+func (app *Config) requireClientPermission(c *gin.Context, permissions []string) {
+	clientId, _ := c.Get("clientId")
+
+	client, err := app.Repo.GetOAuthClient(clientId.(string))
+
+	if err != nil {
+		sendResponse("Failed to get client", err.Error(), nil, c, http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+
+	if client.ID == "" || !client.HasGrantType(data.GrantClientCredentials) {
+		unAuthorizedResponse(c, errors.New("client does not exist"))
 		return
 	}
+
+	scopes, _ := c.Get("scopes")
+
+	for _, permission := range permissions {
+		if !hasScope(client.Scopes, permission) || !hasScope(scopes.([]string), permission) {
+			sendResponse("Not Authorized", "missing scope "+permission, nil, c, http.StatusForbidden)
+			c.Abort()
+			return
+		}
+	}
+
+	principal := data.User{
+		Username:       client.Name,
+		OrganizationID: client.OrganizationID,
+	}
+	principal.ID = client.ID
+
+	c.Set("currentClient", client)
+	c.Set("currentUser", &principal)
+
+	c.Next()
+}
+
+/*
+currentUser returns the user set in the context by RequirePermission.
+*/
+func currentUser(c *gin.Context) *data.User {
+	user, _ := c.Get("currentUser")
+	return user.(*data.User)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+/*
+Notifier delivers messages to the users out of band, like the password reset links.
+It is SMTP in production and memory for testing.
+*/
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+// the messages being delivered by notifyInBackground
+var notifications sync.WaitGroup
+
+/*
+notifyInBackground issues and delivers a message to a user after the response is sent, so that how long the delivery
+takes can not tell whether the user exists. The errors are only logged, with the prefix.
+*/
+func (app *Config) notifyInBackground(prefix string, send func() error) {
+	notifications.Add(1)
+
+	go func() {
+		defer notifications.Done()
+
+		err := send()
+
+		if err != nil {
+			log.Println(prefix, err)
+		}
+	}()
+}
+
+/*
+waitNotifications returns once every message sent with notifyInBackground is delivered.
+*/
+func waitNotifications() {
+	notifications.Wait()
+}
+
+/*
+SMTPNotifier sends the messages as plain text emails through an SMTP server.
+Auth is optional, the server at Addr may accept unauthenticated mail.
+*/
+type SMTPNotifier struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPNotifier(addr, from, username, password string) *SMTPNotifier {
+	notifier := &SMTPNotifier{
+		Addr: addr,
+		From: from,
+	}
+
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		notifier.Auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return notifier
+}
+
+func (n *SMTPNotifier) Notify(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		n.From, to, subject, body)
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{to}, []byte(msg))
+}
+
+/*
+Message is a message delivered by the MemoryNotifier.
+*/
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+/*
+MemoryNotifier keeps the messages in memory instead of delivering them, it is used for testing.
+*/
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (n *MemoryNotifier) Notify(to, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+/*
+Last returns the last message delivered to the recipient, ok is false if there is none.
+*/
+func (n *MemoryNotifier) Last(to string) (Message, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i := len(n.messages) - 1; i >= 0; i-- {
+		if n.messages[i].To == to {
+			return n.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
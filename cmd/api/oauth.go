@@ -0,0 +1,866 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const authorizationCodeTTL = 5 * time.Minute
+
+/*
+authorizationRequest are the parameters of an OAuth 2.0 authorization request (RFC 6749), with the PKCE challenge (RFC 7636)
+and the nonce and prompt of OpenID Connect.
+*/
+type authorizationRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Nonce               string `form:"nonce" json:"nonce"`
+	Prompt              string `form:"prompt" json:"prompt"`
+}
+
+/*
+authorization is a valid authorization request, the scopes are the ones asked for, or the ones of the client if none are.
+*/
+type authorization struct {
+	Client        data.OAuthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+	Nonce         string
+	Prompt        []string
+}
+
+/*
+authorize is a handler that starts the authorization code flow of a client. If the user is logged in and already
+agreed to give the scopes to the client, it redirects back to the client with the code. Otherwise it redirects to
+the page of the app (`APP_URL/authorize`) with the same parameters, which logs the user in, asks for the consent
+and posts them to approveAuthorization.
+With prompt=none the user is never sent to the page, the client gets the login_required or consent_required error instead.
+With prompt=login or prompt=consent the user is always sent to it.
+An unknown client or redirect URI is not redirected to, the other errors are sent back to the client.
+*/
+func (app *Config) authorize(c *gin.Context) {
+	var reqPayload authorizationRequest
+
+	_ = c.ShouldBindQuery(&reqPayload)
+
+	auth, errorRedirect, ok := app.parseAuthorizationRequest(c, reqPayload)
+
+	if !ok {
+		return
+	}
+
+	if errorRedirect != "" {
+		c.Redirect(http.StatusFound, errorRedirect)
+		return
+	}
+
+	interactive := hasScope(auth.Prompt, "login") || hasScope(auth.Prompt, "consent")
+	user := app.sessionUser(c)
+
+	if user != nil && user.OrganizationID == auth.Client.OrganizationID && !interactive {
+		scopes, err := app.grantedScopes(*user, *auth)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		consented, err := app.hasConsent(*user, auth.Client, scopes)
+
+		if err != nil {
+			sendResponse("Failed to get consent", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		enrollmentRequired, err := app.mfaEnrollmentRequired(*user)
+
+		if err != nil {
+			sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if consented && !enrollmentRequired {
+			redirect, err := app.issueAuthorizationCode(*user, *auth, scopes)
+
+			if err != nil {
+				sendResponse("Failed to create authorization code", err.Error(), nil, c, http.StatusInternalServerError)
+				return
+			}
+
+			c.Redirect(http.StatusFound, redirect)
+			return
+		}
+
+		if hasScope(auth.Prompt, "none") {
+			errorCode := "consent_required"
+			if enrollmentRequired {
+				errorCode = "interaction_required"
+			}
+
+			c.Redirect(http.StatusFound, authorizationRedirect(auth.RedirectURI, url.Values{
+				"error": {errorCode},
+				"state": {auth.State},
+			}))
+			return
+		}
+	}
+
+	if hasScope(auth.Prompt, "none") {
+		c.Redirect(http.StatusFound, authorizationRedirect(auth.RedirectURI, url.Values{
+			"error": {"login_required"},
+			"state": {auth.State},
+		}))
+		return
+	}
+
+	c.Redirect(http.StatusFound, APP_URL+"/authorize?"+c.Request.URL.RawQuery)
+}
+
+/*
+approveAuthorization is a handler that takes the parameters of an authorization request from the request body,
+along with whether the user approves it. The user is the one logged in, or the one with the username and password
+of the request body, the users with MFA must log in first. With prompt=login the session is not enough,
+the username and password are required. It returns where to redirect the user back to the client,
+with the code if approved, or with the access_denied error if not.
+*/
+func (app *Config) approveAuthorization(c *gin.Context) {
+	var reqPayload struct {
+		authorizationRequest
+		Username string `form:"username" json:"username"`
+		Password string `form:"password" json:"password"`
+		Approve  bool   `form:"approve" json:"approve"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	auth, errorRedirect, ok := app.parseAuthorizationRequest(c, reqPayload.authorizationRequest)
+
+	if !ok {
+		return
+	}
+
+	if errorRedirect != "" {
+		sendRedirect(c, errorRedirect)
+		return
+	}
+
+	var user *data.User
+
+	if reqPayload.Username != "" || reqPayload.Password != "" {
+		user, ok = app.authenticatePassword(c, reqPayload.Username, reqPayload.Password)
+
+		if !ok {
+			return
+		}
+
+		methods, err := app.mfaMethods(*user)
+
+		if err != nil {
+			sendResponse("Failed to get MFA methods", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if len(methods) > 0 {
+			sendResponse("MFA required, log in first", "mfa required", map[string]any{
+				"mfa_required": true,
+			}, c, http.StatusUnauthorized)
+			return
+		}
+
+		if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+			err = app.Repo.ResetFailedLogins(*user)
+
+			if err != nil {
+				sendResponse("Failed to reset failed logins", err.Error(), nil, c, http.StatusInternalServerError)
+				return
+			}
+		}
+	} else if hasScope(auth.Prompt, "login") {
+		// the client asked for the user to log in again, not to reuse the session
+		sendResponse("Login required, send the username and password", "login required", map[string]any{
+			"login_required": true,
+		}, c, http.StatusUnauthorized)
+		return
+	} else {
+		user = app.sessionUser(c)
+
+		if user == nil {
+			sendResponse("Missing Username or Password in request", "missing username or password in request", nil, c, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if user.OrganizationID != auth.Client.OrganizationID || !reqPayload.Approve {
+		sendRedirect(c, authorizationRedirect(auth.RedirectURI, url.Values{
+			"error": {"access_denied"},
+			"state": {auth.State},
+		}))
+		return
+	}
+
+	enrollmentRequired, err := app.mfaEnrollmentRequired(*user)
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if enrollmentRequired {
+		sendResponse("MFA enrollment required", "mfa enrollment required", nil, c, http.StatusForbidden)
+		return
+	}
+
+	scopes, err := app.grantedScopes(*user, *auth)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.saveConsent(*user, auth.Client, scopes)
+
+	if err != nil {
+		sendResponse("Failed to save consent", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := app.issueAuthorizationCode(*user, *auth, scopes)
+
+	if err != nil {
+		sendResponse("Failed to create authorization code", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendRedirect(c, redirect)
+}
+
+/*
+parseAuthorizationRequest checks the client and the redirect URI of the authorization request, then its other parameters.
+If the client or the redirect URI is invalid, it sends the error response and returns false, as the user must not be
+redirected to an unknown URI. The other errors are returned as the redirect to the client with the error.
+PKCE with S256 is required for every client.
+*/
+func (app *Config) parseAuthorizationRequest(c *gin.Context, reqPayload authorizationRequest) (*authorization, string, bool) {
+	if reqPayload.ClientID == "" {
+		sendResponse("Missing client_id in request", "missing client_id in request", nil, c, http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	client, err := app.Repo.GetOAuthClient(reqPayload.ClientID)
+
+	if err != nil {
+		sendResponse("Failed to get client", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, "", false
+	}
+
+	if client.ID == "" {
+		sendResponse("Unknown client", "invalid_client", nil, c, http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	if !isRegisteredRedirectURI(*client, reqPayload.RedirectURI) {
+		sendResponse("Redirect URI is not registered for the client", "invalid redirect_uri", nil, c, http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	fail := func(code, description string) (*authorization, string, bool) {
+		return nil, authorizationRedirect(reqPayload.RedirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {reqPayload.State},
+		}), true
+	}
+
+	if reqPayload.ResponseType != "code" {
+		return fail("unsupported_response_type", "only the code response type is supported")
+	}
+
+	if !client.HasGrantType(data.GrantAuthorizationCode) {
+		return fail("unauthorized_client", "client is not registered for the authorization code grant")
+	}
+
+	if reqPayload.CodeChallenge == "" {
+		return fail("invalid_request", "code_challenge is required")
+	}
+
+	if reqPayload.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "code_challenge_method must be S256")
+	}
+
+	if challenge, err := base64.RawURLEncoding.DecodeString(reqPayload.CodeChallenge); err != nil || len(challenge) != sha256.Size {
+		return fail("invalid_request", "invalid code_challenge")
+	}
+
+	prompt := strings.Fields(reqPayload.Prompt)
+
+	for _, value := range prompt {
+		if value != "none" && value != "login" && value != "consent" {
+			return fail("invalid_request", "unsupported prompt "+value)
+		}
+	}
+
+	if hasScope(prompt, "none") && len(prompt) > 1 {
+		return fail("invalid_request", "prompt none can not be combined")
+	}
+
+	scopes := strings.Fields(reqPayload.Scope)
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	for _, scope := range scopes {
+		if !hasScope(client.Scopes, scope) {
+			return fail("invalid_scope", "scope "+scope+" is not allowed for the client")
+		}
+	}
+
+	return &authorization{
+		Client:        *client,
+		RedirectURI:   reqPayload.RedirectURI,
+		Scopes:        scopes,
+		State:         reqPayload.State,
+		CodeChallenge: reqPayload.CodeChallenge,
+		Nonce:         reqPayload.Nonce,
+		Prompt:        prompt,
+	}, "", true
+}
+
+/*
+grantedScopes returns the scopes of the authorization the user can give, the permissions the role of the user lacks are left out.
+*/
+func (app *Config) grantedScopes(user data.User, auth authorization) ([]string, error) {
+	role, err := app.Repo.GetRole(user.OrganizationID, user.Role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{}
+	for _, scope := range auth.Scopes {
+		if !isPermission(scope) || role.HasPermission(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+/*
+hasConsent reports whether the user already agreed to give every one of the scopes to the client.
+*/
+func (app *Config) hasConsent(user data.User, client data.OAuthClient, scopes []string) (bool, error) {
+	consent, err := app.Repo.GetOAuthConsent(user.ID, client.ID)
+
+	if err != nil || consent.ID == "" {
+		return false, err
+	}
+
+	for _, scope := range scopes {
+		if !hasScope(consent.Scopes, scope) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+/*
+saveConsent adds the scopes to the ones the user already agreed to give to the client.
+*/
+func (app *Config) saveConsent(user data.User, client data.OAuthClient, scopes []string) error {
+	consent, err := app.Repo.GetOAuthConsent(user.ID, client.ID)
+
+	if err != nil {
+		return err
+	}
+
+	consented := append([]string{}, consent.Scopes...)
+	for _, scope := range scopes {
+		if !hasScope(consented, scope) {
+			consented = append(consented, scope)
+		}
+	}
+
+	return app.Repo.SaveOAuthConsent(data.OAuthConsent{
+		UserID:   user.ID,
+		ClientID: client.ID,
+		Scopes:   consented,
+	})
+}
+
+/*
+issueAuthorizationCode creates the authorization code of the user for the client and returns the redirect to the client with it.
+*/
+func (app *Config) issueAuthorizationCode(user data.User, auth authorization, scopes []string) (string, error) {
+	code, err := randomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	err = app.Repo.InsertAuthorizationCode(data.AuthorizationCode{
+		ClientID:      auth.Client.ID,
+		UserID:        user.ID,
+		RedirectURI:   auth.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: auth.CodeChallenge,
+		Nonce:         auth.Nonce,
+		CodeHash:      hashToken(code),
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return authorizationRedirect(auth.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {auth.State},
+	}), nil
+}
+
+/*
+authorizationRedirect adds the parameters to the query of the redirect URI, the empty ones are left out.
+*/
+func authorizationRedirect(redirectURI string, params url.Values) string {
+	// the redirect URIs are checked when the client is registered
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query[name] = values
+		}
+	}
+
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+/*
+sendRedirect tells the page of the app where to redirect the user, as a fetch request can not follow it itself.
+*/
+func sendRedirect(c *gin.Context, redirect string) {
+	sendResponse("Redirect to the client", "", map[string]any{
+		"redirect_to": redirect,
+	}, c, http.StatusOK)
+}
+
+/*
+sessionUser returns the user logged in with the session cookie, or nil if there is none.
+The tokens issued to OAuth clients are not a session, so they can not authorize other clients.
+*/
+func (app *Config) sessionUser(c *gin.Context) *data.User {
+	authTokenString, err := c.Cookie("Authorization")
+
+	if err != nil || authTokenString == "" {
+		return nil
+	}
+
+	claims, err := app.parseAccessToken(authTokenString)
+
+	if err != nil || claims.Scopes != nil {
+		return nil
+	}
+
+	user, err := app.Repo.GetByID(claims.UserID)
+
+	if err != nil || user.ID == "" || isLocked(*user) {
+		return nil
+	}
+
+	return user
+}
+
+/*
+token is the token endpoint of the OAuth 2.0 authorization server. The clients authenticate with HTTP Basic,
+or with the client_id and client_secret of the form, the public clients only send their client_id.
+It exchanges an authorization code along with its PKCE verifier, or a refresh token, for an access token and
+a new refresh token. The machine clients get an access token of their own with the client credentials grant,
+and the devices poll for the tokens of the user approving their device authorization.
+A client can only use the grant types it is registered for. The errors follow RFC 6749, as the OAuth libraries expect.
+*/
+func (app *Config) token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := app.authenticateClient(c)
+
+	if !ok {
+		return
+	}
+
+	grantType := c.PostForm("grant_type")
+
+	switch grantType {
+	case data.GrantAuthorizationCode, data.GrantRefreshToken, data.GrantClientCredentials, data.GrantDeviceCode:
+		if !client.HasGrantType(grantType) {
+			sendOAuthError(c, http.StatusBadRequest, "unauthorized_client", "client is not registered for the "+grantType+" grant")
+			return
+		}
+	}
+
+	switch grantType {
+	case data.GrantAuthorizationCode:
+		app.authorizationCodeGrant(c, client)
+	case data.GrantRefreshToken:
+		app.refreshTokenGrant(c, client)
+	case data.GrantClientCredentials:
+		app.clientCredentialsGrant(c, client)
+	case data.GrantDeviceCode:
+		app.deviceCodeGrant(c, client)
+	case "":
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing grant_type")
+	default:
+		sendOAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant_type")
+	}
+}
+
+/*
+authenticateClient returns the client of the token request, checking the secret of the confidential clients.
+Otherwise it sends the invalid_client error and returns false.
+*/
+func (app *Config) authenticateClient(c *gin.Context) (*data.OAuthClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+
+	if basic {
+		// the credentials are form encoded before they are put in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	if clientID == "" {
+		sendOAuthError(c, http.StatusUnauthorized, "invalid_client", "missing client_id")
+		return nil, false
+	}
+
+	client, err := app.Repo.GetOAuthClient(clientID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil, false
+	}
+
+	valid := client.ID != ""
+
+	if valid && client.Confidential {
+		valid = secret != "" && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) == 1
+	}
+
+	if !valid {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		sendOAuthError(c, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+
+	return client, true
+}
+
+/*
+authorizationCodeGrant exchanges an authorization code of the client for the tokens of its user.
+The code can only be used once, a second use revokes the tokens it was exchanged for, as the code may have been stolen.
+*/
+func (app *Config) authorizationCodeGrant(c *gin.Context, client *data.OAuthClient) {
+	code := c.PostForm("code")
+
+	if code == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing code")
+		return
+	}
+
+	storedCode, err := app.Repo.ConsumeAuthorizationCode(hashToken(code))
+
+	if errors.Is(err, data.ErrAuthorizationCodeReused) {
+		if storedCode.ClientID == client.ID {
+			// the code is the session of the tokens it was exchanged for
+			revokeErr := app.revokeSession(storedCode.ID)
+
+			if revokeErr != nil {
+				log.Println("@OAUTH Failed to revoke the tokens of a reused code:", revokeErr)
+			}
+		}
+
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	if errors.Is(err, data.ErrInvalidAuthorizationCode) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if storedCode.ClientID != client.ID || storedCode.RedirectURI != c.PostForm("redirect_uri") {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "code was issued to another client or redirect_uri")
+		return
+	}
+
+	if !verifyCodeChallenge(c.PostForm("code_verifier"), storedCode.CodeChallenge) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	user, err := app.Repo.GetByID(storedCode.UserID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if user.ID == "" || isLocked(*user) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "user does not exist or is locked")
+		return
+	}
+
+	app.issueOAuthTokens(c, *user, *client, storedCode.ID, storedCode.Scope, storedCode.Nonce)
+}
+
+/*
+issueOAuthTokens starts the refresh token family of an authorization of the user for the client,
+and sends its first refresh token along with the access token.
+*/
+func (app *Config) issueOAuthTokens(c *gin.Context, user data.User, client data.OAuthClient, sessionID, scope, nonce string) {
+	refreshTokenString, refreshToken, err := app.newRefreshToken(user, sessionID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	refreshToken.ClientID = client.ID
+	refreshToken.Scope = scope
+
+	err = app.Repo.InsertRefreshToken(refreshToken)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	app.sendOAuthTokens(c, user, client, sessionID, strings.Fields(scope), nonce, refreshTokenString)
+}
+
+/*
+refreshTokenGrant exchanges a refresh token of the client for a new access token and a new refresh token,
+rotating them like the refresh tokens of the login sessions. The access token can be asked for with fewer scopes.
+*/
+func (app *Config) refreshTokenGrant(c *gin.Context, client *data.OAuthClient) {
+	refreshTokenString := c.PostForm("refresh_token")
+
+	if refreshTokenString == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "missing refresh_token")
+		return
+	}
+
+	storedToken, err := app.Repo.GetRefreshToken(hashToken(refreshTokenString))
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if storedToken.ID == "" || storedToken.ClientID != client.ID || storedToken.RevokedAt != nil ||
+		time.Now().After(storedToken.ExpiresAt) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return
+	}
+
+	if storedToken.UsedAt != nil {
+		app.oauthRefreshTokenReused(c, *storedToken)
+		return
+	}
+
+	scopes := strings.Fields(storedToken.Scope)
+
+	if requested := strings.Fields(c.PostForm("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !hasScope(scopes, scope) {
+				sendOAuthError(c, http.StatusBadRequest, "invalid_scope", "scope "+scope+" was not granted")
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	user, err := app.Repo.GetByID(storedToken.UserID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if user.ID == "" || isLocked(*user) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "user does not exist or is locked")
+		return
+	}
+
+	nextTokenString, nextToken, err := app.newRefreshToken(*user, storedToken.FamilyID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	// the refresh token keeps every scope granted, only the access token is narrowed
+	nextToken.ClientID = client.ID
+	nextToken.Scope = storedToken.Scope
+
+	err = app.Repo.RotateRefreshToken(*storedToken, nextToken)
+
+	if err != nil {
+		if errors.Is(err, data.ErrRefreshTokenReused) {
+			app.oauthRefreshTokenReused(c, *storedToken)
+			return
+		}
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	// the nonce is only for the ID token of the authorization
+	app.sendOAuthTokens(c, *user, *client, storedToken.FamilyID, scopes, "", nextTokenString)
+}
+
+/*
+oauthRefreshTokenReused revokes the whole family of a refresh token of a client which was presented after it had already been used.
+*/
+func (app *Config) oauthRefreshTokenReused(c *gin.Context, token data.RefreshToken) {
+	err := app.revokeSession(token.FamilyID)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "refresh token reuse detected")
+}
+
+/*
+sendOAuthTokens sends a new access token of the user for the client along with the refresh token, as RFC 6749 describes.
+With the openid scope, the ID token of the user is sent too.
+*/
+func (app *Config) sendOAuthTokens(c *gin.Context, user data.User, client data.OAuthClient, sessionID string, scopes []string, nonce, refreshToken string) {
+	accessToken, err := app.newOAuthAccessToken(user, client, sessionID, scopes)
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	response := gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(scopes, " "),
+	}
+
+	if hasScope(scopes, scopeOpenID) {
+		idToken, err := app.newIDToken(user, client, scopes, nonce)
+
+		if err != nil {
+			sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		response["id_token"] = idToken
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+newOAuthAccessToken creates a short lived JWT access token of the user for the client, like the ones of the login sessions.
+The id of the client and the granted scopes are added, the scopes limit the permissions of the role of the user.
+The issuer and the standard sub claim are added too, for the resource servers using an OAuth library.
+*/
+func (app *Config) newOAuthAccessToken(user data.User, client data.OAuthClient, sessionID string, scopes []string) (string, error) {
+	now := time.Now()
+
+	return app.Keys.Sign(jwt.MapClaims{
+		"userId":    user.ID,
+		"sub_type":  subjectUser,
+		"jti":       uuid.NewString(),
+		"sid":       sessionID,
+		"iat":       float64(now.UnixMicro()) / 1e6,
+		"exp":       now.Add(accessTokenTTL).Unix(),
+		"client_id": client.ID,
+		"scope":     strings.Join(scopes, " "),
+		"iss":       app.Issuer,
+		"sub":       user.ID,
+	})
+}
+
+/*
+sendOAuthError sends an error of the token endpoint, with the error code of RFC 6749.
+*/
+func sendOAuthError(c *gin.Context, code int, err, description string) {
+	c.JSON(code, gin.H{
+		"error":             err,
+		"error_description": description,
+	})
+}
+
+/*
+verifyCodeChallenge reports whether the PKCE verifier matches the S256 challenge of the authorization request.
+The verifier must be 43 to 128 of the unreserved characters of RFC 7636.
+*/
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, r := range verifier {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)) {
+			return false
+		}
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+/*
+hasScope reports whether the scope is one of the scopes.
+*/
+func hasScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
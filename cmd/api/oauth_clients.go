@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+createOAuthClient is a handler that takes the name, the redirect URIs, the scopes and the grant types of a new OAuth client
+from the request body and registers it for the organization of the current user. A confidential client gets a secret,
+which is only returned this once. It can only be called by a user with the clients:manage permission.
+A machine client, registered for the client_credentials grant, must be confidential and needs no redirect URIs.
+As its tokens act for no user, it can only get the permissions the role of the current user grants.
+*/
+func (app *Config) createOAuthClient(c *gin.Context) {
+	currentUser := currentUser(c)
+
+	var reqPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		GrantTypes   []string `json:"grant_types"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(reqPayload.Name)
+
+	if name == "" {
+		sendResponse("Missing client name in request", "missing client name in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	grantTypes := []string{}
+	for _, grantType := range reqPayload.GrantTypes {
+		if grantType != data.GrantAuthorizationCode && grantType != data.GrantRefreshToken &&
+			grantType != data.GrantClientCredentials && grantType != data.GrantDeviceCode {
+			sendResponse("Unknown grant type", "unknown grant type "+grantType, nil, c, http.StatusBadRequest)
+			return
+		}
+		if !hasScope(grantTypes, grantType) {
+			grantTypes = append(grantTypes, grantType)
+		}
+	}
+
+	if len(grantTypes) == 0 {
+		grantTypes = data.DefaultGrantTypes
+	}
+
+	machine := hasScope(grantTypes, data.GrantClientCredentials)
+
+	if machine && !reqPayload.Confidential {
+		sendResponse("Machine clients must be confidential", "the client_credentials grant needs a confidential client", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if hasScope(grantTypes, data.GrantAuthorizationCode) && len(reqPayload.RedirectURIs) == 0 {
+		sendResponse("Missing redirect URIs in request", "missing redirect uris in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	for _, redirectURI := range reqPayload.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			sendResponse("Invalid redirect URI", err.Error()+": "+redirectURI, nil, c, http.StatusBadRequest)
+			return
+		}
+	}
+
+	scopes := []string{}
+	for _, scope := range reqPayload.Scopes {
+		if !isOAuthScope(scope) {
+			sendResponse("Unknown scope", "unknown scope "+scope, nil, c, http.StatusBadRequest)
+			return
+		}
+		if !hasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if machine {
+		role, err := app.Repo.GetRole(currentUser.OrganizationID, currentUser.Role)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		for _, scope := range scopes {
+			if isPermission(scope) && !role.HasPermission(scope) {
+				sendResponse("Not Authorized", "missing permission "+scope, nil, c, http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	client := data.OAuthClient{
+		OrganizationID: currentUser.OrganizationID,
+		Name:           name,
+		Confidential:   reqPayload.Confidential,
+		RedirectURIs:   reqPayload.RedirectURIs,
+		Scopes:         scopes,
+		GrantTypes:     grantTypes,
+		CreatedBy:      currentUser.ID,
+	}
+
+	var secret string
+
+	if client.Confidential {
+		secret, err = randomToken()
+
+		if err != nil {
+			sendResponse("Failed to create client secret", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		client.SecretHash = hashToken(secret)
+	}
+
+	created, err := app.Repo.InsertOAuthClient(client)
+
+	if err != nil {
+		sendResponse("Failed to create client", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]any{
+		"client": created,
+	}
+
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+
+	sendResponse("Successfully created client", "", response, c, http.StatusOK)
+}
+
+/*
+allOAuthClients is a handler that returns the OAuth clients of the organization of the current user.
+*/
+func (app *Config) allOAuthClients(c *gin.Context) {
+	clients, err := app.Repo.GetOAuthClients(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get clients", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get clients", "", map[string]any{
+		"clients": clients,
+	}, c, http.StatusOK)
+}
+
+/*
+deleteOAuthClient is a handler that deletes an OAuth client of the organization of the current user.
+The refresh tokens issued to the client stop working, its access tokens expire on their own.
+*/
+func (app *Config) deleteOAuthClient(c *gin.Context) {
+	client, ok := app.oauthClientFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	err := app.Repo.DeleteOAuthClient(*client)
+
+	if err != nil {
+		sendResponse("Failed to delete client", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully deleted client", "", nil, c, http.StatusOK)
+}
+
+/*
+oauthClientFromPath returns the client from the `id` path parameter, if it belongs to the organization of the current user.
+Otherwise it sends the error response and returns false.
+*/
+func (app *Config) oauthClientFromPath(c *gin.Context) (*data.OAuthClient, bool) {
+	client, err := app.Repo.GetOAuthClient(c.Param("id"))
+
+	if err != nil {
+		sendResponse("Failed to get client", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if client.ID == "" || client.OrganizationID != currentUser(c).OrganizationID {
+		sendResponse("Client does not exist", "client does not exist", nil, c, http.StatusNotFound)
+		return nil, false
+	}
+
+	return client, true
+}
+
+/*
+validateRedirectURI checks that the redirect URI is an absolute https URL without a fragment.
+Plain http is only allowed on the loopback interface, for the apps running on the machine of the user.
+*/
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.User != nil {
+		return errors.New("redirect uri must be an absolute url")
+	}
+
+	if parsed.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return errors.New("redirect uri must not have a fragment")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+
+	return errors.New("redirect uri must use https, or http on the loopback interface")
+}
+
+/*
+isRegisteredRedirectURI reports whether the redirect URI is exactly one of the redirect URIs of the client.
+*/
+func isRegisteredRedirectURI(client data.OAuthClient, redirectURI string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isOAuthScope reports whether the scope can be asked for by the OAuth clients, the scopes are the ones of OpenID Connect
+and the permissions of the roles.
+*/
+func isOAuthScope(scope string) bool {
+	return scope == scopeOpenID || scope == scopeProfile || scope == scopeEmail || isPermission(scope)
+}
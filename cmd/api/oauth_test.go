@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "test-code-verifier-which-is-long-enough-for-pkce"
+)
+
+/*
+Testing the registration of the OAuth clients
+
+	-> The redirect URIs must be https, or http on the loopback interface, without a fragment
+	-> The scopes must be permissions
+	-> A confidential client gets its secret once, and can be deleted
+*/
+func Test_OAuthClients(t *testing.T) {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	invalidClients := []string{
+		`{"name":"App","redirect_uris":["http://app.example.com/callback"]}`,
+		`{"name":"App","redirect_uris":["https://app.example.com/callback#fragment"]}`,
+		`{"name":"App","redirect_uris":["/callback"]}`,
+		`{"name":"App","redirect_uris":[]}`,
+		`{"name":"","redirect_uris":["https://app.example.com/callback"]}`,
+		`{"name":"App","redirect_uris":["https://app.example.com/callback"],"scopes":["unknown:scope"]}`,
+	}
+
+	for _, body := range invalidClients {
+		reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients", body, token)
+
+		if reqRecorder.Code != http.StatusBadRequest {
+			t.Errorf("FAILED: Expected %d get %d for %s", http.StatusBadRequest, reqRecorder.Code, body)
+		}
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"Backend","redirect_uris":["http://127.0.0.1:8080/callback"],"scopes":["users:read"],"confidential":true}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	response := responseData(t, reqRecorder)
+	client, _ := response["client"].(map[string]any)
+	id, _ := client["id"].(string)
+
+	if secret, _ := response["client_secret"].(string); secret == "" || client["confidential"] != true {
+		t.Errorf("FAILED: Expected a confidential client with its secret get %v", response)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"unknown"},
+		"client_id":     {id},
+		"client_secret": {"wrong-secret"},
+	})
+
+	if reqRecorder.Code != http.StatusUnauthorized || oauthErrorCode(t, reqRecorder) != "invalid_client" {
+		t.Errorf("FAILED: Expected invalid_client get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	memberToken, err := signJWTTestTokenFor(testApp.Keys, "random-test-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/oauth/clients", "", memberToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/oauth/clients/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/oauth/clients/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the authorization code flow with PKCE
+
+	-> An unknown redirect URI is refused, the other errors go back to the client
+	-> A user who did not consent yet is sent to the page of the app
+	-> The user logs in with the password and approves, the code is only exchanged with the PKCE verifier, once
+	-> A logged in user who already consented gets the code right away
+	-> The access token is limited to the granted scopes and can not use the account endpoints
+	-> The refresh token rotates, and is not a login session
+*/
+func Test_OAuthAuthorizationCode(t *testing.T) {
+	clientID := createTestOAuthClient(t, `["users:read","users:delete"]`)
+
+	query := authorizationTestQuery(clientID, "users:read users:delete")
+
+	reqRecorder := serve(router, http.MethodGet, "/oauth/authorize?"+strings.Replace(query, "app.example.com", "evil.example.com", 1), "", "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+strings.Replace(query, "S256", "plain", 1), "", "")
+
+	if location := redirectQuery(t, reqRecorder); location.Get("error") != "invalid_request" || location.Get("state") != "test-state" {
+		t.Errorf("FAILED: Expected the invalid_request error sent back to the client get %v", location)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+query, "", "")
+
+	if reqRecorder.Code != http.StatusFound || reqRecorder.Header().Get("Location") != APP_URL+"/authorize?"+query {
+		t.Errorf("FAILED: Expected the redirect to the page of the app get %d %s", reqRecorder.Code, reqRecorder.Header().Get("Location"))
+	}
+
+	// the member logs in with the password, the code is used up by the wrong verifier
+	code := approveTestAuthorization(t, clientID, "users:read users:delete", `"username":"test-username","password":"password",`, "")
+
+	reqRecorder = exchangeTestCode(clientID, code, "wrong-code-verifier-which-is-long-enough-for-pkce")
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_grant" {
+		t.Errorf("FAILED: Expected invalid_grant get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	reqRecorder = exchangeTestCode(clientID, code, testCodeVerifier)
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_grant" {
+		t.Errorf("FAILED: Expected the used code to be refused get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	// the admin is logged in, and approves with the session
+	adminToken, err := signJWTTestTokenFor(testApp.Keys, "oauth-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	query = authorizationTestQuery(clientID, "users:read")
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+query, "", adminToken)
+
+	if !strings.HasPrefix(reqRecorder.Header().Get("Location"), APP_URL) {
+		t.Errorf("FAILED: Expected the admin to be asked for the consent get %s", reqRecorder.Header().Get("Location"))
+	}
+
+	code = approveTestAuthorization(t, clientID, "users:read", "", adminToken)
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+query, "", adminToken)
+
+	if location := redirectQuery(t, reqRecorder); location.Get("code") == "" || location.Get("state") != "test-state" {
+		t.Errorf("FAILED: Expected the code right away after the consent get %v", location)
+	}
+
+	reqRecorder = exchangeTestCode(clientID, code, testCodeVerifier)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+
+	if tokens["token_type"] != "Bearer" || tokens["scope"] != "users:read" || tokens["refresh_token"] == "" {
+		t.Errorf("FAILED: Expected the tokens with the users:read scope get %v", tokens)
+	}
+
+	accessToken, _ := tokens["access_token"].(string)
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/users", accessToken); reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodDelete, "/v1/delete", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the scope to limit the admin get %d", reqRecorder.Code)
+	}
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/v1/me", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the account endpoints to need a session get %d", reqRecorder.Code)
+	}
+
+	refreshToken, _ := tokens["refresh_token"].(string)
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/token/refresh", `{"refresh_token":"`+refreshToken+`"}`, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected the refresh token of a client not to be a session get %d", reqRecorder.Code)
+	}
+
+	refreshForm := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "client_id": {clientID}}
+
+	reqRecorder = serveForm(router, "/oauth/token", refreshForm)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	nextRefreshToken, _ := oauthTokens(t, reqRecorder)["refresh_token"].(string)
+
+	reqRecorder = serveForm(router, "/oauth/token", refreshForm)
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "invalid_grant" {
+		t.Errorf("FAILED: Expected the reused refresh token to be refused get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {nextRefreshToken},
+		"client_id":     {clientID},
+	})
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected the reuse to revoke the whole family get %d", reqRecorder.Code)
+	}
+}
+
+/*
+Testing that a user can deny the authorization, and that the unsupported grant types are refused
+*/
+func Test_OAuthDenied(t *testing.T) {
+	clientID := createTestOAuthClient(t, `["users:read"]`)
+
+	body := `{"response_type":"code","client_id":"` + clientID + `","redirect_uri":"` + testRedirectURI +
+		`","state":"test-state","code_challenge":"` + testCodeChallenge() + `","code_challenge_method":"S256",` +
+		`"username":"test-username","password":"password","approve":false}`
+
+	reqRecorder := serve(router, http.MethodPost, "/oauth/authorize", body, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	redirect, _ := url.Parse(responseData(t, reqRecorder)["redirect_to"].(string))
+
+	if redirect.Query().Get("error") != "access_denied" || redirect.Query().Get("code") != "" {
+		t.Errorf("FAILED: Expected the access_denied error get %s", redirect)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/authorize", strings.Replace(body, `"password":"password"`, `"password":"wrong-password"`, 1), "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serveForm(router, "/oauth/token", url.Values{"grant_type": {"password"}, "client_id": {clientID}})
+
+	if reqRecorder.Code != http.StatusBadRequest || oauthErrorCode(t, reqRecorder) != "unsupported_grant_type" {
+		t.Errorf("FAILED: Expected unsupported_grant_type get %d", reqRecorder.Code)
+	}
+}
+
+// createTestOAuthClient registers a public client of the mocked organization with the scopes and returns its id
+func createTestOAuthClient(t *testing.T, scopes string) string {
+	token, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/oauth/clients",
+		`{"name":"Test App","redirect_uris":["`+testRedirectURI+`"],"scopes":`+scopes+`}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	client, _ := responseData(t, reqRecorder)["client"].(map[string]any)
+	id, _ := client["id"].(string)
+	return id
+}
+
+func testCodeChallenge() string {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorizationTestQuery(clientID, scope string) string {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"test-state"},
+		"code_challenge":        {testCodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+}
+
+// approveTestAuthorization approves the authorization of the scope and returns the code
+func approveTestAuthorization(t *testing.T, clientID, scope, credentials, accessToken string) string {
+	body := `{"response_type":"code","client_id":"` + clientID + `","redirect_uri":"` + testRedirectURI +
+		`","scope":"` + scope + `","state":"test-state","code_challenge":"` + testCodeChallenge() +
+		`","code_challenge_method":"S256",` + credentials + `"approve":true}`
+
+	reqRecorder := serve(router, http.MethodPost, "/oauth/authorize", body, accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	redirectTo, _ := responseData(t, reqRecorder)["redirect_to"].(string)
+	redirect, err := url.Parse(redirectTo)
+
+	if err != nil || !strings.HasPrefix(redirectTo, testRedirectURI) || redirect.Query().Get("state") != "test-state" {
+		t.Fatalf("FAILED: Expected the redirect to the client get %s", redirectTo)
+	}
+
+	return redirect.Query().Get("code")
+}
+
+func exchangeTestCode(clientID, code, verifier string) *httptest.ResponseRecorder {
+	return serveForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	})
+}
+
+// redirectQuery returns the query of the redirect of the response
+func redirectQuery(t *testing.T, reqRecorder *httptest.ResponseRecorder) url.Values {
+	if reqRecorder.Code != http.StatusFound {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusFound, reqRecorder.Code)
+	}
+
+	location, err := url.Parse(reqRecorder.Header().Get("Location"))
+
+	if err != nil {
+		t.Fatalf("Failed to parse the redirect: %s", err.Error())
+	}
+
+	return location.Query()
+}
+
+func oauthTokens(t *testing.T, reqRecorder *httptest.ResponseRecorder) map[string]any {
+	var tokens map[string]any
+
+	err := json.Unmarshal(reqRecorder.Body.Bytes(), &tokens)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	return tokens
+}
+
+func oauthErrorCode(t *testing.T, reqRecorder *httptest.ResponseRecorder) string {
+	code, _ := oauthTokens(t, reqRecorder)["error"].(string)
+	return code
+}
+
+func serveForm(testRouter *gin.Engine, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	reqRecorder := httptest.NewRecorder()
+	testRouter.ServeHTTP(reqRecorder, req)
+	return reqRecorder
+}
+
+func serveBearer(testRouter *gin.Engine, method, target, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	reqRecorder := httptest.NewRecorder()
+	testRouter.ServeHTTP(reqRecorder, req)
+	return reqRecorder
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of OpenID Connect, the other scopes are the permissions
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+const idTokenTTL = time.Hour
+
+/*
+openIDConfiguration is a handler that publishes the OpenID Connect discovery document,
+so that the OIDC libraries find the endpoints, the keys and what is supported on their own.
+*/
+func (app *Config) openIDConfiguration(c *gin.Context) {
+	algs := []string{}
+	for _, key := range app.Keys.Records() {
+		if !hasScope(algs, key.Algorithm) {
+			algs = append(algs, key.Algorithm)
+		}
+	}
+
+	scopes := append([]string{scopeOpenID, scopeProfile, scopeEmail}, data.Permissions...)
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                        app.Issuer,
+		"authorization_endpoint":                        app.Issuer + "/oauth/authorize",
+		"token_endpoint":                                app.Issuer + "/oauth/token",
+		"userinfo_endpoint":                             app.Issuer + "/userinfo",
+		"jwks_uri":                                      app.Issuer + "/.well-known/jwks.json",
+		"introspection_endpoint":                        app.Issuer + "/oauth/introspect",
+		"revocation_endpoint":                           app.Issuer + "/oauth/revoke",
+		"device_authorization_endpoint":                 app.Issuer + "/oauth/device_authorization",
+		"scopes_supported":                              scopes,
+		"response_types_supported":                      []string{"code"},
+		"response_modes_supported":                      []string{"query"},
+		"grant_types_supported":                         []string{data.GrantAuthorizationCode, data.GrantRefreshToken, data.GrantClientCredentials, data.GrantDeviceCode},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         algs,
+		"token_endpoint_auth_methods_supported":         []string{"client_secret_basic", "client_secret_post", "none"},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":              []string{"S256"},
+		"prompt_values_supported":                       []string{"none", "login", "consent"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "nonce", "azp", "org_id", "role", "preferred_username",
+			"name", "picture", "locale", "zoneinfo", "updated_at", "email", "email_verified",
+		},
+	})
+}
+
+/*
+userInfo is a handler that returns the claims of the user of the access token, which must have the openid scope.
+The tokens of the machine clients have no user, and are refused like the ones without the scope.
+The profile and email scopes add the claims of the profile and of the email.
+*/
+func (app *Config) userInfo(c *gin.Context) {
+	scopes, _ := c.Get("scopes")
+	granted, _ := scopes.([]string)
+
+	if subjectType, _ := c.Get("subjectType"); subjectType != subjectUser || !hasScope(granted, scopeOpenID) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		sendOAuthError(c, http.StatusForbidden, "insufficient_scope", "the openid scope is required")
+		return
+	}
+
+	userId, _ := c.Get("userId")
+
+	user, err := app.Repo.GetByID(userId.(string))
+
+	if err != nil {
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	if user.ID == "" {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		sendOAuthError(c, http.StatusUnauthorized, "invalid_token", "user does not exist")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, userClaims(*user, granted))
+}
+
+/*
+newIDToken creates the ID token of the user for the client, with the claims of the scopes and the nonce of the authorization request.
+*/
+func (app *Config) newIDToken(user data.User, client data.OAuthClient, scopes []string, nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims(userClaims(user, scopes))
+
+	claims["iss"] = app.Issuer
+	claims["aud"] = client.ID
+	claims["azp"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenTTL).Unix()
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return app.Keys.Sign(claims)
+}
+
+/*
+userClaims returns the claims about the user for the scopes, the ones without a value are left out.
+The sub is the id of the user, and the organization and the role are always given.
+*/
+func userClaims(user data.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub":                user.ID,
+		"org_id":             user.OrganizationID,
+		"role":               user.Role,
+		"preferred_username": user.Username,
+	}
+
+	if hasScope(scopes, scopeProfile) {
+		profile := map[string]string{
+			"name":     user.DisplayName,
+			"picture":  user.AvatarURL,
+			"locale":   user.Locale,
+			"zoneinfo": user.Timezone,
+		}
+
+		for claim, value := range profile {
+			if value != "" {
+				claims[claim] = value
+			}
+		}
+
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+
+	if hasScope(scopes, scopeEmail) && user.Email != nil {
+		claims["email"] = *user.Email
+		claims["email_verified"] = user.EmailVerifiedAt != nil
+	}
+
+	return claims
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Testing the OpenID Connect discovery document
+
+	-> The issuer and the endpoints are under the configured issuer
+	-> The algorithm of the signing key is listed
+*/
+func Test_OpenIDConfiguration(t *testing.T) {
+	reqRecorder := serve(router, http.MethodGet, "/.well-known/openid-configuration", "", "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	var configuration struct {
+		Issuer          string   `json:"issuer"`
+		UserInfo        string   `json:"userinfo_endpoint"`
+		JWKS            string   `json:"jwks_uri"`
+		Scopes          []string `json:"scopes_supported"`
+		SigningAlgs     []string `json:"id_token_signing_alg_values_supported"`
+		ChallengeMethod []string `json:"code_challenge_methods_supported"`
+	}
+
+	err := json.Unmarshal(reqRecorder.Body.Bytes(), &configuration)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	if configuration.Issuer != testApp.Issuer || configuration.UserInfo != testApp.Issuer+"/userinfo" ||
+		configuration.JWKS != testApp.Issuer+"/.well-known/jwks.json" {
+		t.Errorf("FAILED: Expected the endpoints under %s get %+v", testApp.Issuer, configuration)
+	}
+
+	if !hasScope(configuration.Scopes, "openid") || !hasScope(configuration.Scopes, "users:read") {
+		t.Errorf("FAILED: Expected the openid scope and the permissions get %v", configuration.Scopes)
+	}
+
+	if !hasScope(configuration.SigningAlgs, testApp.Keys.Current().Method.Alg()) {
+		t.Errorf("FAILED: Expected the algorithm of the signing key get %v", configuration.SigningAlgs)
+	}
+}
+
+/*
+Testing the ID token and the userinfo endpoint
+
+	-> With prompt=none the client gets login_required or consent_required instead of the page
+	-> With prompt=login the session is not enough to approve, the password is required
+	-> The ID token is signed by the key ring, for the client, with the nonce and the claims of the user
+	-> The userinfo endpoint returns the same claims, and needs the openid scope
+*/
+func Test_OpenIDConnect(t *testing.T) {
+	clientID := createTestOAuthClient(t, `["openid","profile","users:read"]`)
+
+	adminToken, err := signJWTTestTokenFor(testApp.Keys, "oidc-admin-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	query := authorizationTestQuery(clientID, "openid profile") + "&prompt=none"
+
+	reqRecorder := serve(router, http.MethodGet, "/oauth/authorize?"+query, "", "")
+
+	if location := redirectQuery(t, reqRecorder); location.Get("error") != "login_required" {
+		t.Errorf("FAILED: Expected login_required get %v", location)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+query, "", adminToken)
+
+	if location := redirectQuery(t, reqRecorder); location.Get("error") != "consent_required" {
+		t.Errorf("FAILED: Expected consent_required get %v", location)
+	}
+
+	loginBody := `{"response_type":"code","client_id":"` + clientID + `","redirect_uri":"` + testRedirectURI +
+		`","scope":"openid","state":"test-state","code_challenge":"` + testCodeChallenge() +
+		`","code_challenge_method":"S256","prompt":"login","approve":true}`
+
+	reqRecorder = serve(router, http.MethodPost, "/oauth/authorize", loginBody, adminToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	approveTestAuthorization(t, clientID, "openid", `"prompt":"login","username":"test-username","password":"password",`, adminToken)
+
+	code := approveTestAuthorization(t, clientID, "openid profile", `"nonce":"test-nonce",`, adminToken)
+
+	reqRecorder = exchangeTestCode(clientID, code, testCodeVerifier)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+
+	tokens := oauthTokens(t, reqRecorder)
+	idToken, _ := tokens["id_token"].(string)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, testApp.Keys.Keyfunc,
+		jwt.WithIssuer(testApp.Issuer), jwt.WithAudience(clientID))
+
+	if err != nil {
+		t.Fatalf("FAILED: Expected a valid ID token get %s", err.Error())
+	}
+
+	if claims["sub"] != "oidc-admin-id" || claims["org_id"] != "test-org-1" || claims["role"] != "admin" ||
+		claims["preferred_username"] != "test-username" || claims["nonce"] != "test-nonce" {
+		t.Errorf("FAILED: Expected the claims of the user with the nonce get %v", claims)
+	}
+
+	accessToken, _ := tokens["access_token"].(string)
+
+	reqRecorder = serveBearer(router, http.MethodGet, "/userinfo", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	userInfo := oauthTokens(t, reqRecorder)
+
+	if userInfo["sub"] != "oidc-admin-id" || userInfo["preferred_username"] != "test-username" || userInfo["updated_at"] == nil {
+		t.Errorf("FAILED: Expected the claims of the user get %v", userInfo)
+	}
+
+	// a user who consented is not asked again
+	reqRecorder = serve(router, http.MethodGet, "/oauth/authorize?"+query, "", adminToken)
+
+	if location := redirectQuery(t, reqRecorder); location.Get("code") == "" {
+		t.Errorf("FAILED: Expected the code without a prompt get %v", location)
+	}
+
+	code = approveTestAuthorization(t, clientID, "users:read", "", adminToken)
+	tokens = oauthTokens(t, exchangeTestCode(clientID, code, testCodeVerifier))
+
+	if tokens["id_token"] != nil {
+		t.Errorf("FAILED: Expected no ID token without the openid scope get %v", tokens["id_token"])
+	}
+
+	accessToken, _ = tokens["access_token"].(string)
+
+	if reqRecorder = serveBearer(router, http.MethodGet, "/userinfo", accessToken); reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+}
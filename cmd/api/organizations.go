@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+createOrganization is a handler that takes the name of a new organization and the username and password of
+its first admin from the request body, and creates both.
+It can only be called by a platform admin.
+*/
+func (app *Config) createOrganization(c *gin.Context) {
+	var reqPayload struct {
+		Name  string `json:"name"`
+		Admin struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"admin"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(reqPayload.Name)
+	username := reqPayload.Admin.Username
+	password := reqPayload.Admin.Password
+
+	if name == "" || username == "" || password == "" {
+		sendResponse("Missing Organization Name, Username or Password in request", "missing organization name, username or password in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	// the organization does not exist yet, so its first admin follows the default policy
+	if !app.checkPassword(c, data.DefaultPasswordPolicy(), data.User{Username: username}, password) {
+		return
+	}
+
+	org, err := app.Repo.CreateOrganization(data.Organization{Name: name}, data.User{
+		Username: username,
+		Password: password,
+	})
+
+	if err != nil {
+		sendCreateOrganizationError(c, err)
+		return
+	}
+
+	sendResponse("Successfully created organization", "", map[string]any{
+		"organization": org,
+	}, c, http.StatusOK)
+}
+
+/*
+signup is a handler that takes the name of a new organization and the username and password of its first admin
+from the request body, creates both in one transaction and signs the admin in the same way login does.
+It is public, so that a new customer can get an organization without an operator.
+*/
+func (app *Config) signup(c *gin.Context) {
+	var reqPayload struct {
+		Organization string `json:"organization"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(reqPayload.Organization)
+	username := reqPayload.Username
+	password := reqPayload.Password
+
+	if name == "" || username == "" || password == "" {
+		sendResponse("Missing Organization, Username or Password in request", "missing organization, username or password in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	// the organization does not exist yet, so its first admin follows the default policy
+	if !app.checkPassword(c, data.DefaultPasswordPolicy(), data.User{Username: username}, password) {
+		return
+	}
+
+	org, err := app.Repo.CreateOrganization(data.Organization{Name: name}, data.User{
+		Username: username,
+		Password: password,
+	})
+
+	if err != nil {
+		sendCreateOrganizationError(c, err)
+		return
+	}
+
+	admin := org.Users[0]
+
+	err = app.startSession(c, admin)
+
+	if err != nil {
+		sendResponse("Failed to create session", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	org.Users = nil
+
+	sendResponse("Successfully signed up", "", map[string]any{
+		"user":         admin,
+		"organization": org,
+	}, c, http.StatusOK)
+}
+
+/*
+sendCreateOrganizationError sends 409 for a taken organization name or username, and 500 for any other error.
+*/
+func sendCreateOrganizationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateOrganization):
+		sendResponse("Organization name already taken", err.Error(), nil, c, http.StatusConflict)
+	case errors.Is(err, data.ErrDuplicateUsername):
+		sendResponse("Username already taken", err.Error(), nil, c, http.StatusConflict)
+	default:
+		sendResponse("Failed to create organization", err.Error(), nil, c, http.StatusInternalServerError)
+	}
+}
+
+/*
+allOrganizations is a handler that returns every organization with the number of its members.
+It can only be called by a platform admin.
+*/
+func (app *Config) allOrganizations(c *gin.Context) {
+	orgs, err := app.Repo.GetOrganizations()
+
+	if err != nil {
+		sendResponse("Failed to get organizations", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get all organizations", "", map[string]any{
+		"organizations": orgs,
+	}, c, http.StatusOK)
+}
+
+/*
+renameOrganization is a handler that takes the new name of the organization from the request body.
+It can only be called by a platform admin.
+*/
+func (app *Config) renameOrganization(c *gin.Context) {
+	org, ok := app.organizationFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Name string `json:"name"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(reqPayload.Name)
+
+	if name == "" {
+		sendResponse("Missing Organization Name in request", "missing organization name in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.RenameOrganization(*org, name)
+
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateOrganization) {
+			sendResponse("Organization name already taken", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to rename organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	org.Name = name
+
+	sendResponse("Successfully renamed organization", "", map[string]any{
+		"organization": org,
+	}, c, http.StatusOK)
+}
+
+/*
+deleteOrganization is a handler that deletes an organization.
+An organization which still has users is only deleted along with its users when `?cascade=true` is set.
+It can only be called by a platform admin.
+*/
+func (app *Config) deleteOrganization(c *gin.Context) {
+	org, ok := app.organizationFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	cascade := c.Query("cascade") == "true"
+
+	err := app.Repo.DeleteOrganization(*org, cascade)
+
+	if err != nil {
+		if errors.Is(err, data.ErrOrganizationNotEmpty) {
+			sendResponse("Organization still has users, delete them first or set cascade=true", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to delete organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully deleted organization", "", nil, c, http.StatusOK)
+}
+
+/*
+organizationFromPath returns the organization from the `id` path parameter.
+Otherwise it sends the error response and returns false.
+*/
+func (app *Config) organizationFromPath(c *gin.Context) (*data.Organization, bool) {
+	org, err := app.Repo.GetOrganizationByID(c.Param("id"))
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if org.ID == "" {
+		sendResponse("Organization does not exist", "organization does not exist", nil, c, http.StatusNotFound)
+		return nil, false
+	}
+
+	return org, true
+}
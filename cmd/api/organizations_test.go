@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+/*
+Testing /v1/orgs
+
+	-> Create an organization with its first admin
+	-> Duplicate organization name
+	-> List organizations with member counts
+	-> Rename an organization
+	-> Delete an organization which still has users, without and with cascade
+*/
+func Test_OrganizationLifecycle(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	body := `{"name":"new-org","admin":{"username":"new-org-admin","password":"password"}}`
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/orgs", body, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	var created struct {
+		Data struct {
+			Organization struct {
+				ID    string `json:"id"`
+				Users []struct {
+					Role string `json:"role"`
+				}
+			} `json:"organization"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &created)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	orgID := created.Data.Organization.ID
+
+	if orgID == "" || len(created.Data.Organization.Users) != 1 || created.Data.Organization.Users[0].Role != "admin" {
+		t.Fatalf("FAILED: Expected organization with an admin get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/orgs", body, adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/orgs", "", adminToken)
+
+	var listed struct {
+		Data struct {
+			Organizations []struct {
+				ID          string `json:"id"`
+				MemberCount int    `json:"member_count"`
+			} `json:"organizations"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &listed)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	found := false
+	for _, org := range listed.Data.Organizations {
+		if org.ID == orgID && org.MemberCount == 1 {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("FAILED: Expected new organization with 1 member in %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/orgs/"+orgID, `{"name":"renamed-org"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/orgs/"+orgID, `{"name":"test-org"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/orgs/"+orgID, "", adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/orgs/"+orgID+"?cascade=true", "", adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/orgs/"+orgID, "", adminToken)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/orgs
+
+	-> Missing organization name and admin
+*/
+func Test_CreateOrganizationBadRequest(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/orgs", `{}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/signup
+
+	-> Success, the new admin is signed in
+	-> Organization name already taken
+	-> Username already taken
+	-> Missing organization
+*/
+func Test_Signup(t *testing.T) {
+	reqRecorder := serve(router, http.MethodPost, "/v1/signup", `{"organization":"signup-org","username":"signup-admin","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if getCookie(reqRecorder, "Authorization") == "" || getCookie(reqRecorder, refreshTokenCookie) == "" {
+		t.Error("FAILED: Session Cookies absent after signup")
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/signup", `{"organization":"signup-org","username":"other-admin","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/signup", `{"organization":"other-org","username":"test-username","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/signup", `{"username":"signup-admin","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const webAuthnChallengeTTL = 5 * time.Minute
+
+// the binary fields of the ceremonies are base64url encoded, without padding, like the browsers do
+var webAuthnEncoding = base64.RawURLEncoding
+
+/*
+credentialDescriptor tells the browser which passkeys to use, or not to register again.
+*/
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func credentialDescriptors(credentials []data.WebAuthnCredential) []credentialDescriptor {
+	descriptors := []credentialDescriptor{}
+
+	for _, credential := range credentials {
+		descriptors = append(descriptors, credentialDescriptor{Type: "public-key", ID: credential.CredentialID})
+	}
+
+	return descriptors
+}
+
+/*
+assertionPayload is the answer of the authenticator to the challenge of a login, as sent by the client.
+*/
+type assertionPayload struct {
+	CredentialID      string `json:"credential_id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"user_handle"`
+}
+
+/*
+newWebAuthnChallenge creates the random challenge of a ceremony, stored as a one time token of the purpose,
+so that it can only be answered once. The user is empty for the passwordless login, it is only known once answered.
+*/
+func (app *Config) newWebAuthnChallenge(purpose, userID string) (string, error) {
+	challenge, err := randomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	err = app.Repo.InsertOneTimeToken(data.OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(challenge),
+		ExpiresAt: time.Now().Add(webAuthnChallengeTTL),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+/*
+passkeyReauthentication is the part of the request body of the passkey changes which proves who the user is again,
+as a passkey logs in without the password and outlives the sessions.
+*/
+type passkeyReauthentication struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recovery_code"`
+}
+
+/*
+beginPasskeyRegistration is a handler that returns the options of navigator.credentials.create()
+for the current user to register a new passkey. It takes the current password, and a TOTP code if enabled.
+*/
+func (app *Config) beginPasskeyRegistration(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload passkeyReauthentication
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.CurrentPassword, reqPayload.Code, reqPayload.RecoveryCode) {
+		return
+	}
+
+	credentials, err := app.Repo.GetWebAuthnCredentials(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to get passkeys", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(data.PurposeWebAuthnRegistration, user.ID)
+
+	if err != nil {
+		sendResponse("Failed to create challenge", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Create the passkey and finish the registration", "", map[string]any{
+		"public_key": map[string]any{
+			"challenge": challenge,
+			"rp": map[string]any{
+				"id":   app.WebAuthn.RPID,
+				"name": app.WebAuthn.RPName,
+			},
+			"user": map[string]any{
+				"id":          webAuthnEncoding.EncodeToString([]byte(user.ID)),
+				"name":        user.Username,
+				"displayName": user.Username,
+			},
+			"pubKeyCredParams": []map[string]any{
+				{"type": "public-key", "alg": coseES256},
+				{"type": "public-key", "alg": coseEdDSA},
+				{"type": "public-key", "alg": coseRS256},
+			},
+			"timeout":            webAuthnChallengeTTL.Milliseconds(),
+			"attestation":        "none",
+			"excludeCredentials": credentialDescriptors(credentials),
+			"authenticatorSelection": map[string]any{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+		},
+	}, c, http.StatusOK)
+}
+
+/*
+finishPasskeyRegistration is a handler that takes the answer of navigator.credentials.create() from the request body,
+checks it against the challenge of the current user and stores the new passkey.
+It takes the current password again, and a TOTP code if enabled.
+*/
+func (app *Config) finishPasskeyRegistration(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		passkeyReauthentication
+		Name              string `json:"name"`
+		ClientDataJSON    string `json:"client_data_json"`
+		AttestationObject string `json:"attestation_object"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.CurrentPassword, reqPayload.Code, reqPayload.RecoveryCode) {
+		return
+	}
+
+	clientDataJSON, err := webAuthnEncoding.DecodeString(reqPayload.ClientDataJSON)
+
+	if err != nil {
+		sendResponse("Invalid client data", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	attestationObject, err := webAuthnEncoding.DecodeString(reqPayload.AttestationObject)
+
+	if err != nil {
+		sendResponse("Invalid attestation object", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	clientData, err := app.WebAuthn.verifyClientData(clientDataJSON, ceremonyCreate)
+
+	if err != nil {
+		sendResponse("Invalid client data", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.Repo.ConsumeOneTimeToken(data.PurposeWebAuthnRegistration, hashToken(clientData.Challenge))
+
+	if err != nil || token.UserID != user.ID {
+		sendResponse("Invalid or expired challenge", "invalid or expired challenge", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	authData, err := parseAttestationObject(attestationObject)
+
+	if err == nil {
+		err = app.WebAuthn.verifyAuthenticatorData(authData, false)
+	}
+
+	if err == nil {
+		_, err = parseCOSEKey(authData.PublicKey)
+	}
+
+	if err != nil {
+		sendResponse("Invalid passkey", err.Error(), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	name := reqPayload.Name
+	if name == "" {
+		name = "Passkey"
+	}
+
+	credential := data.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: webAuthnEncoding.EncodeToString(authData.CredentialID),
+		PublicKey:    authData.PublicKey,
+		SignCount:    int64(authData.SignCount),
+		Name:         name,
+	}
+
+	err = app.Repo.InsertWebAuthnCredential(credential)
+
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateCredential) {
+			sendResponse("Passkey already registered", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to save passkey", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	// read back for the id given by the database
+	saved, err := app.Repo.GetWebAuthnCredential(credential.CredentialID)
+
+	if err != nil {
+		sendResponse("Failed to get passkey", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully registered passkey", "", map[string]any{
+		"credential": saved,
+	}, c, http.StatusOK)
+}
+
+/*
+allPasskeys is a handler that returns the passkeys of the current user.
+*/
+func (app *Config) allPasskeys(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	credentials, err := app.Repo.GetWebAuthnCredentials(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to get passkeys", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get passkeys", "", map[string]any{
+		"credentials": credentials,
+	}, c, http.StatusOK)
+}
+
+/*
+deletePasskey is a handler that deletes the passkey of the current user from the `id` path parameter.
+It takes the current password, and a TOTP code if enabled.
+The last MFA method of an admin of an organization which requires MFA for its admins can not be deleted.
+*/
+func (app *Config) deletePasskey(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload passkeyReauthentication
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.reauthenticate(c, *user, reqPayload.CurrentPassword, reqPayload.Code, reqPayload.RecoveryCode) {
+		return
+	}
+
+	credentials, err := app.Repo.GetWebAuthnCredentials(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to get passkeys", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	var credential *data.WebAuthnCredential
+
+	for i := range credentials {
+		if credentials[i].ID == c.Param("id") {
+			credential = &credentials[i]
+		}
+	}
+
+	if credential == nil {
+		sendResponse("Passkey does not exist", "passkey does not exist", nil, c, http.StatusNotFound)
+		return
+	}
+
+	if user.TOTPEnabledAt == nil && len(credentials) == 1 {
+		required, err := app.adminMFARequired(*user)
+
+		if err != nil {
+			sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if required {
+			sendResponse("MFA is required for the admins of the organization", "mfa required for admins", nil, c, http.StatusConflict)
+			return
+		}
+	}
+
+	err = app.Repo.DeleteWebAuthnCredential(*credential)
+
+	if err != nil {
+		sendResponse("Failed to delete passkey", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully deleted passkey", "", nil, c, http.StatusOK)
+}
+
+/*
+beginPasskeyLogin is a handler that returns the options of navigator.credentials.get() for a passwordless login.
+No passkey is listed, the authenticator offers the passkeys it has for our relying party, which tell who the user is.
+*/
+func (app *Config) beginPasskeyLogin(c *gin.Context) {
+	challenge, err := app.newWebAuthnChallenge(data.PurposeWebAuthnLogin, "")
+
+	if err != nil {
+		sendResponse("Failed to create challenge", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Use a passkey and finish the login", "", map[string]any{
+		"public_key": map[string]any{
+			"challenge":        challenge,
+			"rpId":             app.WebAuthn.RPID,
+			"timeout":          webAuthnChallengeTTL.Milliseconds(),
+			"userVerification": "required",
+			"allowCredentials": []credentialDescriptor{},
+		},
+	}, c, http.StatusOK)
+}
+
+/*
+finishPasskeyLogin is a handler that takes the answer of navigator.credentials.get() from the request body
+and logs the user of the passkey in. The passkey is the only factor, so the user must have been verified by it.
+*/
+func (app *Config) finishPasskeyLogin(c *gin.Context) {
+	var reqPayload assertionPayload
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	user, ok := app.verifyAssertion(c, reqPayload, data.PurposeWebAuthnLogin, "", true)
+
+	if !ok {
+		return
+	}
+
+	app.completeLogin(c, user)
+}
+
+/*
+beginPasskeyMFA is a handler that takes the MFA token given by the login from the request body and returns the options
+of navigator.credentials.get() with the passkeys of the user, to use one as the second factor.
+*/
+func (app *Config) beginPasskeyMFA(c *gin.Context) {
+	var reqPayload struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := app.parseMFAChallenge(reqPayload.MFAToken)
+
+	if err != nil {
+		sendResponse("Invalid or expired MFA token", err.Error(), nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := app.Repo.GetWebAuthnCredentials(claims.UserID)
+
+	if err != nil {
+		sendResponse("Failed to get passkeys", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(data.PurposeWebAuthnMFA, claims.UserID)
+
+	if err != nil {
+		sendResponse("Failed to create challenge", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Use a passkey and finish the login", "", map[string]any{
+		"public_key": map[string]any{
+			"challenge":        challenge,
+			"rpId":             app.WebAuthn.RPID,
+			"timeout":          webAuthnChallengeTTL.Milliseconds(),
+			"userVerification": "discouraged",
+			"allowCredentials": credentialDescriptors(credentials),
+		},
+	}, c, http.StatusOK)
+}
+
+/*
+finishPasskeyMFA is a handler that takes the MFA token and the answer of navigator.credentials.get()
+from the request body, and completes the login of the user with the passkey as the second factor.
+*/
+func (app *Config) finishPasskeyMFA(c *gin.Context) {
+	var reqPayload struct {
+		MFAToken string `json:"mfa_token"`
+		assertionPayload
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := app.parseMFAChallenge(reqPayload.MFAToken)
+
+	if err != nil {
+		sendResponse("Invalid or expired MFA token", err.Error(), nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := app.verifyAssertion(c, reqPayload.assertionPayload, data.PurposeWebAuthnMFA, claims.UserID, false)
+
+	if !ok {
+		return
+	}
+
+	err = app.Revocations.Revoke(claims.ID, claims.ExpiresAt)
+
+	if err != nil {
+		sendResponse("Failed to revoke MFA token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	app.completeLogin(c, user)
+}
+
+/*
+verifyAssertion checks the answer of the authenticator against the challenge of the purpose and the passkey it was
+signed with, and returns the user of the passkey. When userID is set, the challenge and the passkey must be of that user.
+The signature counter of the passkey must increase, or it may have been cloned.
+Otherwise it sends the error response, the same for every invalid answer, and returns false.
+*/
+func (app *Config) verifyAssertion(c *gin.Context, payload assertionPayload, purpose, userID string, requireUserVerified bool) (*data.User, bool) {
+	invalid := func(reason error) (*data.User, bool) {
+		log.Println("@LOGIN Refused passkey:", reason)
+		sendResponse("Invalid passkey", errInvalidAssertion.Error(), nil, c, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	clientDataJSON, err := webAuthnEncoding.DecodeString(payload.ClientDataJSON)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	authDataBytes, err := webAuthnEncoding.DecodeString(payload.AuthenticatorData)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	signature, err := webAuthnEncoding.DecodeString(payload.Signature)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	clientData, err := app.WebAuthn.verifyClientData(clientDataJSON, ceremonyGet)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	token, err := app.Repo.ConsumeOneTimeToken(purpose, hashToken(clientData.Challenge))
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if token.UserID != userID {
+		return invalid(errors.New("challenge of another user"))
+	}
+
+	credential, err := app.Repo.GetWebAuthnCredential(payload.CredentialID)
+
+	if err != nil {
+		sendResponse("Failed to get passkey", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if credential.ID == "" || (userID != "" && credential.UserID != userID) {
+		return invalid(errors.New("unknown passkey"))
+	}
+
+	if payload.UserHandle != "" {
+		userHandle, err := webAuthnEncoding.DecodeString(payload.UserHandle)
+
+		if err != nil || string(userHandle) != credential.UserID {
+			return invalid(errors.New("user handle does not match the passkey"))
+		}
+	}
+
+	authData, err := parseAuthenticatorData(authDataBytes)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = app.WebAuthn.verifyAuthenticatorData(authData, requireUserVerified)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = verifyAssertionSignature(credential.PublicKey, authDataBytes, clientDataJSON, signature)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	user, err := app.Repo.GetByID(credential.UserID)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if user.ID == "" || isLocked(*user) {
+		return invalid(errors.New("user does not exist or is locked"))
+	}
+
+	counterValid, err := app.Repo.UseWebAuthnCredential(*credential, authData.SignCount)
+
+	if err != nil {
+		sendResponse("Failed to update passkey", err.Error(), nil, c, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !counterValid {
+		log.Printf("@LOGIN Signature counter of passkey %s did not increase, it may have been cloned", credential.ID)
+		return invalid(errors.New("signature counter did not increase"))
+	}
+
+	return user, true
+}
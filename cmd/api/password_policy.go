@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bcrypt ignores anything after 72 bytes, so a longer password would be silently truncated
+const bcryptMaxLength = 72
+
+/*
+passwordViolation is a rule of the password policy which a password does not follow.
+*/
+type passwordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+/*
+validatePassword checks the password against every rule of the policy and returns the rules it violates.
+*/
+func validatePassword(policy data.PasswordPolicy, username, password string) []passwordViolation {
+	violations := []passwordViolation{}
+
+	violate := func(rule, message string) {
+		violations = append(violations, passwordViolation{Rule: rule, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violate("min_length", fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+
+	if len(password) > policy.MaxLength {
+		violate("max_length", fmt.Sprintf("must be at most %d bytes long", policy.MaxLength))
+	}
+
+	if policy.RequireUpper && !containsFunc(password, unicode.IsUpper) {
+		violate("require_upper", "must contain an uppercase letter")
+	}
+
+	if policy.RequireLower && !containsFunc(password, unicode.IsLower) {
+		violate("require_lower", "must contain a lowercase letter")
+	}
+
+	if policy.RequireDigit && !containsFunc(password, unicode.IsDigit) {
+		violate("require_digit", "must contain a digit")
+	}
+
+	if policy.RequireSymbol && !containsFunc(password, isSymbol) {
+		violate("require_symbol", "must contain a symbol")
+	}
+
+	if policy.DisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violate("disallow_username", "must not contain the username")
+	}
+
+	return violations
+}
+
+func containsFunc(s string, f func(rune) bool) bool {
+	return strings.IndexFunc(s, f) >= 0
+}
+
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+/*
+passwordPolicy returns the password policy of the organization, or the default policy if there is no such organization yet.
+*/
+func (app *Config) passwordPolicy(orgID string) (data.PasswordPolicy, error) {
+	org, err := app.Repo.GetOrganizationByID(orgID)
+
+	if err != nil {
+		return data.PasswordPolicy{}, err
+	}
+
+	if org.ID == "" {
+		return data.DefaultPasswordPolicy(), nil
+	}
+
+	return org.PasswordPolicy, nil
+}
+
+/*
+checkPassword checks the new password of the user against the policy, against the last passwords of the user
+if the user already exists, and against the breached passwords. If the password is refused, it sends every
+violated rule and returns false.
+*/
+func (app *Config) checkPassword(c *gin.Context, policy data.PasswordPolicy, user data.User, password string) bool {
+	violations := validatePassword(policy, user.Username, password)
+
+	if user.ID != "" && policy.History > 0 {
+		used, err := app.Repo.PasswordUsedRecently(user, password, policy.History)
+
+		if err != nil {
+			sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
+			return false
+		}
+
+		if used {
+			violations = append(violations, passwordViolation{
+				Rule:    "history",
+				Message: fmt.Sprintf("must not be one of the last %d passwords", policy.History),
+			})
+		}
+	}
+
+	if app.Breached != nil {
+		count, err := app.Breached.Count(password)
+
+		if err != nil {
+			sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
+			return false
+		}
+
+		if count > 0 {
+			violations = append(violations, passwordViolation{
+				Rule:    "breached",
+				Message: "has appeared in a data breach, choose another password",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		sendResponse("Password does not follow the password policy", "password policy violated", map[string]any{
+			"violations": violations,
+		}, c, http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+/*
+getPasswordPolicy is a handler that returns the password policy of the organization of the current user,
+so that the clients can show the rules before a password is sent.
+*/
+func (app *Config) getPasswordPolicy(c *gin.Context) {
+	policy, err := app.passwordPolicy(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get password policy", "", map[string]any{
+		"password_policy": policy,
+	}, c, http.StatusOK)
+}
+
+/*
+updatePasswordPolicy is a handler that takes the rules to change from the request body, the other rules are kept.
+The new rules apply to the passwords set from now on, the current passwords are not checked.
+It can only be called by a user with the password_policy:manage permission.
+*/
+func (app *Config) updatePasswordPolicy(c *gin.Context) {
+	org, err := app.Repo.GetOrganizationByID(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get organization", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if org.ID == "" {
+		sendResponse("Organization does not exist", "organization does not exist", nil, c, http.StatusNotFound)
+		return
+	}
+
+	policy := org.PasswordPolicy
+
+	err = c.Bind(&policy)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case policy.MinLength < 1 || policy.MinLength > policy.MaxLength:
+		sendResponse("Invalid password policy", "min_length must be between 1 and max_length", nil, c, http.StatusBadRequest)
+		return
+	case policy.MaxLength > bcryptMaxLength:
+		sendResponse("Invalid password policy", fmt.Sprintf("max_length must be at most %d", bcryptMaxLength), nil, c, http.StatusBadRequest)
+		return
+	case policy.History < 0 || policy.History > data.MaxPasswordHistory:
+		sendResponse("Invalid password policy", fmt.Sprintf("history must be between 0 and %d", data.MaxPasswordHistory), nil, c, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.UpdatePasswordPolicy(*org, policy)
+
+	if err != nil {
+		sendResponse("Failed to update password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully updated password policy", "", map[string]any{
+		"password_policy": policy,
+	}, c, http.StatusOK)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+/*
+Testing the rules of the password policy
+*/
+func Test_ValidatePassword(t *testing.T) {
+	strict := data.PasswordPolicy{
+		MinLength:        10,
+		MaxLength:        20,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUsername: true,
+	}
+
+	tests := []struct {
+		name     string
+		policy   data.PasswordPolicy
+		password string
+		rules    []string
+	}{
+		{"default policy", data.DefaultPasswordPolicy(), "password", nil},
+		{"too short", data.DefaultPasswordPolicy(), "a", []string{"min_length"}},
+		{"too long for bcrypt", data.DefaultPasswordPolicy(), strings.Repeat("a", 73), []string{"max_length"}},
+		{"contains username", data.DefaultPasswordPolicy(), "my-Alice-password", []string{"disallow_username"}},
+		{"strict policy", strict, "Str0ng-Password", nil},
+		{"every class missing", strict, "          ", []string{"require_upper", "require_lower", "require_digit", "require_symbol"}},
+		{"multibyte characters", data.PasswordPolicy{MinLength: 4, MaxLength: 8}, "äöüß", nil},
+	}
+
+	for _, test := range tests {
+		violations := validatePassword(test.policy, "alice", test.password)
+
+		rules := []string{}
+		for _, violation := range violations {
+			rules = append(rules, violation.Rule)
+		}
+
+		if strings.Join(rules, ",") != strings.Join(test.rules, ",") {
+			t.Errorf("FAILED: %s Expected %v get %v", test.name, test.rules, rules)
+		}
+	}
+}
+
+/*
+Testing /v1/password/policy
+
+	-> A weak password is refused with every violated rule
+	-> Update the policy, the new rules apply to the next passwords
+	-> A recent password is refused
+	-> Invalid policy
+*/
+func Test_PasswordPolicy(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/add", `{"username":"new-user","password":"a"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	var refused struct {
+		Data struct {
+			Violations []passwordViolation `json:"violations"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &refused)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	if len(refused.Data.Violations) != 1 || refused.Data.Violations[0].Rule != "min_length" {
+		t.Errorf("FAILED: Expected min_length violation get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/password/policy", `{"require_digit":true,"history":3}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	// the other tests use the default policy
+	defer serve(router, http.MethodPatch, "/v1/password/policy", `{"require_digit":false,"history":0}`, adminToken)
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/password/policy", "", adminToken)
+
+	var listed struct {
+		Data struct {
+			PasswordPolicy data.PasswordPolicy `json:"password_policy"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &listed)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	policy := listed.Data.PasswordPolicy
+
+	if !policy.RequireDigit || policy.History != 3 || policy.MinLength != 8 {
+		t.Errorf("FAILED: Expected updated policy keeping min_length get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/password", `{"current_password":"password","new_password":"recent-password"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	refused.Data.Violations = nil
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &refused)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	if len(refused.Data.Violations) != 2 || refused.Data.Violations[0].Rule != "require_digit" || refused.Data.Violations[1].Rule != "history" {
+		t.Errorf("FAILED: Expected require_digit and history violations get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/password/policy", `{"max_length":100}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	memberToken, _ := loginTestUser(t)
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/password/policy", `{"min_length":1}`, memberToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusForbidden, reqRecorder.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const passwordResetTTL = time.Hour
+
+/*
+forgotPassword is a handler that takes the username from the request body and sends a password reset link to the user.
+It answers the same whether the user exists or not, so that it can not be used to find out the usernames.
+*/
+func (app *Config) forgotPassword(c *gin.Context) {
+	var reqPayload struct {
+		Username string `json:"username"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Username == "" {
+		sendResponse("Missing Username in request", "missing username in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Repo.GetByUsername(reqPayload.Username)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	// in the background and only logged, the time of the response or an error would tell that the user exists
+	if user.ID != "" {
+		app.notifyInBackground("@PASSWORD Failed to send password reset:", func() error {
+			return app.sendPasswordReset(*user)
+		})
+	}
+
+	sendResponse("If the user exists, a password reset link has been sent", "", nil, c, http.StatusOK)
+}
+
+/*
+sendPasswordReset issues a password reset token for the user and sends the link to their verified email.
+The links sent before stop working, only the last one can be used. Nothing is sent without a verified email.
+*/
+func (app *Config) sendPasswordReset(user data.User) error {
+	to, ok := notifyAddress(user)
+
+	if !ok {
+		return errNoVerifiedEmail
+	}
+
+	token, err := randomToken()
+
+	if err != nil {
+		return err
+	}
+
+	err = app.Repo.ReplaceOneTimeToken(data.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   data.PurposePasswordReset,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Reset your password with this link, it is valid for %s:\n\n%s/reset-password?token=%s\n\n"+
+		"If you did not ask for it, you can ignore this message.", passwordResetTTL, APP_URL, token)
+
+	return app.Notifier.Notify(to, "Reset your password", body)
+}
+
+/*
+resetPassword is a handler that takes the password reset token and the new password from the request body.
+The token can only be used once, it is used up along with the change of the password, and every session of the user is revoked.
+*/
+func (app *Config) resetPassword(c *gin.Context) {
+	var reqPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Token == "" || reqPayload.Password == "" {
+		sendResponse("Missing Token or Password in request", "missing token or password in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	// the token is only looked up here, a refused password does not use up the link
+	token, err := app.Repo.GetOneTimeToken(data.PurposePasswordReset, hashToken(reqPayload.Token))
+
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidOneTimeToken) {
+			sendResponse("Invalid or expired password reset token", err.Error(), nil, c, http.StatusBadRequest)
+			return
+		}
+		sendResponse("Failed to reset password", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Repo.GetByID(token.UserID)
+
+	if err != nil || user.ID == "" {
+		sendResponse("User does not exist", "user does not exist", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	policy, err := app.passwordPolicy(user.OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkPassword(c, policy, *user, reqPayload.Password) {
+		return
+	}
+
+	err = app.Repo.ResetPassword(token.TokenHash, reqPayload.Password)
+
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidOneTimeToken) {
+			sendResponse("Invalid or expired password reset token", err.Error(), nil, c, http.StatusBadRequest)
+			return
+		}
+		sendResponse("Failed to reset password", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to revoke sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully reset password", "", nil, c, http.StatusOK)
+}
+
+/*
+changePassword is a handler that takes the current and the new password of the logged in user from the request body.
+The current password is verified again, then every session of the user is revoked and a new session is started
+for the caller, so that only the device changing the password stays logged in.
+*/
+func (app *Config) changePassword(c *gin.Context) {
+	userId, _ := c.Get("userId")
+
+	var reqPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.CurrentPassword == "" || reqPayload.NewPassword == "" {
+		sendResponse("Missing Current Password or New Password in request", "missing current password or new password in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Repo.GetByID(userId.(string))
+
+	if err != nil || user.ID == "" {
+		sendResponse("User does not exist", "user does not exist", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	isPasswordMatched, err := app.Repo.PasswordMatch(reqPayload.CurrentPassword, *user)
+
+	if err != nil {
+		sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !isPasswordMatched {
+		sendResponse("Invalid current password", "invalid current password", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	policy, err := app.passwordPolicy(user.OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get password policy", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkPassword(c, policy, *user, reqPayload.NewPassword) {
+		return
+	}
+
+	err = app.Repo.UpdatePassword(*user, reqPayload.NewPassword)
+
+	if err != nil {
+		sendResponse("Failed to change password", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to revoke sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.startSession(c, *user)
+
+	if err != nil {
+		sendResponse("Failed to create session", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully changed password", "", nil, c, http.StatusOK)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+/*
+Testing /v1/password/forgot and /v1/password/reset
+
+	-> The reset link is sent to the user
+	-> Resetting the password revokes every session of the user
+	-> The reset token can only be used once
+*/
+func Test_PasswordReset(t *testing.T) {
+	accessToken, refreshToken := loginTestUser(t)
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/password/forgot", `{"username":"test-username"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	waitNotifications()
+
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last(testUserEmail)
+
+	if !ok {
+		t.Fatalf("FAILED: Expected a password reset message")
+	}
+
+	_, token, _ := strings.Cut(message.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+token+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = postRefreshToken(t, refreshToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+token+`","password":"other-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/password/reset with a password refused by the policy
+
+	-> The weak password is refused and the reset link is not used up
+	-> The same link then resets the password with a valid one
+*/
+func Test_PasswordResetRefusedPassword(t *testing.T) {
+	reqRecorder := serve(router, http.MethodPost, "/v1/password/forgot", `{"username":"test-username"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	waitNotifications()
+
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last(testUserEmail)
+
+	if !ok {
+		t.Fatalf("FAILED: Expected a password reset message")
+	}
+
+	_, token, _ := strings.Cut(message.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+token+`","password":"short"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+token+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected the link to still work get %d %s", reqRecorder.Code, reqRecorder.Body.String())
+	}
+}
+
+/*
+Testing POST /v1/password/forgot twice
+
+	-> Only the last reset link sent to the user works
+*/
+func Test_PasswordResetReplacesLink(t *testing.T) {
+	tokens := []string{}
+
+	for i := 0; i < 2; i++ {
+		reqRecorder := serve(router, http.MethodPost, "/v1/password/forgot", `{"username":"test-username"}`, "")
+
+		if reqRecorder.Code != http.StatusOK {
+			t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+		}
+
+		waitNotifications()
+		message, ok := testApp.Notifier.(*MemoryNotifier).Last(testUserEmail)
+
+		if !ok {
+			t.Fatalf("FAILED: Expected a password reset message")
+		}
+
+		_, token, _ := strings.Cut(message.Body, "token=")
+		token, _, _ = strings.Cut(token, "\n")
+		tokens = append(tokens, token)
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+tokens[0]+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected the first link to stop working get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"`+tokens[1]+`","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/password/reset
+
+	-> Unknown token
+	-> Missing password
+*/
+func Test_PasswordResetBadRequest(t *testing.T) {
+	reqRecorder := serve(router, http.MethodPost, "/v1/password/reset", `{"token":"unknown","password":"new-password"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/password/reset", `{"token":"unknown"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/me/password
+
+	-> Wrong current password
+	-> Changing the password revokes the other sessions, the caller gets a new session
+*/
+func Test_ChangePassword(t *testing.T) {
+	accessToken, _ := loginTestUser(t)
+	otherAccessToken, otherRefreshToken := loginTestUser(t)
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/me/password", `{"current_password":"wrong-password","new_password":"new-password"}`, accessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/password", `{"current_password":"password","new_password":"new-password"}`, accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	newAccessToken := getCookie(reqRecorder, "Authorization")
+
+	if newAccessToken == "" {
+		t.Fatalf("FAILED: Expected a new session")
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", otherAccessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = postRefreshToken(t, otherRefreshToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", newAccessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/text/language"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+
+	maxDisplayNameLength = 100
+	maxAvatarURLLength   = 2048
+	maxEmailLength       = 254
+)
+
+/*
+getMe is a handler that returns the current user, with their email and profile.
+*/
+func (app *Config) getMe(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	sendResponse("Successfully get user", "", map[string]any{
+		"user": user,
+	}, c, http.StatusOK)
+}
+
+/*
+updateMe is a handler that takes the profile fields and the email of the current user from the request body,
+the missing ones are left as they are and an empty one is cleared. A new email is unverified, and a link
+to verify it is sent to it. Changing the email takes the current password, as the email receives the password
+reset and login links, and the previous verified email is told about the change.
+A wrong password counts as a failed login, and a locked out user can not change the email.
+*/
+func (app *Config) updateMe(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Email       *string `json:"email"`
+		DisplayName *string `json:"display_name"`
+		AvatarURL   *string `json:"avatar_url"`
+		Locale      *string `json:"locale"`
+		Timezone    *string `json:"timezone"`
+
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	profile := user.Profile
+	violations := []string{}
+
+	if reqPayload.DisplayName != nil {
+		profile.DisplayName, err = normalizeDisplayName(*reqPayload.DisplayName)
+		violations = appendViolation(violations, err)
+	}
+
+	if reqPayload.AvatarURL != nil {
+		profile.AvatarURL, err = normalizeAvatarURL(*reqPayload.AvatarURL)
+		violations = appendViolation(violations, err)
+	}
+
+	if reqPayload.Locale != nil {
+		profile.Locale, err = normalizeLocale(*reqPayload.Locale)
+		violations = appendViolation(violations, err)
+	}
+
+	if reqPayload.Timezone != nil {
+		profile.Timezone, err = normalizeTimezone(*reqPayload.Timezone)
+		violations = appendViolation(violations, err)
+	}
+
+	var email string
+
+	if reqPayload.Email != nil {
+		email, err = normalizeEmail(*reqPayload.Email)
+		violations = appendViolation(violations, err)
+	}
+
+	if len(violations) > 0 {
+		sendResponse("Invalid profile", strings.Join(violations, ", "), map[string]any{
+			"violations": violations,
+		}, c, http.StatusBadRequest)
+		return
+	}
+
+	currentEmail := ""
+	if user.Email != nil {
+		currentEmail = *user.Email
+	}
+
+	emailChanged := reqPayload.Email != nil && email != currentEmail
+
+	if emailChanged {
+		if reqPayload.CurrentPassword == "" {
+			sendResponse("Missing Current Password in request", "missing current password in request", nil, c, http.StatusBadRequest)
+			return
+		}
+
+		if isLocked(*user) {
+			app.dummyPasswordMatch(reqPayload.CurrentPassword)
+			sendResponse("Invalid current password", "invalid current password", nil, c, http.StatusUnauthorized)
+			return
+		}
+
+		isPasswordMatched, err := app.Repo.PasswordMatch(reqPayload.CurrentPassword, *user)
+
+		if err != nil {
+			sendResponse("Error while verifying password", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if !isPasswordMatched {
+			err = app.recordFailedLogin(*user)
+
+			if err != nil {
+				log.Println("@PROFILE Failed to record failed login:", err)
+			}
+
+			sendResponse("Invalid current password", "invalid current password", nil, c, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if profile != user.Profile {
+		err = app.Repo.UpdateProfile(*user, profile)
+
+		if err != nil {
+			sendResponse("Failed to update profile", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if emailChanged {
+		err = app.Repo.SetEmail(*user, email)
+
+		if err != nil {
+			if errors.Is(err, data.ErrDuplicateEmail) {
+				sendResponse("Email already taken", err.Error(), nil, c, http.StatusConflict)
+				return
+			}
+			sendResponse("Failed to update email", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if email != "" {
+			err = app.sendEmailVerification(*user, email)
+
+			if err != nil {
+				log.Println("@PROFILE Failed to send email verification:", err)
+			}
+		}
+
+		// so that the owner of the previous email notices if the change was not theirs
+		if previous, ok := notifyAddress(*user); ok {
+			err = app.sendEmailChanged(previous, email)
+
+			if err != nil {
+				log.Println("@PROFILE Failed to notify the previous email:", err)
+			}
+		}
+	}
+
+	user, err = app.Repo.GetByID(user.ID)
+
+	if err != nil {
+		sendResponse("Error while getting user", "error while getting user", nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully updated profile", "", map[string]any{
+		"user": user,
+	}, c, http.StatusOK)
+}
+
+/*
+sendEmailChanged tells the previous email of a user that the email was changed, and to which one.
+*/
+func (app *Config) sendEmailChanged(previous, email string) error {
+	change := "removed"
+	if email != "" {
+		change = "changed to " + email
+	}
+
+	body := fmt.Sprintf("The email of your account was %s, this address will no longer receive its messages.\n\n"+
+		"If you did not do it, reset your password and contact your administrator.", change)
+
+	return app.Notifier.Notify(previous, "Your email was changed", body)
+}
+
+/*
+resendEmailVerification is a handler that sends a new link to verify the email of the current user.
+*/
+func (app *Config) resendEmailVerification(c *gin.Context) {
+	user, ok := app.tokenUser(c)
+
+	if !ok {
+		return
+	}
+
+	if user.Email == nil {
+		sendResponse("User has no email", "user has no email", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		sendResponse("Email already verified", "email already verified", nil, c, http.StatusConflict)
+		return
+	}
+
+	err := app.sendEmailVerification(*user, *user.Email)
+
+	if err != nil {
+		sendResponse("Failed to send email verification", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Email verification sent", "", nil, c, http.StatusOK)
+}
+
+/*
+sendEmailVerification sends a link to verify the email to it. The link is bound to the user and the email,
+so that it does not verify another email the user changes to afterwards.
+*/
+func (app *Config) sendEmailVerification(user data.User, email string) error {
+	token, err := app.newSignedLink(user, data.PurposeEmailVerify, emailVerificationTTL, jwt.MapClaims{"email": email})
+
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Verify your email with this link, it is valid for %s:\n\n%s/verify-email?token=%s\n\n"+
+		"If you did not ask for it, you can ignore this message.", emailVerificationTTL, APP_URL, url.QueryEscape(token))
+
+	return app.Notifier.Notify(email, "Verify your email", body)
+}
+
+/*
+verifyEmail is a handler that takes the token of an email verification link from the request body,
+and marks the email of its user as verified. The link can only be used once.
+*/
+func (app *Config) verifyEmail(c *gin.Context) {
+	var reqPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Token == "" {
+		sendResponse("Missing Token in request", "missing token in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.consumeSignedLink(reqPayload.Token, data.PurposeEmailVerify)
+
+	if err != nil {
+		if errors.Is(err, errInvalidLink) {
+			sendResponse("Invalid or expired link", err.Error(), nil, c, http.StatusBadRequest)
+			return
+		}
+		sendResponse("Failed to verify link", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	userId, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+
+	verified, err := app.Repo.VerifyEmail(data.User{GormModel: data.GormModel{ID: userId}}, email)
+
+	if err != nil {
+		sendResponse("Failed to verify email", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if !verified {
+		sendResponse("Email changed or already verified", "email changed or already verified", nil, c, http.StatusConflict)
+		return
+	}
+
+	sendResponse("Successfully verified email", "", map[string]any{
+		"email": email,
+	}, c, http.StatusOK)
+}
+
+func appendViolation(violations []string, err error) []string {
+	if err != nil {
+		return append(violations, err.Error())
+	}
+	return violations
+}
+
+/*
+normalizeEmail returns the email in lower case, it must be a bare address like "name@example.com".
+*/
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	if email == "" {
+		return "", nil
+	}
+
+	address, err := mail.ParseAddress(email)
+
+	if err != nil || address.Address != email || len(email) > maxEmailLength {
+		return "", errors.New("invalid email")
+	}
+
+	return strings.ToLower(email), nil
+}
+
+func normalizeDisplayName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		return "", fmt.Errorf("display name longer than %d characters", maxDisplayNameLength)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", errors.New("display name has control characters")
+		}
+	}
+
+	return name, nil
+}
+
+/*
+normalizeAvatarURL checks that the avatar is an absolute http or https URL, the clients load it as an image.
+*/
+func normalizeAvatarURL(avatarURL string) (string, error) {
+	avatarURL = strings.TrimSpace(avatarURL)
+
+	if avatarURL == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(avatarURL)
+
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || len(avatarURL) > maxAvatarURLLength {
+		return "", errors.New("avatar url must be an http or https url")
+	}
+
+	return parsed.String(), nil
+}
+
+/*
+normalizeLocale returns the canonical BCP 47 tag of the locale, like "en-US".
+*/
+func normalizeLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+
+	if locale == "" {
+		return "", nil
+	}
+
+	tag, err := language.Parse(locale)
+
+	if err != nil {
+		return "", errors.New("invalid locale")
+	}
+
+	return tag.String(), nil
+}
+
+/*
+normalizeTimezone checks that the timezone is a name of the IANA database, like "Europe/Paris".
+The database is embedded in the binary, so that it does not depend on the one of the system.
+*/
+func normalizeTimezone(timezone string) (string, error) {
+	timezone = strings.TrimSpace(timezone)
+
+	if timezone == "" {
+		return "", nil
+	}
+
+	// "Local" is the timezone of the server, not one of the user
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return "", errors.New("invalid timezone")
+	}
+
+	return timezone, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Testing the checks of the profile fields
+
+	-> The email is a bare address in lower case
+	-> The locale is a canonical BCP 47 tag, the timezone an IANA name and the avatar an http or https URL
+*/
+func Test_NormalizeProfile(t *testing.T) {
+	tests := []struct {
+		normalize func(string) (string, error)
+		value     string
+		expected  string
+		valid     bool
+	}{
+		{normalizeEmail, "Someone@Example.com", "someone@example.com", true},
+		{normalizeEmail, "", "", true},
+		{normalizeEmail, "Someone <someone@example.com>", "", false},
+		{normalizeEmail, "not-an-email", "", false},
+		{normalizeLocale, "en-us", "en-US", true},
+		{normalizeLocale, "fr", "fr", true},
+		{normalizeLocale, "not a locale", "", false},
+		{normalizeTimezone, "Europe/Paris", "Europe/Paris", true},
+		{normalizeTimezone, "UTC", "UTC", true},
+		{normalizeTimezone, "Local", "", false},
+		{normalizeTimezone, "Mars/Olympus", "", false},
+		{normalizeAvatarURL, "https://example.com/avatar.png", "https://example.com/avatar.png", true},
+		{normalizeAvatarURL, "javascript:alert(1)", "", false},
+		{normalizeAvatarURL, "/avatar.png", "", false},
+		{normalizeDisplayName, "  Ada Lovelace ", "Ada Lovelace", true},
+		{normalizeDisplayName, strings.Repeat("a", maxDisplayNameLength+1), "", false},
+		{normalizeDisplayName, "Ada\nLovelace", "", false},
+	}
+
+	for _, test := range tests {
+		value, err := test.normalize(test.value)
+
+		if (err == nil) != test.valid || value != test.expected {
+			t.Errorf("FAILED: %q Expected %q %t get %q %v", test.value, test.expected, test.valid, value, err)
+		}
+	}
+
+	email := "someone@example.com"
+	now := time.Now()
+	user := data.User{Username: "someone", Email: &email}
+
+	if to, ok := notifyAddress(user); ok {
+		t.Errorf("FAILED: Expected no address before the email is verified get %s", to)
+	}
+
+	user.EmailVerifiedAt = &now
+
+	if to, ok := notifyAddress(user); !ok || to != email {
+		t.Errorf("FAILED: Expected the verified email get %s", to)
+	}
+}
+
+/*
+Testing GET and PATCH /v1/me
+
+	-> The profile fields are normalized, and the invalid ones are all reported
+	-> The missing fields are left as they are
+	-> An email of another user is refused
+*/
+func Test_Profile(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "profile-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPatch, "/v1/me",
+		`{"display_name":"Ada Lovelace","avatar_url":"https://example.com/ada.png","locale":"en-gb","timezone":"Europe/London"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"locale":"not a locale","timezone":"Mars/Olympus"}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	if violations, _ := responseData(t, reqRecorder)["violations"].([]any); len(violations) != 2 {
+		t.Errorf("FAILED: Expected 2 violations get %v", violations)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"display_name":"Ada"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/me", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	user, _ := responseData(t, reqRecorder)["user"].(map[string]any)
+
+	if user["display_name"] != "Ada" || user["locale"] != "en-GB" || user["timezone"] != "Europe/London" ||
+		user["avatar_url"] != "https://example.com/ada.png" {
+		t.Errorf("FAILED: Expected the updated profile get %v", user)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"email":"Taken@Example.com","current_password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the verification of the email
+
+	-> Changing the email takes the current password
+	-> A new email is unverified, and the link is sent to it
+	-> The link verifies the email once
+	-> The link of a previous email does not verify the new one
+	-> A new link can be sent for an unverified email only
+	-> The previous verified email is told about the change
+*/
+func Test_EmailVerification(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "email-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPatch, "/v1/me", `{"email":"Ada@Example.com"}`, token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"email":"Ada@Example.com","current_password":"wrong-password"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"email":"Ada@Example.com","current_password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	user, _ := responseData(t, reqRecorder)["user"].(map[string]any)
+
+	if user["email"] != "ada@example.com" || user["email_verified_at"] != nil {
+		t.Errorf("FAILED: Expected the unverified email get %v", user)
+	}
+
+	adaLink := emailVerificationTestToken(t, "ada@example.com")
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"email":"lovelace@example.com","current_password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/email/verify", `{"token":"`+adaLink+`"}`, "")
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/email/verification", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	lovelaceLink := emailVerificationTestToken(t, "lovelace@example.com")
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/email/verify", `{"token":"`+lovelaceLink+`"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/email/verify", `{"token":"`+lovelaceLink+`"}`, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/me", "", token)
+	user, _ = responseData(t, reqRecorder)["user"].(map[string]any)
+
+	if user["email"] != "lovelace@example.com" || user["email_verified_at"] == nil {
+		t.Errorf("FAILED: Expected the verified email get %v", user)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/me/email/verification", "", token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/me", `{"email":"babbage@example.com","current_password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last("lovelace@example.com")
+
+	if !ok || !strings.Contains(message.Body, "changed to babbage@example.com") {
+		t.Errorf("FAILED: Expected the previous email to be told about the change get %q", message.Body)
+	}
+}
+
+/*
+Testing PATCH /v1/me with wrong current passwords
+
+	-> A wrong current password counts as a failed login
+	-> The locked out user can not change the email, even with the right password
+*/
+func Test_EmailChangeLockout(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "email-lockout-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	for i := 0; i < testApp.Lockout.Threshold; i++ {
+		reqRecorder := serve(router, http.MethodPatch, "/v1/me", `{"email":"grace@example.com","current_password":"wrong-password"}`, token)
+
+		if reqRecorder.Code != http.StatusUnauthorized {
+			t.Fatalf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+		}
+	}
+
+	reqRecorder := serve(router, http.MethodPatch, "/v1/me", `{"email":"grace@example.com","current_password":"password"}`, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Function to get the token of the last email verification link sent to the address
+*/
+func emailVerificationTestToken(t *testing.T, to string) string {
+	message, ok := testApp.Notifier.(*MemoryNotifier).Last(to)
+
+	if !ok || !strings.Contains(message.Body, "/verify-email?token=") {
+		t.Fatalf("FAILED: Expected an email verification message to %s", to)
+	}
+
+	_, token, _ := strings.Cut(message.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	return token
+}
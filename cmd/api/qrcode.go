@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+/*
+A minimal QR code encoder, just enough for the otpauth URIs of the TOTP enrollment:
+byte mode, error correction level M and versions 1 to 10, that is up to 213 bytes of text.
+*/
+
+var errQRCodeTooLong = errors.New("text too long for a QR code")
+
+const qrMaxVersion = 10
+
+/*
+qrBlocks is the error correction level M block layout of a version: the error correction codewords of each block
+and the number of blocks of the two groups with their number of data codewords.
+*/
+type qrBlocks struct {
+	eccPerBlock        int
+	group1, group1Data int
+	group2, group2Data int
+	alignmentPositions []int
+}
+
+var qrVersions = [qrMaxVersion + 1]qrBlocks{
+	1:  {10, 1, 16, 0, 0, nil},
+	2:  {16, 1, 28, 0, 0, []int{6, 18}},
+	3:  {26, 1, 44, 0, 0, []int{6, 22}},
+	4:  {18, 2, 32, 0, 0, []int{6, 26}},
+	5:  {24, 2, 43, 0, 0, []int{6, 30}},
+	6:  {16, 4, 27, 0, 0, []int{6, 34}},
+	7:  {18, 4, 31, 0, 0, []int{6, 22, 38}},
+	8:  {22, 2, 38, 2, 39, []int{6, 24, 42}},
+	9:  {22, 3, 36, 2, 37, []int{6, 26, 46}},
+	10: {26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	return b.group1*b.group1Data + b.group2*b.group2Data
+}
+
+/*
+qrCode is the matrix of a QR code, the function modules (finders, timing, alignment, format and version)
+are marked so that the data and the mask skip them.
+*/
+type qrCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+/*
+newQRCode returns a QR code of the version with its function modules drawn and no data.
+*/
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+
+	qr := &qrCode{version: version, size: size}
+	qr.modules = make([][]bool, size)
+	qr.function = make([][]bool, size)
+
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+
+	qr.drawFunctionPatterns()
+	return qr
+}
+
+/*
+encodeQRCode encodes the text in the smallest version that fits it, with the mask of the lowest penalty.
+*/
+func encodeQRCode(text []byte) (*qrCode, error) {
+	version := 0
+
+	for v := 1; v <= qrMaxVersion; v++ {
+		if qrDataBits(v, len(text)) <= qrVersions[v].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, errQRCodeTooLong
+	}
+
+	qr := newQRCode(version)
+	qr.drawCodewords(qrCodewords(version, text))
+
+	bestMask, bestPenalty := 0, -1
+
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+
+		penalty := qr.penalty()
+
+		if bestPenalty < 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+
+		// the mask is a XOR, applying it again removes it
+		qr.applyMask(mask)
+	}
+
+	qr.applyMask(bestMask)
+	qr.drawFormatBits(bestMask)
+	return qr, nil
+}
+
+/*
+PNG renders the QR code as a PNG image, with scale pixels per module and the 4 modules wide quiet zone around it.
+*/
+func (qr *qrCode) PNG(scale int) ([]byte, error) {
+	const quietZone = 4
+
+	width := (qr.size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			mx, my := x/scale-quietZone, y/scale-quietZone
+
+			if mx >= 0 && my >= 0 && mx < qr.size && my < qr.size && qr.modules[my][mx] {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, img)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func qrDataBits(version, length int) int {
+	return 4 + qrCountBits(version) + 8*length
+}
+
+/*
+qrCodewords returns the data codewords of the text followed by their error correction, interleaved by block.
+*/
+func qrCodewords(version int, text []byte) []byte {
+	layout := qrVersions[version]
+	capacity := layout.dataCodewords()
+
+	var bits bitBuffer
+	bits.append(0x4, 4) // byte mode
+	bits.append(len(text), qrCountBits(version))
+
+	for _, b := range text {
+		bits.append(int(b), 8)
+	}
+
+	// terminator, then padding to a whole codeword and the pad codewords
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	data := bits.bytes()
+	for pad := byte(0xEC); len(data) < capacity; pad ^= 0xEC ^ 0x11 {
+		data = append(data, pad)
+	}
+
+	generator := rsGenerator(layout.eccPerBlock)
+
+	var blocks, eccs [][]byte
+
+	for i := 0; i < layout.group1+layout.group2; i++ {
+		length := layout.group1Data
+		if i >= layout.group1 {
+			length = layout.group2Data
+		}
+
+		blocks = append(blocks, data[:length])
+		eccs = append(eccs, rsRemainder(data[:length], generator))
+		data = data[length:]
+	}
+
+	var codewords []byte
+
+	for i := 0; i < layout.group2Data || i < layout.group1Data; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				codewords = append(codewords, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < layout.eccPerBlock; i++ {
+		for _, ecc := range eccs {
+			codewords = append(codewords, ecc[i])
+		}
+	}
+
+	return codewords
+}
+
+/*
+bitBuffer is a sequence of bits, most significant first.
+*/
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, (len(b)+7)/8)
+
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	return result
+}
+
+/*
+=====================
+Reed-Solomon over GF(256) with the polynomial 0x11D
+=====================
+*/
+
+var qrExp, qrLog [256]byte
+
+func init() {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		qrExp[i] = byte(x)
+		qrLog[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return qrExp[(int(qrLog[a])+int(qrLog[b]))%255]
+}
+
+/*
+rsGenerator returns the coefficients of the generator polynomial of the degree, highest first, without the leading 1.
+*/
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+
+		root = gfMul(root, 2)
+	}
+
+	return result
+}
+
+/*
+rsRemainder returns the error correction codewords of the data.
+*/
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+
+	for _, b := range data {
+		factor := b ^ result[0]
+
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i := range result {
+			result[i] ^= gfMul(generator[i], factor)
+		}
+	}
+
+	return result
+}
+
+/*
+=====================
+Drawing
+=====================
+*/
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns() {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(qr.size-4, 3)
+	qr.drawFinder(3, qr.size-4)
+
+	positions := qrVersions[qr.version].alignmentPositions
+	last := len(positions) - 1
+
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners of the finders
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+
+			qr.drawAlignment(x, y)
+		}
+	}
+
+	// reserved until the mask is known
+	qr.drawFormatBits(0)
+	qr.drawVersionBits()
+}
+
+/*
+drawFinder draws the finder pattern centered at x, y along with its separator.
+*/
+func (qr *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+
+			if xx < 0 || yy < 0 || xx >= qr.size || yy >= qr.size {
+				continue
+			}
+
+			dist := maxInt(absInt(dx), absInt(dy))
+			qr.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (qr *qrCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.setFunction(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+/*
+qrFormatBits returns the 15 format bits of the error correction level M with the mask.
+*/
+func qrFormatBits(mask int) int {
+	// level M is 00
+	data := mask
+	rem := data
+
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (qr *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	// around the top left finder
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	// along the top right and bottom left finders
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+func (qr *qrCode) drawVersionBits() {
+	if qr.version < 7 {
+		return
+	}
+
+	rem := qr.version
+
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	bits := qr.version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := qr.size-11+i%3, i/3
+
+		qr.setFunction(a, b, dark)
+		qr.setFunction(b, a, dark)
+	}
+}
+
+/*
+drawCodewords places the codewords in the zigzag order, two columns at a time from the bottom right corner.
+*/
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+
+				if qr.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+
+				qr.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.function[y][x] && qrMask(mask, x, y) {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+/*
+penalty scores how hard the QR code is to scan: long runs and blocks of a color, patterns looking like
+the finders and an unbalanced number of dark modules.
+*/
+func (qr *qrCode) penalty() int {
+	result := 0
+	dark := 0
+
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, transposed := range []bool{false, true} {
+		for y := 0; y < qr.size; y++ {
+			run := 1
+
+			for x := 1; x <= qr.size; x++ {
+				if x < qr.size && at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for x := 0; x+11 <= qr.size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+
+					for i, want := range pattern {
+						if at(x+i, y, transposed) != want {
+							matches = false
+							break
+						}
+					}
+
+					if matches {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+
+			if x+1 < qr.size && y+1 < qr.size {
+				color := qr.modules[y][x]
+
+				if qr.modules[y][x+1] == color && qr.modules[y+1][x] == color && qr.modules[y+1][x+1] == color {
+					result += 3
+				}
+			}
+		}
+	}
+
+	total := qr.size * qr.size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+
+	return result
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
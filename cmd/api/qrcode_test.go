@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+/*
+Testing the Reed-Solomon error correction against the worked example of "HELLO WORLD" in version 1-M
+*/
+func Test_RSRemainder(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	ecc := rsRemainder(data, rsGenerator(10))
+
+	if !bytes.Equal(ecc, expected) {
+		t.Errorf("FAILED: Expected %v get %v", expected, ecc)
+	}
+}
+
+/*
+Testing the format bits against the table of the specification
+*/
+func Test_QRFormatBits(t *testing.T) {
+	expected := []int{
+		0b101010000010010,
+		0b101000100100101,
+		0b101111001111100,
+		0b101101101001011,
+		0b100010111111001,
+		0b100000011001110,
+		0b100111110010111,
+		0b100101010100000,
+	}
+
+	for mask, bits := range expected {
+		if got := qrFormatBits(mask); got != bits {
+			t.Errorf("FAILED: Mask %d Expected %015b get %015b", mask, bits, got)
+		}
+	}
+}
+
+/*
+Testing the QR codes of texts of every version by reading them back
+
+	-> The format, the error correction and the data match
+	-> A text too long for version 10 is refused
+*/
+func Test_EncodeQRCode(t *testing.T) {
+	for _, length := range []int{1, 14, 26, 42, 62, 84, 106, 122, 152, 180, 213} {
+		text := []byte(strings.Repeat("otpauth://totp/", 15)[:length])
+
+		qr, err := encodeQRCode(text)
+
+		if err != nil {
+			t.Fatalf("FAILED: %d bytes Expected no error get %s", length, err.Error())
+		}
+
+		decoded, err := readQRCode(qr)
+
+		if err != nil {
+			t.Fatalf("FAILED: %d bytes in version %d: %s", length, qr.version, err.Error())
+		}
+
+		if !bytes.Equal(decoded, text) {
+			t.Errorf("FAILED: Expected %q get %q", text, decoded)
+		}
+	}
+
+	if _, err := encodeQRCode(make([]byte, 214)); !errors.Is(err, errQRCodeTooLong) {
+		t.Errorf("FAILED: Expected %v get %v", errQRCodeTooLong, err)
+	}
+}
+
+/*
+Testing the PNG of a QR code, with the quiet zone around it
+*/
+func Test_QRCodePNG(t *testing.T) {
+	qr, err := encodeQRCode([]byte("otpauth://totp/Test:user?secret=ABC"))
+
+	if err != nil {
+		t.Fatalf("Failed to encode QR code: %s", err.Error())
+	}
+
+	image, err := qr.PNG(2)
+
+	if err != nil {
+		t.Fatalf("FAILED: Expected no error get %s", err.Error())
+	}
+
+	img, err := png.Decode(bytes.NewReader(image))
+
+	if err != nil {
+		t.Fatalf("FAILED: Expected a PNG get %s", err.Error())
+	}
+
+	if width := img.Bounds().Dx(); width != (qr.size+8)*2 {
+		t.Errorf("FAILED: Expected %d get %d", (qr.size+8)*2, width)
+	}
+}
+
+/*
+readQRCode reads the text back from the QR code, checking its format and error correction.
+*/
+func readQRCode(qr *qrCode) ([]byte, error) {
+	blank := newQRCode(qr.version)
+
+	// the first copy of the format bits, around the top left finder
+	var format int
+	positions := [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+
+	for i, p := range positions {
+		if qr.modules[p[1]][p[0]] {
+			format |= 1 << i
+		}
+	}
+
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if qrFormatBits(m) == format {
+			mask = m
+		}
+	}
+
+	if mask < 0 {
+		return nil, errors.New("invalid format bits")
+	}
+
+	var bits bitBuffer
+
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+
+				if !blank.function[y][x] {
+					bits = append(bits, qr.modules[y][x] != qrMask(mask, x, y))
+				}
+			}
+		}
+	}
+
+	layout := qrVersions[qr.version]
+	blockCount := layout.group1 + layout.group2
+	codewords := bits.bytes()
+
+	blocks := make([][]byte, blockCount)
+	eccs := make([][]byte, blockCount)
+	next := 0
+
+	for i := 0; i < layout.group2Data || i < layout.group1Data; i++ {
+		for b := range blocks {
+			length := layout.group1Data
+			if b >= layout.group1 {
+				length = layout.group2Data
+			}
+
+			if i < length {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+
+	for i := 0; i < layout.eccPerBlock; i++ {
+		for b := range eccs {
+			eccs[b] = append(eccs[b], codewords[next])
+			next++
+		}
+	}
+
+	var data []byte
+	generator := rsGenerator(layout.eccPerBlock)
+
+	for b := range blocks {
+		if !bytes.Equal(rsRemainder(blocks[b], generator), eccs[b]) {
+			return nil, errors.New("invalid error correction")
+		}
+
+		data = append(data, blocks[b]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		return nil, errors.New("not byte mode")
+	}
+
+	var length, offset int
+
+	if qrCountBits(qr.version) == 8 {
+		length = int(data[0]&0x0f)<<4 | int(data[1]>>4)
+		offset = 1
+	} else {
+		length = int(data[0]&0x0f)<<12 | int(data[1])<<4 | int(data[2]>>4)
+		offset = 2
+	}
+
+	text := make([]byte, length)
+	for i := range text {
+		text[i] = data[offset+i]<<4 | data[offset+i+1]>>4
+	}
+
+	return text, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Route groups with their own rate limit
+const (
+	rateLimitLogin = "login" // logins and everything else checking a password
+	rateLimitAuth  = "auth"  // the other public endpoints, like signup and password reset
+	rateLimitUsers = "users" // the endpoints of the logged in users
+)
+
+// the largest body byUsername reads the username from
+const maxBodySize = 1 << 20
+
+/*
+rateLimitKey returns what the requests are counted by, like the client IP, or "" if the request has none.
+*/
+type rateLimitKey func(c *gin.Context) string
+
+func byIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+/*
+byUsername counts the requests by the username in the JSON or form body, so that guessing the password of a user
+is throttled even when the requests come from many IPs. The body is put back for the handler.
+Each endpoint has its own bucket for the username, so that flooding one of them with the username of a user,
+like the magic links, does not throttle the other logins of the user.
+A body larger than maxBodySize is refused with 413, rather than cut short.
+*/
+func byUsername(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return ""
+	}
+
+	if len(body) > maxBodySize {
+		sendResponse("Request body too large", "request body too large", nil, c, http.StatusRequestEntityTooLarge)
+		c.Abort()
+		return ""
+	}
+
+	var username string
+
+	if c.ContentType() == binding.MIMEPOSTForm {
+		form, err := url.ParseQuery(string(body))
+
+		if err != nil {
+			return ""
+		}
+
+		username = form.Get("username")
+	} else {
+		var payload struct {
+			Username string `json:"username"`
+		}
+
+		if json.Unmarshal(body, &payload) != nil {
+			return ""
+		}
+
+		username = payload.Username
+	}
+
+	if username == "" {
+		return ""
+	}
+
+	return "username:" + c.Request.URL.Path + ":" + strings.ToLower(username)
+}
+
+/*
+byUser counts the requests by the logged in user, or by the machine client, it must run after the AuthorizationMiddleware.
+*/
+func byUser(c *gin.Context) string {
+	userId, ok := c.Get("userId")
+
+	if !ok {
+		if clientId, ok := c.Get("clientId"); ok {
+			return "client:" + clientId.(string)
+		}
+		return ""
+	}
+
+	return "user:" + userId.(string)
+}
+
+/*
+RateLimit returns a middleware that throttles the requests of the group with a token bucket per key,
+every key of the request must have a token left, and a throttled request takes no token from any of them.
+A throttled request gets 429 with the `Retry-After` header.
+A group without a limit in app.RateLimits is not throttled.
+*/
+func (app *Config) RateLimit(group string, keys ...rateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := app.RateLimits[group]
+
+		if !ok || limit.Requests <= 0 {
+			c.Next()
+			return
+		}
+
+		counted := []string{}
+
+		for _, key := range keys {
+			k := key(c)
+
+			if c.IsAborted() {
+				return
+			}
+
+			if k != "" {
+				counted = append(counted, group+":"+k)
+			}
+		}
+
+		if len(counted) == 0 {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter, err := app.RateLimitStore.Take(counted, limit, time.Now())
+
+		if err != nil {
+			// the rate limit is a protection, it does not take the service down with it
+			log.Println("@RATELIMIT Failed to take a token:", err)
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			sendResponse("Too many requests, try again later", "rate limit exceeded", nil, c, http.StatusTooManyRequests)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+parseRateLimit parses a rate limit like `10/1m`, ten requests per minute. `0` turns the limit off.
+*/
+func parseRateLimit(s string) (data.RateLimit, error) {
+	if s == "0" {
+		return data.RateLimit{}, nil
+	}
+
+	requests, period, ok := strings.Cut(s, "/")
+
+	if !ok {
+		return data.RateLimit{}, fmt.Errorf("rate limit %q is not like 10/1m", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+
+	if err != nil || n <= 0 {
+		return data.RateLimit{}, fmt.Errorf("rate limit %q must have a positive number of requests", s)
+	}
+
+	d, err := time.ParseDuration(period)
+
+	if err != nil || d <= 0 {
+		return data.RateLimit{}, fmt.Errorf("rate limit %q must have a positive period", s)
+	}
+
+	return data.RateLimit{Requests: n, Period: d}, nil
+}
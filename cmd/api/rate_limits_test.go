@@ -0,0 +1,214 @@
+package main
+
+import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Testing the token bucket
+
+	-> A burst up to the limit is allowed
+	-> The bucket refills over time
+	-> The buckets of different keys are independent
+	-> A request throttled by one of its keys takes no token from the others
+*/
+func Test_TokenBucket(t *testing.T) {
+	store := data.NewMemoryRateLimitStore()
+	limit := data.RateLimit{Requests: 2, Period: time.Second}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := store.Take([]string{"key"}, limit, now); !allowed {
+			t.Fatalf("FAILED: Expected request %d to be allowed", i+1)
+		}
+	}
+
+	allowed, retryAfter, _ := store.Take([]string{"key"}, limit, now)
+
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Errorf("FAILED: Expected to be throttled for 500ms get %t %s", allowed, retryAfter)
+	}
+
+	if allowed, _, _ := store.Take([]string{"other-key"}, limit, now); !allowed {
+		t.Errorf("FAILED: Expected another key to be allowed")
+	}
+
+	if allowed, _, _ := store.Take([]string{"key"}, limit, now.Add(500*time.Millisecond)); !allowed {
+		t.Errorf("FAILED: Expected to be allowed after the refill")
+	}
+
+	// "key" is empty again, "other-key" has one token left which must not be taken
+	if allowed, _, _ := store.Take([]string{"key", "other-key"}, limit, now.Add(500*time.Millisecond)); allowed {
+		t.Errorf("FAILED: Expected to be throttled by the empty bucket")
+	}
+
+	if allowed, _, _ := store.Take([]string{"other-key"}, limit, now.Add(500*time.Millisecond)); !allowed {
+		t.Errorf("FAILED: Expected the throttled request to leave the token of the other key")
+	}
+}
+
+/*
+Testing the username key of the rate limits
+
+	-> The username is read from JSON and form bodies, and the body is left for the handler
+	-> A body over the size cap is refused with 413
+*/
+func Test_ByUsername(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		contentType string
+		body        string
+		key         string
+	}{
+		{"application/json", `{"username":"Alice","password":"password"}`, "username:/v1/login:alice"},
+		{"application/x-www-form-urlencoded", url.Values{"username": {"Alice"}, "password": {"password"}}.Encode(), "username:/v1/login:alice"},
+		{"application/json", `{"password":"password"}`, ""},
+	}
+
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(test.body))
+		c.Request.Header.Set("Content-Type", test.contentType)
+
+		if key := byUsername(c); key != test.key {
+			t.Errorf("FAILED: Expected %q get %q", test.key, key)
+		}
+
+		if body, _ := io.ReadAll(c.Request.Body); string(body) != test.body {
+			t.Errorf("FAILED: Expected the body to be left for the handler get %q", body)
+		}
+	}
+
+	reqRecorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(reqRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(strings.Repeat("a", maxBodySize+1)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	byUsername(c)
+
+	if !c.IsAborted() || reqRecorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusRequestEntityTooLarge, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the rate limit of POST /v1/login
+
+	-> The requests over the limit get 429 with Retry-After
+	-> Another username from the same IP is throttled too
+*/
+func Test_LoginRateLimit(t *testing.T) {
+	app := testApp
+	app.RateLimitStore = data.NewMemoryRateLimitStore()
+	app.RateLimits = map[string]data.RateLimit{
+		rateLimitLogin: {Requests: 2, Period: time.Minute},
+	}
+
+	testRouter := app.routes()
+
+	for i := 0; i < 2; i++ {
+		reqRecorder := serve(testRouter, http.MethodPost, "/v1/login", `{"username":"username","password":"password"}`, "")
+
+		if reqRecorder.Code != http.StatusOK {
+			t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+		}
+	}
+
+	reqRecorder := serve(testRouter, http.MethodPost, "/v1/login", `{"username":"username","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusTooManyRequests, reqRecorder.Code)
+	}
+
+	if retryAfter := reqRecorder.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("FAILED: Expected Retry-After 30 get %q", retryAfter)
+	}
+
+	reqRecorder = serve(testRouter, http.MethodPost, "/v1/login", `{"username":"other-username","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusTooManyRequests {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusTooManyRequests, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the rate limits of the login endpoints
+
+	-> Each endpoint has its own bucket for a username, the magic links do not throttle the password login
+	-> The username is still throttled on an endpoint across IPs
+*/
+func Test_LoginRateLimitPerEndpoint(t *testing.T) {
+	app := testApp
+	app.RateLimitStore = data.NewMemoryRateLimitStore()
+	app.RateLimits = map[string]data.RateLimit{
+		rateLimitLogin: {Requests: 2, Period: time.Minute},
+	}
+
+	testRouter := app.routes()
+
+	serveFrom := func(ip, url string) int {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"username":"username","password":"password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+
+		reqRecorder := httptest.NewRecorder()
+		testRouter.ServeHTTP(reqRecorder, req)
+
+		return reqRecorder.Code
+	}
+
+	for _, ip := range []string{"192.0.2.10", "192.0.2.11"} {
+		if code := serveFrom(ip, "/v1/login/magic"); code == http.StatusTooManyRequests {
+			t.Fatalf("FAILED: Expected the magic link not to be throttled get %d", code)
+		}
+	}
+
+	if code := serveFrom("192.0.2.12", "/v1/login/magic"); code != http.StatusTooManyRequests {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusTooManyRequests, code)
+	}
+
+	for _, ip := range []string{"192.0.2.13", "192.0.2.14"} {
+		if code := serveFrom(ip, "/v1/login"); code != http.StatusOK {
+			t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, code)
+		}
+	}
+
+	if code := serveFrom("192.0.2.15", "/v1/login"); code != http.StatusTooManyRequests {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusTooManyRequests, code)
+	}
+}
+
+/*
+Testing the parsing of the rate limits from the environment
+*/
+func Test_ParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit data.RateLimit
+		valid bool
+	}{
+		{"10/1m", data.RateLimit{Requests: 10, Period: time.Minute}, true},
+		{"0", data.RateLimit{}, true},
+		{"10", data.RateLimit{}, false},
+		{"-1/1m", data.RateLimit{}, false},
+		{"10/forever", data.RateLimit{}, false},
+	}
+
+	for _, test := range tests {
+		limit, err := parseRateLimit(test.value)
+
+		if (err == nil) != test.valid || limit != test.limit {
+			t.Errorf("FAILED: %q Expected %v %t get %v %v", test.value, test.limit, test.valid, limit, err)
+		}
+	}
+}
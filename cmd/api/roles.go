@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+allRoles is a handler that returns the built in and custom roles of the organization with their permissions,
+along with every permission which can be granted.
+*/
+func (app *Config) allRoles(c *gin.Context) {
+	roles, err := app.Repo.GetRoles(currentUser(c).OrganizationID)
+
+	if err != nil {
+		sendResponse("Failed to get roles", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully get all roles in organization", "", map[string]any{
+		"roles":       roles,
+		"permissions": data.Permissions,
+	}, c, http.StatusOK)
+}
+
+/*
+createRole is a handler that takes the name and the permissions of a new custom role from the request body.
+It can only be called by a user with the roles:manage permission.
+*/
+func (app *Config) createRole(c *gin.Context) {
+	var reqPayload struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(reqPayload.Name)
+
+	if name == "" {
+		sendResponse("Missing Role Name in request", "missing role name in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	for _, permission := range reqPayload.Permissions {
+		if !isPermission(permission) {
+			sendResponse("Unknown permission in request", "unknown permission "+permission, nil, c, http.StatusBadRequest)
+			return
+		}
+	}
+
+	role := data.Role{
+		OrganizationID: currentUser(c).OrganizationID,
+		Name:           name,
+		Permissions:    reqPayload.Permissions,
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	err = app.Repo.CreateRole(role)
+
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateRole) {
+			sendResponse("Role already exists", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to create role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully created role", "", map[string]any{
+		"role": role,
+	}, c, http.StatusOK)
+}
+
+/*
+deleteRole is a handler that deletes a custom role of the organization, which is not assigned to any user.
+It can only be called by a user with the roles:manage permission.
+*/
+func (app *Config) deleteRole(c *gin.Context) {
+	role, err := app.Repo.GetRole(currentUser(c).OrganizationID, c.Param("name"))
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if role.Name == "" {
+		sendResponse("Role does not exist", "role does not exist", nil, c, http.StatusNotFound)
+		return
+	}
+
+	if role.BuiltIn {
+		sendResponse("Built in roles can not be deleted", "built in role", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.DeleteRole(*role)
+
+	if err != nil {
+		if errors.Is(err, data.ErrRoleInUse) {
+			sendResponse("Role is still assigned to users", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to delete role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully deleted role", "", nil, c, http.StatusOK)
+}
+
+/*
+updateUserRole is a handler that takes from the request body the new role of the user from the `id` path parameter.
+The last admin of an organization can not be demoted, and nobody can change their own role.
+It can only be called by a user of the same organization with the users:update_role permission,
+who holds every permission of both the current and the new role of the user.
+*/
+func (app *Config) updateUserRole(c *gin.Context) {
+	user, ok := app.userFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	var reqPayload struct {
+		Role string `json:"role"`
+	}
+
+	err := c.Bind(&reqPayload)
+
+	if err != nil {
+		sendResponse("Error reading request body", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if reqPayload.Role == "" {
+		sendResponse("Missing Role in request", "missing role in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	role, err := app.Repo.GetRole(user.OrganizationID, reqPayload.Role)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if role.Name == "" {
+		sendResponse("Role does not exist", "role does not exist", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	if user.ID == currentUser(c).ID {
+		sendResponse("Can not change your own role", "can not change your own role", nil, c, http.StatusForbidden)
+		return
+	}
+
+	currentRole, err := app.Repo.GetRole(user.OrganizationID, user.Role)
+
+	if err != nil {
+		sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	for _, changed := range []data.Role{*currentRole, *role} {
+		allowed, err := app.canGrantRole(c, changed)
+
+		if err != nil {
+			sendResponse("Failed to get role", err.Error(), nil, c, http.StatusInternalServerError)
+			return
+		}
+
+		if !allowed {
+			sendResponse("Not Authorized", "role "+changed.Name+" grants more than your permissions", nil, c, http.StatusForbidden)
+			return
+		}
+	}
+
+	change, err := app.Repo.UpdateUserRole(*user, role.Name, *currentUser(c))
+
+	if err != nil {
+		if errors.Is(err, data.ErrLastAdmin) {
+			sendResponse("Can not demote the last admin of the organization", err.Error(), nil, c, http.StatusConflict)
+			return
+		}
+		sendResponse("Failed to update role", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	user.Role = role.Name
+
+	sendResponse("Successfully updated role", "", map[string]any{
+		"user":   user,
+		"change": change,
+	}, c, http.StatusOK)
+}
+
+/*
+canGrantRole reports whether the current user may give the role to a user, or take it away: the current user must hold
+every permission of the role, and only an admin can give the admin role. A machine client holds the permissions of
+its scopes, and a user behind an OAuth client only the permissions of their role which are granted to the token.
+*/
+func (app *Config) canGrantRole(c *gin.Context, role data.Role) (bool, error) {
+	held, err := app.heldPermissions(c)
+
+	if err != nil {
+		return false, err
+	}
+
+	if role.Name == data.AdminRole && currentUser(c).Role != data.AdminRole {
+		return false, nil
+	}
+
+	for _, permission := range role.Permissions {
+		if !hasScope(held, permission) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+/*
+heldPermissions returns the permissions the current user holds in the request, as RequirePermission checks them.
+*/
+func (app *Config) heldPermissions(c *gin.Context) ([]string, error) {
+	scopes, scoped := c.Get("scopes")
+
+	if client, ok := c.Get("currentClient"); ok {
+		return intersectScopes(client.(*data.OAuthClient).Scopes, scopes.([]string)), nil
+	}
+
+	user := currentUser(c)
+
+	role, err := app.Repo.GetRole(user.OrganizationID, user.Role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if scoped {
+		return intersectScopes(role.Permissions, scopes.([]string)), nil
+	}
+
+	return role.Permissions, nil
+}
+
+/*
+intersectScopes returns the scopes of a which are also in b.
+*/
+func intersectScopes(a, b []string) []string {
+	scopes := []string{}
+
+	for _, scope := range a {
+		if hasScope(b, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+func isPermission(permission string) bool {
+	for _, known := range data.Permissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
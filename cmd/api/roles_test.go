@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+/*
+Testing the permissions of a member
+
+	-> A member can list the users of the organization
+	-> A member can not add users, delete users, manage roles or change roles
+*/
+func Test_MemberPermissions(t *testing.T) {
+	memberToken, _ := loginTestUser(t)
+
+	reqRecorder := serve(router, http.MethodGet, "/v1/users", "", memberToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	requests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{http.MethodPost, "/v1/add", `{"username":"new-user","password":"password"}`},
+		{http.MethodDelete, "/v1/delete", `{"username":"new-user"}`},
+		{http.MethodPost, "/v1/roles", `{"name":"auditor","permissions":["users:read"]}`},
+		{http.MethodDelete, "/v1/roles/auditor", ""},
+		{http.MethodPatch, "/v1/users/random-test-id/role", `{"role":"admin"}`},
+	}
+
+	for _, req := range requests {
+		reqRecorder := serve(router, req.method, req.url, req.body, memberToken)
+
+		if reqRecorder.Code != http.StatusForbidden {
+			t.Errorf("FAILED: %s %s Expected %d get %d", req.method, req.url, http.StatusForbidden, reqRecorder.Code)
+		}
+	}
+}
+
+/*
+Testing /v1/roles
+
+	-> Create a custom role
+	-> Duplicate and built in role names, unknown permissions
+	-> List the built in and custom roles
+	-> Delete the custom role, built in roles can not be deleted
+*/
+func Test_RoleLifecycle(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	body := `{"name":"support","permissions":["users:read","sessions:manage"]}`
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/roles", body, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/roles", body, adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/roles", `{"name":"admin","permissions":[]}`, adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/roles", `{"name":"root","permissions":["everything"]}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/roles", "", adminToken)
+
+	var listed struct {
+		Data struct {
+			Roles []struct {
+				Name    string `json:"name"`
+				BuiltIn bool   `json:"built_in"`
+			} `json:"roles"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &listed)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	names := map[string]bool{}
+	for _, role := range listed.Data.Roles {
+		names[role.Name] = role.BuiltIn
+	}
+
+	if builtIn, ok := names["admin"]; !ok || !builtIn {
+		t.Errorf("FAILED: Expected built in admin role get %s", reqRecorder.Body.String())
+	}
+
+	if builtIn, ok := names["support"]; !ok || builtIn {
+		t.Errorf("FAILED: Expected custom support role get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/roles/member", "", adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/roles/support", "", adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/roles/support", "", adminToken)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing PATCH /v1/users/:id/role
+
+	-> Promote a member, the change is recorded with who made it
+	-> Unknown role
+	-> Demote the last admin of the organization
+*/
+func Test_UpdateUserRole(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPatch, "/v1/users/random-test-id/role", `{"role":"admin"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	var updated struct {
+		Data struct {
+			Change struct {
+				OldRole     string `json:"old_role"`
+				NewRole     string `json:"new_role"`
+				ChangedByID string `json:"changed_by_id"`
+			} `json:"change"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(reqRecorder.Body.Bytes(), &updated)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err.Error())
+	}
+
+	change := updated.Data.Change
+
+	if change.OldRole != "member" || change.NewRole != "admin" || change.ChangedByID != "test-user-id" {
+		t.Errorf("FAILED: Expected change from member to admin by test-user-id get %s", reqRecorder.Body.String())
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/random-test-id/role", `{"role":"owner"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/last-admin-id/role", `{"role":"member"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+}
+
+/*
+Testing PATCH /v1/users/:id/role and POST /v1/invitations with a custom role
+
+	-> Nobody can change their own role, not even an admin
+	-> A user of a custom role can not give the admin role, nor invite an admin
+	-> A user of a custom role can give the roles whose permissions they hold
+*/
+func Test_UpdateUserRoleEscalation(t *testing.T) {
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/roles",
+		`{"name":"role-manager","permissions":["users:read","users:create","users:update_role"]}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/test-user-id/role", `{"role":"member"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the admin to keep their own role get %d", reqRecorder.Code)
+	}
+
+	managerToken, err := signJWTTestTokenFor(testApp.Keys, "role-manager-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/role-manager-id/role", `{"role":"admin"}`, managerToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected self promotion to be refused get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/random-test-id/role", `{"role":"admin"}`, managerToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the promotion to admin to be refused get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/invitations", `{"username":"unknown-user","email":"admin@example.com","role":"admin"}`, managerToken)
+
+	if reqRecorder.Code != http.StatusForbidden {
+		t.Errorf("FAILED: Expected the invitation of an admin to be refused get %d", reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPatch, "/v1/users/random-test-id/role", `{"role":"role-manager"}`, managerToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d %s", http.StatusOK, reqRecorder.Code, reqRecorder.Body.String())
+	}
+}
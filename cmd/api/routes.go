@@ -1,6 +1,9 @@
 package main
 
 import (
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -22,28 +25,168 @@ func (app *Config) routes() *gin.Engine {
 	//	Enabling Cors
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "DELETE"},
+		AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE"},
 		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposeHeaders:    []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	// Public signing keys, so that other services can verify our tokens
+	router.GET("/.well-known/jwks.json", app.jwks)
+
+	// OpenID Connect discovery, so that the OIDC libraries can be pointed at the issuer alone
+	router.GET("/.well-known/openid-configuration", app.openIDConfiguration)
+
+	// Only the trusted proxies can set the client IP, which the rate limits count by
+	err := router.SetTrustedProxies(app.TrustedProxies)
+
+	if err != nil {
+		log.Println("@ROUTES Invalid trusted proxies, trusting none:", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	// Rate limits, strict on the logins and looser on the rest
+	loginLimit := app.RateLimit(rateLimitLogin, byIP, byUsername)
+	passwordLimit := app.RateLimit(rateLimitLogin, byUser)
+	authLimit := app.RateLimit(rateLimitAuth, byIP, byUsername)
+	usersLimit := app.RateLimit(rateLimitUsers, byUser)
+
+	// OAuth 2.0 authorization server, for the clients registered by the organizations
+	oauth := router.Group("/oauth")
+	oauth.GET("/authorize", app.RateLimit(rateLimitAuth, byIP), app.authorize)
+	oauth.POST("/authorize", loginLimit, app.approveAuthorization)
+	oauth.POST("/token", app.RateLimit(rateLimitAuth, byIP), app.token)
+
+	// Resource servers check the tokens, and the clients revoke their own
+	oauth.POST("/introspect", app.RateLimit(rateLimitUsers, byIP), app.introspect)
+	oauth.POST("/revoke", app.RateLimit(rateLimitAuth, byIP), app.revoke)
+
+	// Device flow, for the CLIs which can not open a browser: a logged in user approves the user code of the device
+	oauth.POST("/device_authorization", app.RateLimit(rateLimitAuth, byIP), app.deviceAuthorization)
+	oauth.GET("/device", app.RateLimit(rateLimitLogin, byIP), app.getDeviceAuthorization)
+	oauth.POST("/device", app.RateLimit(rateLimitLogin, byIP), app.decideDeviceAuthorization)
+
+	// Claims of the user of an access token with the openid scope
+	router.GET("/userinfo", app.AuthorizationMiddleware, usersLimit, app.userInfo)
+	router.POST("/userinfo", app.AuthorizationMiddleware, usersLimit, app.userInfo)
+
 	//Grouping by version
 	v1 := router.Group("/v1")
 
 	// Registering Routes
-	v1.POST("/login", app.login)   // User Login
-	v1.POST("/logout", app.logout) // User Logout
+	v1.POST("/signup", authLimit, app.signup) // New Organization with its first admin
+	v1.POST("/login", loginLimit, app.login)  // User Login
+	v1.POST("/logout", app.logout)            // User Logout
+
+	// Second step of the login of the users with MFA
+	v1.POST("/login/mfa", app.RateLimit(rateLimitLogin, byIP), app.loginMFA)
+
+	// Login with a link sent to the user, instead of the password
+	v1.POST("/login/magic", loginLimit, app.requestMagicLink)
+	v1.POST("/login/magic/verify", app.RateLimit(rateLimitLogin, byIP), app.verifyMagicLink)
+
+	// Revoke every session of the current user
+	v1.POST("/logout/all", app.AuthorizationMiddleware, app.RequireSession, usersLimit, app.logoutEverywhere)
+
+	// Exchange a refresh token for a new access token, rotating the refresh token
+	v1.POST("/token/refresh", authLimit, app.refreshToken)
+
+	// Password reset, the link is sent to the user by the notifier
+	v1.POST("/password/forgot", authLimit, app.forgotPassword)
+	v1.POST("/password/reset", authLimit, app.resetPassword)
 
-	// Admin User adds a new User account(by providing the username & password)
-	v1.POST("/add", app.AuthorizationMiddleware, app.addUser)
+	// Profile and email of the current user
+	v1.GET("/me", app.AuthorizationMiddleware, app.RequireSession, usersLimit, app.getMe)
+	v1.PATCH("/me", app.AuthorizationMiddleware, app.RequireSession, passwordLimit, app.updateMe)
+	v1.POST("/me/email/verification", app.AuthorizationMiddleware, app.RequireSession, passwordLimit, app.resendEmailVerification)
+	v1.POST("/email/verify", authLimit, app.verifyEmail)
+
+	// Change the password of the current user
+	v1.POST("/me/password", app.AuthorizationMiddleware, app.RequireSession, passwordLimit, app.changePassword)
+
+	// TOTP enrollment and recovery codes of the current user
+	mfa := v1.Group("/me/mfa", app.AuthorizationMiddleware, app.RequireSession, passwordLimit)
+	mfa.POST("/totp", app.enrollTOTP)
+	mfa.POST("/totp/confirm", app.confirmTOTP)
+	mfa.DELETE("/totp", app.disableTOTP)
+	mfa.POST("/recovery-codes", app.regenerateRecoveryCodes)
+
+	// Passkeys of the current user
+	webauthn := v1.Group("/webauthn")
+	passkeys := webauthn.Group("", app.AuthorizationMiddleware, app.RequireSession)
+	passkeys.POST("/register/begin", passwordLimit, app.beginPasskeyRegistration)
+	passkeys.POST("/register/finish", passwordLimit, app.finishPasskeyRegistration)
+	passkeys.GET("/credentials", usersLimit, app.allPasskeys) // reading them does not use up the strict bucket
+	passkeys.DELETE("/credentials/:id", passwordLimit, app.deletePasskey)
+
+	// Login with a passkey, alone or as the second factor after the password
+	passkeyLimit := app.RateLimit(rateLimitLogin, byIP)
+	webauthn.POST("/login/begin", passkeyLimit, app.beginPasskeyLogin)
+	webauthn.POST("/login/finish", passkeyLimit, app.finishPasskeyLogin)
+	webauthn.POST("/mfa/begin", passkeyLimit, app.beginPasskeyMFA)
+	webauthn.POST("/mfa/finish", passkeyLimit, app.finishPasskeyMFA)
+
+	// MFA policy of the organization
+	v1.GET("/mfa/policy", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(), app.getMFAPolicy)
+	v1.PATCH("/mfa/policy", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermMFAPolicyManage), app.updateMFAPolicy)
+
+	// Password policy of the organization
+	v1.GET("/password/policy", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(), app.getPasswordPolicy)
+	v1.PATCH("/password/policy", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermPasswordPolicyManage), app.updatePasswordPolicy)
+
+	// Admin User invites a new User, who sets their own password by accepting the invitation
+	invitations := v1.Group("/invitations", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersCreate))
+	invitations.POST("", app.inviteUser)
+	invitations.GET("", app.allInvitations)
+	invitations.POST("/:id/resend", app.resendInvitation)
+	invitations.DELETE("/:id", app.revokeInvitation)
+	v1.POST("/invitations/accept", authLimit, app.acceptInvitation)
+
+	// Deprecated, use the invitations. Admin User adds a new User account(by providing the username & password)
+	v1.POST("/add", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersCreate), app.addUser)
 
 	// Admin User deletes an existing User account from their organization
-	v1.DELETE("/delete", app.AuthorizationMiddleware, app.deleteUser)
+	v1.DELETE("/delete", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersDelete), app.deleteUser)
 
 	//List all Users in their organization
-	v1.GET("/users", app.AuthorizationMiddleware, app.allUsers)
+	v1.GET("/users", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersRead), app.allUsers)
+
+	// Admin User promotes or demotes a User from their organization
+	v1.PATCH("/users/:id/role", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersUpdateRole), app.updateUserRole)
+
+	// Admin User unlocks a User from their organization locked out by failed logins
+	v1.POST("/users/:id/unlock", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersUnlock), app.unlockUser)
+
+	// Admin User lists and kills the sessions of a User from their organization
+	sessions := v1.Group("/users/:id/sessions", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermSessionsManage))
+	sessions.GET("", app.userSessions)
+	sessions.DELETE("", app.killUserSessions)
+	sessions.DELETE("/:sid", app.killUserSession)
+
+	// Roles of the organization, built in and custom ones
+	v1.GET("/roles", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermUsersRead), app.allRoles)
+	v1.POST("/roles", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermRolesManage), app.createRole)
+	v1.DELETE("/roles/:name", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermRolesManage), app.deleteRole)
+
+	// OAuth clients of the organization, which the users can authorize
+	clients := v1.Group("/oauth/clients", app.AuthorizationMiddleware, usersLimit, app.RequirePermission(data.PermClientsManage))
+	clients.POST("", app.createOAuthClient)
+	clients.GET("", app.allOAuthClients)
+	clients.DELETE("/:id", app.deleteOAuthClient)
+
+	// Platform Admin creates, lists, renames and deletes organizations
+	orgs := v1.Group("/orgs", app.AuthorizationMiddleware, app.RequireSession, app.PlatformAdminMiddleware)
+	orgs.POST("", app.createOrganization)
+	orgs.GET("", app.allOrganizations)
+	orgs.PATCH("/:id", app.renameOrganization)
+	orgs.DELETE("/:id", app.deleteOrganization)
+
+	// Platform Admin lists, rotates and retires the keys signing the JWT tokens
+	keys := v1.Group("/keys", app.AuthorizationMiddleware, app.RequireSession, app.PlatformAdminMiddleware)
+	keys.GET("", app.listKeys)
+	keys.POST("/rotate", app.rotateKeys)
+	keys.DELETE("/:kid", app.retireKey)
 
 	return router
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	refreshTokenCookie = "RefreshToken"
+)
+
+/*
+newAccessToken creates a short lived JWT access token for the user in the given session.
+Every token carries its own id (jti) and the id of the session (sid) it belongs to, so that both can be revoked.
+The issued at time (iat) has microsecond precision, so that a token issued right after a user revoked all of
+its tokens is not mistaken for one issued before. The subject type (sub_type) tells it from the tokens of the machine clients.
+*/
+func (app *Config) newAccessToken(user data.User, sessionID string) (string, error) {
+	now := time.Now()
+
+	return app.Keys.Sign(jwt.MapClaims{
+		"userId":   user.ID,
+		"sub_type": subjectUser,
+		"jti":      uuid.NewString(),
+		"sid":      sessionID,
+		"iat":      float64(now.UnixMicro()) / 1e6,
+		"exp":      now.Add(accessTokenTTL).Unix(),
+	})
+}
+
+/*
+newRefreshToken creates a random refresh token for the user in the given family.
+It returns the token to hand out to the client and the RefreshToken record, holding only its hash, to store in the database.
+*/
+func (app *Config) newRefreshToken(user data.User, familyID string) (string, data.RefreshToken, error) {
+	tokenString, err := randomToken()
+
+	if err != nil {
+		return "", data.RefreshToken{}, err
+	}
+
+	refreshToken := data.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(tokenString),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+
+	return tokenString, refreshToken, nil
+}
+
+/*
+startSession issues a new access token and a new refresh token family for the user and sets both in the cookies.
+*/
+func (app *Config) startSession(c *gin.Context, user data.User) error {
+	// the refresh token family is the session
+	sessionID := uuid.NewString()
+
+	accessToken, err := app.newAccessToken(user, sessionID)
+
+	if err != nil {
+		return err
+	}
+
+	refreshTokenString, refreshToken, err := app.newRefreshToken(user, sessionID)
+
+	if err != nil {
+		return err
+	}
+
+	err = app.Repo.InsertRefreshToken(refreshToken)
+
+	if err != nil {
+		return err
+	}
+
+	setSessionCookies(c, accessToken, refreshTokenString)
+	return nil
+}
+
+func setSessionCookies(c *gin.Context, accessToken, refreshToken string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("Authorization", accessToken, int(accessTokenTTL.Seconds()), "", "", false, true)
+	c.SetCookie(refreshTokenCookie, refreshToken, int(refreshTokenTTL.Seconds()), "/v1", "", false, true)
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("Authorization", "", -1, "", "", false, true)
+	c.SetCookie(refreshTokenCookie, "", -1, "/v1", "", false, true)
+}
+
+/*
+refreshToken is a handler that exchanges a refresh token for a new access token and a new refresh token.
+The refresh token is read from the cookie, or from the request body for clients which do not keep cookies.
+Every refresh token can only be used once, presenting it a second time revokes the whole token family.
+*/
+func (app *Config) refreshToken(c *gin.Context) {
+	refreshTokenString, err := c.Cookie(refreshTokenCookie)
+
+	if err != nil || refreshTokenString == "" {
+		var reqPayload struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		// body is optional when the cookie is present, so a bind error only means there is no token
+		_ = c.ShouldBindJSON(&reqPayload)
+		refreshTokenString = reqPayload.RefreshToken
+	}
+
+	if refreshTokenString == "" {
+		sendResponse("Missing refresh token in request", "missing refresh token in request", nil, c, http.StatusBadRequest)
+		return
+	}
+
+	storedToken, err := app.Repo.GetRefreshToken(hashToken(refreshTokenString))
+
+	if err != nil {
+		sendResponse("Error while getting refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	// the refresh tokens of the OAuth clients are only refreshed at /oauth/token, with their scopes
+	if storedToken.ID == "" || storedToken.ClientID != "" || storedToken.RevokedAt != nil || time.Now().After(storedToken.ExpiresAt) {
+		sendResponse("Invalid refresh token", "invalid refresh token", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	if storedToken.UsedAt != nil {
+		app.refreshTokenReused(c, *storedToken)
+		return
+	}
+
+	user, err := app.Repo.GetByID(storedToken.UserID)
+
+	if err != nil {
+		sendResponse("Error while getting user", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	if user.ID == "" {
+		sendResponse("Invalid refresh token", "invalid refresh token", nil, c, http.StatusUnauthorized)
+		return
+	}
+
+	nextTokenString, nextToken, err := app.newRefreshToken(*user, storedToken.FamilyID)
+
+	if err != nil {
+		sendResponse("Failed to create refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Repo.RotateRefreshToken(*storedToken, nextToken)
+
+	if err != nil {
+		if errors.Is(err, data.ErrRefreshTokenReused) {
+			app.refreshTokenReused(c, *storedToken)
+			return
+		}
+		sendResponse("Failed to rotate refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := app.newAccessToken(*user, storedToken.FamilyID)
+
+	if err != nil {
+		sendResponse("Failed to create JWT Token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookies(c, accessToken, nextTokenString)
+
+	sendResponse("Token refreshed", "", map[string]any{
+		"user": user,
+	}, c, http.StatusOK)
+}
+
+/*
+refreshTokenReused revokes the whole family of a refresh token which was presented after it had already been used.
+*/
+func (app *Config) refreshTokenReused(c *gin.Context, token data.RefreshToken) {
+	err := app.Repo.RevokeRefreshTokenFamily(token.FamilyID)
+
+	if err != nil {
+		sendResponse("Failed to revoke refresh token", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(c)
+	sendResponse("Refresh token reuse detected", "refresh token reuse detected", nil, c, http.StatusUnauthorized)
+}
+
+/*
+logoutEverywhere is a handler that revokes every access token and every refresh token of the current user.
+*/
+func (app *Config) logoutEverywhere(c *gin.Context) {
+	userId, _ := c.Get("userId")
+
+	err := app.revokeAllSessions(userId.(string))
+
+	if err != nil {
+		sendResponse("Failed to revoke sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(c)
+	sendResponse("Logged out from all sessions", "", nil, c, http.StatusOK)
+}
+
+/*
+userSessions is a handler that lists the active sessions of a user.
+It can only be called by a user of the same organization with the sessions:manage permission.
+*/
+func (app *Config) userSessions(c *gin.Context) {
+	user, ok := app.userFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	tokens, err := app.Repo.GetActiveRefreshTokens(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to get sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sessions := make([]map[string]any, 0, len(tokens))
+	for _, token := range tokens {
+		session := map[string]any{
+			"id":         token.FamilyID,
+			"expires_at": token.ExpiresAt,
+		}
+
+		// the sessions of the OAuth clients, which the user authorized
+		if token.ClientID != "" {
+			session["client_id"] = token.ClientID
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	sendResponse("Successfully get sessions of user", "", map[string]any{
+		"sessions": sessions,
+	}, c, http.StatusOK)
+}
+
+/*
+killUserSession is a handler that revokes a single session of a user, both its access tokens and its refresh token.
+It can only be called by a user of the same organization with the sessions:manage permission.
+*/
+func (app *Config) killUserSession(c *gin.Context) {
+	user, ok := app.userFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	sessionID := c.Param("sid")
+
+	tokens, err := app.Repo.GetActiveRefreshTokens(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to get sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	found := false
+	for _, token := range tokens {
+		if token.FamilyID == sessionID {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		sendResponse("Session does not exist", "session does not exist", nil, c, http.StatusNotFound)
+		return
+	}
+
+	err = app.revokeSession(sessionID)
+
+	if err != nil {
+		sendResponse("Failed to revoke session", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully revoked session", "", nil, c, http.StatusOK)
+}
+
+/*
+killUserSessions is a handler that revokes every session of a user.
+It can only be called by a user of the same organization with the sessions:manage permission.
+*/
+func (app *Config) killUserSessions(c *gin.Context) {
+	user, ok := app.userFromPath(c)
+
+	if !ok {
+		return
+	}
+
+	err := app.revokeAllSessions(user.ID)
+
+	if err != nil {
+		sendResponse("Failed to revoke sessions", err.Error(), nil, c, http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse("Successfully revoked all sessions of user", "", nil, c, http.StatusOK)
+}
+
+/*
+revokeSession revokes the refresh tokens and the access tokens of a single session.
+*/
+func (app *Config) revokeSession(sessionID string) error {
+	err := app.Repo.RevokeRefreshTokenFamily(sessionID)
+
+	if err != nil {
+		return err
+	}
+
+	// access tokens of the session are valid for at most accessTokenTTL from now
+	return app.Revocations.Revoke(sessionID, time.Now().Add(accessTokenTTL))
+}
+
+/*
+revokeAllSessions revokes every access token issued to the user until now and every refresh token of the user.
+*/
+func (app *Config) revokeAllSessions(userID string) error {
+	err := app.Revocations.RevokeUser(userID, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	return app.Repo.RevokeUserRefreshTokens(userID)
+}
+
+// randomToken returns 32 bytes of randomness encoded as url safe base64
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token, which is what we store in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Testing POST /v1/token/refresh
+
+	-> Success, the refresh token is rotated
+	-> Reusing an already rotated refresh token revokes the whole family
+*/
+func Test_RefreshTokenRotation(t *testing.T) {
+	_, refreshToken := loginTestUser(t)
+
+	reqRecorder := postRefreshToken(t, refreshToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	rotatedToken := getCookie(reqRecorder, refreshTokenCookie)
+
+	if rotatedToken == "" || rotatedToken == refreshToken {
+		t.Fatal("FAILED: Refresh token was not rotated")
+	}
+
+	if getCookie(reqRecorder, "Authorization") == "" {
+		t.Error("FAILED: Authorization Cookie absent after refresh")
+	}
+
+	// presenting the old refresh token again is a reuse
+	reqRecorder = postRefreshToken(t, refreshToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// and the reuse revoked the rotated token as well
+	reqRecorder = postRefreshToken(t, rotatedToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/token/refresh
+
+	-> The refresh tokens of a deleted user can not be used anymore
+*/
+func Test_RefreshTokenDeletedUser(t *testing.T) {
+	_, refreshToken := loginTestUser(t)
+
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	reqRecorder := serve(router, http.MethodDelete, "/v1/delete", `{"username":"test-username"}`, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = postRefreshToken(t, refreshToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/token/refresh
+
+	-> Missing refresh token
+*/
+func Test_RefreshTokenBadRequest(t *testing.T) {
+	reqRecorder := postRefreshToken(t, "")
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/token/refresh
+
+	-> Unknown refresh token
+*/
+func Test_RefreshTokenInvalid(t *testing.T) {
+	reqRecorder := postRefreshToken(t, "not-a-refresh-token")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/logout
+
+	-> The access token is revoked on the server and can not be used anymore
+*/
+func Test_LogoutRevokesToken(t *testing.T) {
+	accessToken, _ := loginTestUser(t)
+
+	reqRecorder := requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodPost, "/v1/logout", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+Testing POST /v1/logout/all
+
+	-> Every session of the user is revoked, access tokens and refresh tokens
+*/
+func Test_LogoutEverywhere(t *testing.T) {
+	accessToken, _ := loginTestUser(t)
+	otherAccessToken, otherRefreshToken := loginTestUser(t)
+
+	reqRecorder := requestWithToken(t, http.MethodPost, "/v1/logout/all", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", otherAccessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = postRefreshToken(t, otherRefreshToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// a new login right after is not affected
+	accessToken, _ = loginTestUser(t)
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing DELETE /v1/users/:id/sessions/:sid
+
+	-> Admin kills a session of a user in the same organization
+	-> Unknown session
+*/
+func Test_KillUserSession(t *testing.T) {
+	accessToken, _ := loginTestUser(t)
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(accessToken, claims)
+
+	if err != nil {
+		t.Fatalf("Failed to parse access token: %s", err.Error())
+	}
+
+	adminToken, err := getJWTTestToken()
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	url := fmt.Sprintf("/v1/users/%s/sessions/%s", claims["userId"], claims["sid"])
+
+	reqRecorder := requestWithToken(t, http.MethodDelete, url, adminToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodGet, "/v1/users", accessToken)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = requestWithToken(t, http.MethodDelete, url, adminToken)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Function to login with the mock repository and return the access token and the refresh token from the cookies
+*/
+func loginTestUser(t *testing.T) (string, string) {
+	testPayloadBytes, err := json.Marshal(map[string]any{
+		"username": "username",
+		"password": "password",
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to marshal testPayload: %s", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/login", bytes.NewReader(testPayloadBytes))
+
+	if err != nil {
+		t.Fatalf("Failed to create post request: %s", err.Error())
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	reqRecorder := httptest.NewRecorder()
+
+	router.ServeHTTP(reqRecorder, req)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected 200 get %d", reqRecorder.Code)
+	}
+
+	accessToken := getCookie(reqRecorder, "Authorization")
+	refreshToken := getCookie(reqRecorder, refreshTokenCookie)
+
+	if accessToken == "" || refreshToken == "" {
+		t.Fatal("FAILED: Authorization or RefreshToken Cookie absent")
+	}
+
+	return accessToken, refreshToken
+}
+
+/*
+Function to send the refresh token in the request body to POST /v1/token/refresh
+*/
+func postRefreshToken(t *testing.T, refreshToken string) *httptest.ResponseRecorder {
+	testPayloadBytes, err := json.Marshal(map[string]any{
+		"refresh_token": refreshToken,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to marshal testPayload: %s", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/token/refresh", bytes.NewReader(testPayloadBytes))
+
+	if err != nil {
+		t.Fatalf("Failed to create post request: %s", err.Error())
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	reqRecorder := httptest.NewRecorder()
+
+	router.ServeHTTP(reqRecorder, req)
+
+	return reqRecorder
+}
+
+/*
+Function to get the value of a cookie set in the response
+*/
+func getCookie(reqRecorder *httptest.ResponseRecorder, name string) string {
+	for _, cookie := range reqRecorder.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+/*
+Function to send a request with the access token in the Authorization cookie
+*/
+func requestWithToken(t *testing.T, method, url, accessToken string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err.Error())
+	}
+
+	req.Header.Set("Cookie", fmt.Sprintf("Authorization=%s", accessToken))
+
+	reqRecorder := httptest.NewRecorder()
+
+	router.ServeHTTP(reqRecorder, req)
+
+	return reqRecorder
+}
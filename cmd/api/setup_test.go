@@ -2,8 +2,10 @@ package main
 
 import (
 	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,11 +20,36 @@ import (
 */
 
 var router *gin.Engine // package level variable used by `handlers_test.go`
+var testApp Config     // package level variable used to sign test JWT Tokens
+
+// the verified email of the mocked member, which the password reset and login links are sent to
+const testUserEmail = "test-username@example.com"
 
 func TestMain(m *testing.M) {
 
-	testApp := Config{
-		Repo: data.NewPostgresTestRepository(nil),
+	keys, err := NewKeyRing(data.NewMemoryKeyStore(), algHS256, NewHMACKey("default", []byte(JWT_SECRET)))
+
+	if err != nil {
+		log.Fatalf("Failed to create key ring: %s", err.Error())
+	}
+
+	testApp = Config{
+		Repo:           data.NewPostgresTestRepository(nil),
+		Revocations:    data.NewMemoryRevocationStore(),
+		Keys:           keys,
+		PlatformAdmins: []string{"test-username"},
+		Notifier:       NewMemoryNotifier(),
+		Lockout:        Lockout{Threshold: 5, Duration: time.Minute, MaxDuration: time.Hour},
+		MFAIssuer:      "Houseware Test",
+		WebAuthn:       WebAuthn{RPID: "localhost", RPName: "Houseware Test", Origin: "http://localhost:5000"},
+		Issuer:         "http://localhost:5000",
+		RateLimitStore: data.NewMemoryRateLimitStore(),
+		RateLimits: map[string]data.RateLimit{
+			// every test request comes from the same IP and mostly the same user
+			rateLimitLogin: {Requests: 10000, Period: time.Minute},
+			rateLimitAuth:  {Requests: 10000, Period: time.Minute},
+			rateLimitUsers: {Requests: 10000, Period: time.Minute},
+		},
 	}
 
 	router = testApp.routes()
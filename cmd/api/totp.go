@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+TOTP codes as of RFC 6238, with the parameters every authenticator app supports:
+HMAC-SHA1, 6 digits and a 30 seconds step.
+*/
+const (
+	totpDigits = 6
+	totpPeriod = 30
+
+	// codes of the previous and the next step are accepted too, for the clocks which are a bit off
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+/*
+newTOTPSecret returns a random 160 bits secret, base32 encoded as the authenticator apps expect it.
+*/
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+/*
+hotp returns the HOTP code (RFC 4226) of the key for the counter.
+*/
+func hotp(key []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%modulo)
+}
+
+/*
+totpStep returns the time step of the time.
+*/
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+/*
+verifyTOTP checks the code against the secret around the time, and returns the time step it matched.
+The caller still has to check that the step was not used already.
+*/
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := totpStep(now)
+
+	for i := -totpSkew; i <= totpSkew; i++ {
+		expected := hotp(key, uint64(step+int64(i)), totpDigits)
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + int64(i), true
+		}
+	}
+
+	return 0, false
+}
+
+/*
+totpURI returns the otpauth URI of the secret, which the authenticator apps read from the QR code.
+*/
+func totpURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+/*
+newRecoveryCodes returns the recovery codes to hand out to the user, and their hashes to store in the database.
+*/
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+
+		_, err := rand.Read(b)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+/*
+hashRecoveryCode returns the hash of the recovery code, ignoring the case, the dashes and the spaces users type.
+*/
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return hashToken(code)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+WebAuthn is the relying party the passkeys are registered with. RPID is the domain of the application, which the
+authenticators bind the passkeys to, and Origin the origin of the pages running the ceremonies.
+*/
+type WebAuthn struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// the types of the client data of the ceremonies
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// flags of the authenticator data
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// COSE algorithms of the supported passkeys
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+var errInvalidAssertion = errors.New("invalid passkey")
+
+/*
+clientData is the part of the client data JSON which is checked, the browser signs it along with the authenticator data.
+*/
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+/*
+verifyClientData checks the type and the origin of the client data, the caller still has to check the challenge.
+*/
+func (w WebAuthn) verifyClientData(raw []byte, ceremony string) (*clientData, error) {
+	var data clientData
+
+	err := json.Unmarshal(raw, &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case data.Type != ceremony:
+		return nil, fmt.Errorf("client data type is %q, not %q", data.Type, ceremony)
+	case data.Origin != w.Origin:
+		return nil, fmt.Errorf("origin %q is not allowed", data.Origin)
+	case data.Challenge == "":
+		return nil, errors.New("missing challenge in client data")
+	}
+
+	return &data, nil
+}
+
+/*
+authenticatorData is the data the authenticator signs. The attested credential, its id and COSE public key,
+is only there when registering.
+*/
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	if data.Flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	rest := raw[37:]
+
+	// AAGUID and the length of the credential id
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if idLength == 0 || len(rest) < idLength {
+		return nil, errors.New("invalid credential id")
+	}
+
+	data.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, extensions, err := decodeCBOR(rest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data.PublicKey = rest[:len(rest)-len(extensions)]
+	return data, nil
+}
+
+/*
+verifyAuthenticatorData checks that the passkey is bound to our relying party and that the user was present.
+A passkey used as the only factor must also have verified the user, by a PIN or a biometric.
+*/
+func (w WebAuthn) verifyAuthenticatorData(data *authenticatorData, requireUserVerified bool) error {
+	rpIDHash := sha256.Sum256([]byte(w.RPID))
+
+	switch {
+	case !bytes.Equal(data.RPIDHash, rpIDHash[:]):
+		return errors.New("passkey is bound to another relying party")
+	case data.Flags&flagUserPresent == 0:
+		return errors.New("user not present")
+	case requireUserVerified && data.Flags&flagUserVerified == 0:
+		return errors.New("user not verified")
+	}
+
+	return nil
+}
+
+/*
+parseAttestationObject returns the authenticator data of the attestation object of a registration.
+Only the "none" attestation is asked for, so the attestation statement, if any, is not verified
+and the public key is trusted as the one of the user.
+*/
+func parseAttestationObject(raw []byte) (*authenticatorData, error) {
+	value, _, err := decodeCBOR(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := value.(map[any]any)
+
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+
+	rawAuthData, ok := object["authData"].([]byte)
+
+	if !ok {
+		return nil, errors.New("missing authData in attestation object")
+	}
+
+	data, err := parseAuthenticatorData(rawAuthData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Flags&flagAttested == 0 {
+		return nil, errors.New("missing attested credential")
+	}
+
+	return data, nil
+}
+
+/*
+parseCOSEKey returns the public key of a COSE key (RFC 9053), for the ES256, EdDSA and RS256 algorithms.
+*/
+func parseCOSEKey(raw []byte) (crypto.PublicKey, error) {
+	value, _, err := decodeCBOR(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := value.(map[any]any)
+
+	if !ok {
+		return nil, errors.New("cose key is not a map")
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == coseES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+
+		// ecdh checks that the point is on the curve
+		_, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case kty == 1 && alg == coseEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+
+	case kty == 3 && alg == coseRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		if publicKey.N.BitLen() < 2048 || publicKey.E < 3 {
+			return nil, errors.New("RSA key too weak")
+		}
+
+		return publicKey, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported cose key type %d with algorithm %d", kty, alg)
+	}
+}
+
+/*
+verifyAssertionSignature checks the signature of an assertion, made over the authenticator data
+and the hash of the client data, with the COSE public key of the passkey.
+*/
+func verifyAssertionSignature(coseKey, authData, clientDataJSON, signature []byte) error {
+	publicKey, err := parseCOSEKey(coseKey)
+
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errInvalidAssertion
+		}
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, signature) {
+			return errInvalidAssertion
+		}
+
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errInvalidAssertion
+		}
+
+	default:
+		return errInvalidAssertion
+	}
+
+	return nil
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"houseware---backend-engineering-octernship-KunalSin9h/data"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/*
+Testing the signatures of the assertions, with a software authenticator for every supported algorithm
+
+	-> A signature over other data, or with another key, is refused
+	-> A P-256 key which is not on the curve is refused
+*/
+func Test_VerifyAssertionSignature(t *testing.T) {
+	for _, alg := range []int{coseES256, coseEdDSA, coseRS256} {
+		authenticator := newTestAuthenticator(t, alg)
+		other := newTestAuthenticator(t, alg)
+
+		if _, err := parseCOSEKey(authenticator.coseKey); err != nil {
+			t.Fatalf("FAILED: Algorithm %d Expected a valid COSE key get %s", alg, err.Error())
+		}
+
+		authData := authenticator.authenticatorData(flagUserPresent, false)
+		clientDataJSON := testClientData(ceremonyGet, "challenge", testApp.WebAuthn.Origin)
+		signature := authenticator.sign(t, authData, clientDataJSON)
+
+		if err := verifyAssertionSignature(authenticator.coseKey, authData, clientDataJSON, signature); err != nil {
+			t.Errorf("FAILED: Algorithm %d Expected a valid signature get %s", alg, err.Error())
+		}
+
+		otherClientData := testClientData(ceremonyGet, "other-challenge", testApp.WebAuthn.Origin)
+
+		if verifyAssertionSignature(authenticator.coseKey, authData, otherClientData, signature) == nil {
+			t.Errorf("FAILED: Algorithm %d Expected the signature of other client data to be refused", alg)
+		}
+
+		if verifyAssertionSignature(other.coseKey, authData, clientDataJSON, signature) == nil {
+			t.Errorf("FAILED: Algorithm %d Expected the signature with another key to be refused", alg)
+		}
+	}
+
+	offCurve := encodeTestCBOR(map[any]any{1: 2, 3: coseES256, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)})
+
+	if _, err := parseCOSEKey(offCurve); err == nil {
+		t.Errorf("FAILED: Expected a point not on the curve to be refused")
+	}
+}
+
+/*
+Fuzzing the parsing of the authenticator data, run with `go test -fuzz=FuzzParseAuthenticatorData ./cmd/api`
+
+	-> Any input is parsed or refused without a panic
+	-> The attested credential has an id, and the id and the public key are taken from the data
+*/
+func FuzzParseAuthenticatorData(f *testing.F) {
+	for _, alg := range []int{coseES256, coseEdDSA, coseRS256} {
+		authenticator := newTestAuthenticator(f, alg)
+		f.Add(authenticator.authenticatorData(flagUserPresent|flagUserVerified, true))
+		f.Add(authenticator.authenticatorData(flagUserPresent, false))
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		authData, err := parseAuthenticatorData(raw)
+
+		if err != nil {
+			return
+		}
+
+		if authData.Flags&flagAttested == 0 {
+			return
+		}
+
+		if len(authData.CredentialID) == 0 || len(authData.PublicKey) == 0 {
+			t.Fatalf("FAILED: Expected an attested credential id and public key for %x", raw)
+		}
+
+		if !bytes.Contains(raw, append(append([]byte{}, authData.CredentialID...), authData.PublicKey...)) {
+			t.Errorf("FAILED: Expected the credential id and the public key to come from %x", raw)
+		}
+	})
+}
+
+/*
+Fuzzing the parsing of the COSE keys, run with `go test -fuzz=FuzzParseCOSEKey ./cmd/api`
+
+	-> Any input is parsed or refused without a panic
+	-> Only the keys of the supported algorithms are returned
+*/
+func FuzzParseCOSEKey(f *testing.F) {
+	for _, alg := range []int{coseES256, coseEdDSA, coseRS256} {
+		f.Add(newTestAuthenticator(f, alg).coseKey)
+	}
+
+	f.Add(encodeTestCBOR(map[any]any{1: 2, 3: coseES256, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)}))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		publicKey, err := parseCOSEKey(raw)
+
+		if err != nil {
+			return
+		}
+
+		switch publicKey.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		default:
+			t.Errorf("FAILED: Unexpected public key %T for %x", publicKey, raw)
+		}
+	})
+}
+
+/*
+Testing the registration of a passkey and the passwordless login with it
+
+	-> The client data must come from our origin, and a challenge can only be answered once
+	-> A passkey can only be registered once
+	-> The login requires the user to be verified by the authenticator
+	-> The signature counter must increase, or the passkey may have been cloned
+*/
+func Test_PasskeyRegistrationAndLogin(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "passkey-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	authenticator := newTestAuthenticator(t, coseES256)
+	credential := registerTestPasskey(t, token, authenticator)
+	defer testApp.Repo.DeleteWebAuthnCredential(data.WebAuthnCredential{CredentialID: credential})
+
+	// the same passkey again
+	challenge := beginTestCeremony(t, "/v1/webauthn/register/begin", testReauthentication, token)
+	reqRecorder := serve(router, http.MethodPost, "/v1/webauthn/register/finish", authenticator.attestation(challenge, testApp.WebAuthn.Origin), token)
+
+	if reqRecorder.Code != http.StatusConflict {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusConflict, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodGet, "/v1/webauthn/credentials", "", token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if credentials, _ := responseData(t, reqRecorder)["credentials"].([]any); len(credentials) != 1 {
+		t.Errorf("FAILED: Expected 1 passkey get %d", len(credentials))
+	}
+
+	// passwordless login
+	authenticator.signCount = 1
+	challenge = beginTestCeremony(t, "/v1/webauthn/login/begin", "", "")
+	assertion := authenticator.assertion(t, challenge, flagUserPresent|flagUserVerified, "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/login/finish", assertion, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if getCookie(reqRecorder, "Authorization") == "" {
+		t.Errorf("FAILED: Authorization Cookie absent")
+	}
+
+	// the same assertion again
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/login/finish", assertion, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// the user was only present, not verified
+	authenticator.signCount = 2
+	challenge = beginTestCeremony(t, "/v1/webauthn/login/begin", "", "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/login/finish", authenticator.assertion(t, challenge, flagUserPresent, ""), "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	// a cloned passkey, with the counter of the last login
+	authenticator.signCount = 1
+	challenge = beginTestCeremony(t, "/v1/webauthn/login/begin", "", "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/login/finish", authenticator.assertion(t, challenge, flagUserPresent|flagUserVerified, ""), "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	authenticator.signCount = 2
+	challenge = beginTestCeremony(t, "/v1/webauthn/login/begin", "", "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/login/finish", authenticator.assertion(t, challenge, flagUserPresent|flagUserVerified, ""), "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the registration with a wrong origin or challenge
+
+	-> The challenge of a registration can not be used to log in
+	-> A challenge refused for its origin can still be answered
+	-> Registering and deleting a passkey take the current password
+*/
+func Test_PasskeyRegistrationBadRequest(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "passkey-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	authenticator := newTestAuthenticator(t, coseEdDSA)
+	challenge := beginTestCeremony(t, "/v1/webauthn/register/begin", testReauthentication, token)
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/webauthn/register/finish", authenticator.attestation(challenge, "https://evil.example"), token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/finish", authenticator.attestation("unknown-challenge", testApp.WebAuthn.Origin), token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	loginChallenge := beginTestCeremony(t, "/v1/webauthn/login/begin", "", "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/finish", authenticator.attestation(loginChallenge, testApp.WebAuthn.Origin), token)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	// a session alone can not register a passkey, which would log in without the password
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/begin", "", token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	withoutPassword := strings.Replace(authenticator.attestation(challenge, testApp.WebAuthn.Origin), `"current_password":"password",`, "", 1)
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/finish", withoutPassword, token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/finish", authenticator.attestation(challenge, testApp.WebAuthn.Origin), token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	id, _ := responseData(t, reqRecorder)["credential"].(map[string]any)["id"].(string)
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/webauthn/credentials/"+id, "", token)
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+	reqRecorder = serve(router, http.MethodDelete, "/v1/webauthn/credentials/"+id, testReauthentication, token)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	reqRecorder = serve(router, http.MethodDelete, "/v1/webauthn/credentials/"+id, testReauthentication, token)
+
+	if reqRecorder.Code != http.StatusNotFound {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusNotFound, reqRecorder.Code)
+	}
+}
+
+/*
+Testing the passkey as the second factor after the password
+
+	-> The login tells which second factors the user has
+	-> The passkey must be one of the user, and the MFA token can only be used once
+*/
+func Test_PasskeyMFA(t *testing.T) {
+	token, err := signJWTTestTokenFor(testApp.Keys, "passkey-user-id")
+
+	if err != nil {
+		t.Fatalf("Failed to generate test JWT Token: %s", err.Error())
+	}
+
+	authenticator := newTestAuthenticator(t, coseRS256)
+	credential := registerTestPasskey(t, token, authenticator)
+	defer testApp.Repo.DeleteWebAuthnCredential(data.WebAuthnCredential{CredentialID: credential})
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/login", `{"username":"passkey-user","password":"password"}`, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	login := responseData(t, reqRecorder)
+	mfaToken, _ := login["mfa_token"].(string)
+
+	if methods, _ := login["mfa_methods"].([]any); len(methods) != 1 || methods[0] != "webauthn" {
+		t.Errorf("FAILED: Expected the webauthn MFA method get %v", login["mfa_methods"])
+	}
+
+	if getCookie(reqRecorder, "Authorization") != "" {
+		t.Errorf("FAILED: Expected no Authorization Cookie before the passkey")
+	}
+
+	mfaBody := fmt.Sprintf(`{"mfa_token":%q}`, mfaToken)
+
+	// a passkey of another user, unknown to this one
+	stranger := newTestAuthenticator(t, coseES256)
+	challenge := beginTestCeremony(t, "/v1/webauthn/mfa/begin", mfaBody, "")
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/mfa/finish", stranger.assertion(t, challenge, flagUserPresent, mfaToken), "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+
+	authenticator.signCount = 1
+	challenge = beginTestCeremony(t, "/v1/webauthn/mfa/begin", mfaBody, "")
+	assertion := authenticator.assertion(t, challenge, flagUserPresent, mfaToken)
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/mfa/finish", assertion, "")
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	if getCookie(reqRecorder, "Authorization") == "" {
+		t.Errorf("FAILED: Authorization Cookie absent")
+	}
+
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/mfa/begin", mfaBody, "")
+
+	if reqRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusUnauthorized, reqRecorder.Code)
+	}
+}
+
+/*
+testAuthenticator is a software authenticator, holding a single passkey bound to the test relying party.
+*/
+type testAuthenticator struct {
+	signer       crypto.Signer
+	coseKey      []byte
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+/*
+Function to create a software authenticator with a new key of the COSE algorithm, for the mocked passkey user
+*/
+func newTestAuthenticator(t testing.TB, alg int) *testAuthenticator {
+	authenticator := &testAuthenticator{
+		credentialID: make([]byte, 16),
+		userHandle:   []byte("passkey-user-id"),
+	}
+
+	_, err := rand.Read(authenticator.credentialID)
+
+	if err != nil {
+		t.Fatalf("Failed to generate credential id: %s", err.Error())
+	}
+
+	switch alg {
+	case coseES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err.Error())
+		}
+
+		authenticator.signer = key
+		authenticator.coseKey = encodeTestCBOR(map[any]any{
+			1: 2, 3: coseES256, -1: 1, -2: key.X.FillBytes(make([]byte, 32)), -3: key.Y.FillBytes(make([]byte, 32)),
+		})
+
+	case coseEdDSA:
+		publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err.Error())
+		}
+
+		authenticator.signer = key
+		authenticator.coseKey = encodeTestCBOR(map[any]any{1: 1, 3: coseEdDSA, -1: 6, -2: []byte(publicKey)})
+
+	case coseRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err.Error())
+		}
+
+		authenticator.signer = key
+		authenticator.coseKey = encodeTestCBOR(map[any]any{
+			1: 3, 3: coseRS256, -1: key.N.Bytes(), -2: big.NewInt(int64(key.E)).Bytes(),
+		})
+	}
+
+	return authenticator
+}
+
+/*
+Function to get the authenticator data for the test relying party, with the attested passkey when registering
+*/
+func (a *testAuthenticator) authenticatorData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(testApp.WebAuthn.RPID))
+	authData := append(rpIDHash[:], flags)
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+
+	if attested {
+		authData[32] |= flagAttested
+		authData = append(authData, make([]byte, 16)...) // AAGUID
+		authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+		authData = append(authData, a.credentialID...)
+		authData = append(authData, a.coseKey...)
+	}
+
+	return authData
+}
+
+/*
+Function to sign the authenticator data and the hash of the client data, as the authenticator does
+*/
+func (a *testAuthenticator) sign(t *testing.T, authData, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	var signature []byte
+	var err error
+
+	switch key := a.signer.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, signed)
+	default:
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to sign: %s", err.Error())
+	}
+
+	return signature
+}
+
+/*
+Function to get the body finishing a registration, the answer of navigator.credentials.create()
+*/
+func (a *testAuthenticator) attestation(challenge, origin string) string {
+	attestationObject := encodeTestCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authenticatorData(flagUserPresent|flagUserVerified, true),
+	})
+
+	body, _ := json.Marshal(map[string]string{
+		"current_password":   "password",
+		"name":               "Test Passkey",
+		"client_data_json":   webAuthnEncoding.EncodeToString(testClientData(ceremonyCreate, challenge, origin)),
+		"attestation_object": webAuthnEncoding.EncodeToString(attestationObject),
+	})
+
+	return string(body)
+}
+
+/*
+Function to get the body finishing a login, the answer of navigator.credentials.get(), along with the MFA token if any
+*/
+func (a *testAuthenticator) assertion(t *testing.T, challenge string, flags byte, mfaToken string) string {
+	authData := a.authenticatorData(flags, false)
+	clientDataJSON := testClientData(ceremonyGet, challenge, testApp.WebAuthn.Origin)
+
+	payload := map[string]string{
+		"credential_id":      webAuthnEncoding.EncodeToString(a.credentialID),
+		"client_data_json":   webAuthnEncoding.EncodeToString(clientDataJSON),
+		"authenticator_data": webAuthnEncoding.EncodeToString(authData),
+		"signature":          webAuthnEncoding.EncodeToString(a.sign(t, authData, clientDataJSON)),
+		"user_handle":        webAuthnEncoding.EncodeToString(a.userHandle),
+	}
+
+	if mfaToken != "" {
+		payload["mfa_token"] = mfaToken
+	}
+
+	body, _ := json.Marshal(payload)
+	return string(body)
+}
+
+/*
+Function to register the passkey of the authenticator for the user of the access token, and return its credential id
+*/
+func registerTestPasskey(t *testing.T, accessToken string, authenticator *testAuthenticator) string {
+	challenge := beginTestCeremony(t, "/v1/webauthn/register/begin", testReauthentication, accessToken)
+	body := authenticator.attestation(challenge, testApp.WebAuthn.Origin)
+
+	reqRecorder := serve(router, http.MethodPost, "/v1/webauthn/register/finish", body, accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	// the challenge can only be answered once
+	reqRecorder = serve(router, http.MethodPost, "/v1/webauthn/register/finish", body, accessToken)
+
+	if reqRecorder.Code != http.StatusBadRequest {
+		t.Errorf("FAILED: Expected %d get %d", http.StatusBadRequest, reqRecorder.Code)
+	}
+
+	return webAuthnEncoding.EncodeToString(authenticator.credentialID)
+}
+
+// the body proving the password again, which the changes to the passkeys take
+const testReauthentication = `{"current_password":"password"}`
+
+/*
+Function to begin a ceremony and return its challenge
+*/
+func beginTestCeremony(t *testing.T, url, body, accessToken string) string {
+	reqRecorder := serve(router, http.MethodPost, url, body, accessToken)
+
+	if reqRecorder.Code != http.StatusOK {
+		t.Fatalf("FAILED: Expected %d get %d", http.StatusOK, reqRecorder.Code)
+	}
+
+	options, _ := responseData(t, reqRecorder)["public_key"].(map[string]any)
+	challenge, _ := options["challenge"].(string)
+
+	if challenge == "" {
+		t.Fatal("FAILED: Challenge absent")
+	}
+
+	return challenge
+}
+
+/*
+Function to get the client data JSON of a ceremony, as the browser makes it
+*/
+func testClientData(ceremony, challenge, origin string) []byte {
+	clientDataJSON, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+
+	return clientDataJSON
+}
+
+/*
+Function to encode CBOR, for the integers, byte and text strings and maps the authenticators use
+*/
+func encodeTestCBOR(value any) []byte {
+	head := func(major byte, argument uint64) []byte {
+		switch {
+		case argument < 24:
+			return []byte{major<<5 | byte(argument)}
+		case argument < 1<<8:
+			return []byte{major<<5 | 24, byte(argument)}
+		case argument < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		// sorted so that the encoding is the same every time
+		keys := make([][]byte, 0, len(v))
+		encoded := map[string][]byte{}
+
+		for key, item := range v {
+			encodedKey := encodeTestCBOR(key)
+			keys = append(keys, encodedKey)
+			encoded[string(encodedKey)] = encodeTestCBOR(item)
+		}
+
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+		result := head(5, uint64(len(v)))
+
+		for _, key := range keys {
+			result = append(append(result, key...), encoded[string(key)]...)
+		}
+
+		return result
+	default:
+		panic(fmt.Sprintf("encodeTestCBOR: unsupported type %T", value))
+	}
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GrantDeviceCode is the grant type of the device authorization flow (RFC 8628)
+const GrantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceSlowDownStep is added to the polling interval of a device which polls too often, as RFC 8628 asks
+const deviceSlowDownStep = 5
+
+var (
+	ErrInvalidDeviceCode          = errors.New("invalid or already used device code")
+	ErrDeviceCodeExpired          = errors.New("device code expired")
+	ErrDeviceAuthorizationPending = errors.New("authorization pending")
+	ErrDeviceAuthorizationDenied  = errors.New("authorization denied")
+	ErrDeviceSlowDown             = errors.New("polling too often")
+	ErrInvalidUserCode            = errors.New("invalid or expired user code")
+	ErrDeviceAuthorizationDecided = errors.New("authorization already decided")
+)
+
+/*
+DeviceAuthorization is the authorization a device without a browser, like a CLI, asks for with the device flow.
+The device polls with the device code while a logged in user approves or denies the user code on another device.
+Only the SHA-256 hashes of both codes are stored. Its id is the family of the refresh tokens the device gets,
+and Interval is the number of seconds the device must wait between two polls.
+*/
+type DeviceAuthorization struct {
+	GormModel
+	ClientID       string     `json:"client_id" gorm:"not null;index"`
+	Scope          string     `json:"scope" gorm:"not null;default:''"`
+	DeviceCodeHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	UserCodeHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	Interval       int        `json:"interval" gorm:"not null"`
+	ExpiresAt      time.Time  `json:"expires_at" gorm:"not null"`
+	LastPolledAt   *time.Time `json:"last_polled_at"`
+	UserID         string     `json:"user_id" gorm:"not null;default:'';index"`
+	ApprovedAt     *time.Time `json:"approved_at"`
+	DeniedAt       *time.Time `json:"denied_at"`
+	UsedAt         *time.Time `json:"used_at"`
+}
+
+func (DeviceAuthorization) TableName() string {
+	return "oauth_device_authorizations"
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the DeviceAuthorization struct
+func (authorization *DeviceAuthorization) BeforeCreate(tx *gorm.DB) (err error) {
+	authorization.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertDeviceAuthorization is a method that inserts a DeviceAuthorization struct into the database and returns an error.
+*/
+func (u *PostgresRepository) InsertDeviceAuthorization(authorization DeviceAuthorization) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Create(&authorization).Error
+}
+
+/*
+GetDeviceAuthorization is a method that returns the unexpired and undecided device authorization of the user code hash.
+It returns ErrInvalidUserCode if there is none.
+*/
+func (u *PostgresRepository) GetDeviceAuthorization(userCodeHash string) (*DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var authorization DeviceAuthorization
+
+	err := db.WithContext(ctx).Find(&authorization,
+		"user_code_hash = ? AND expires_at > ? AND approved_at IS NULL AND denied_at IS NULL", userCodeHash, time.Now()).Error
+
+	if err != nil {
+		return &DeviceAuthorization{}, err
+	}
+
+	if authorization.ID == "" {
+		return &DeviceAuthorization{}, ErrInvalidUserCode
+	}
+
+	return &authorization, nil
+}
+
+/*
+DecideDeviceAuthorization is a method that records the answer of the user to the device authorization,
+along with the scope the user approved. A single update does the check and the write, so that an authorization
+is only decided once. It returns ErrDeviceAuthorizationDecided if it was already decided or is expired.
+*/
+func (u *PostgresRepository) DecideDeviceAuthorization(authorization DeviceAuthorization, user User, scope string, approved bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	now := time.Now()
+	updates := map[string]any{"user_id": user.ID, "denied_at": now}
+
+	if approved {
+		updates = map[string]any{"user_id": user.ID, "scope": scope, "approved_at": now}
+	}
+
+	result := db.WithContext(ctx).Model(&DeviceAuthorization{}).
+		Where("id = ? AND expires_at > ? AND approved_at IS NULL AND denied_at IS NULL", authorization.ID, now).
+		Updates(updates)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrDeviceAuthorizationDecided
+	}
+
+	return nil
+}
+
+/*
+PollDeviceAuthorization is a method that records a poll of the device of the client with the device code hash.
+A device polling before its interval is over is slowed down by adding 5 seconds to its interval.
+Once approved, the first poll in time marks the authorization as used and returns it, the device gets the tokens.
+The row is locked during the poll, so concurrent polls can not both get the tokens.
+Otherwise it returns the error telling the device what to do: ErrDeviceAuthorizationPending, ErrDeviceSlowDown,
+ErrDeviceAuthorizationDenied, ErrDeviceCodeExpired or ErrInvalidDeviceCode.
+*/
+func (u *PostgresRepository) PollDeviceAuthorization(deviceCodeHash, clientID string) (*DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var authorization DeviceAuthorization
+	var pollErr error
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Find(&authorization, "device_code_hash = ? AND client_id = ?", deviceCodeHash, clientID).Error
+
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]any{"last_polled_at": now}
+		pollErr = devicePollError(authorization, now)
+
+		switch pollErr {
+		case ErrInvalidDeviceCode, ErrDeviceCodeExpired:
+			return nil
+		case ErrDeviceSlowDown:
+			updates["interval"] = authorization.Interval + deviceSlowDownStep
+		case nil:
+			updates["used_at"] = now
+		}
+
+		return tx.Model(&authorization).Updates(updates).Error
+	})
+
+	if err != nil {
+		return &DeviceAuthorization{}, err
+	}
+
+	if pollErr != nil {
+		return &DeviceAuthorization{}, pollErr
+	}
+
+	return &authorization, nil
+}
+
+/*
+devicePollError returns the error of a poll of the device authorization at the time, or nil if the device gets the tokens.
+*/
+func devicePollError(authorization DeviceAuthorization, now time.Time) error {
+	switch {
+	case authorization.ID == "" || authorization.UsedAt != nil:
+		return ErrInvalidDeviceCode
+	case !authorization.ExpiresAt.After(now):
+		return ErrDeviceCodeExpired
+	case authorization.DeniedAt != nil:
+		return ErrDeviceAuthorizationDenied
+	case authorization.LastPolledAt != nil &&
+		now.Sub(*authorization.LastPolledAt) < time.Duration(authorization.Interval)*time.Second:
+		return ErrDeviceSlowDown
+	case authorization.ApprovedAt == nil:
+		return ErrDeviceAuthorizationPending
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EncryptedKeyPrefix marks the private keys encrypted by the EncryptedKeyStore, the others are stored as they are
+const EncryptedKeyPrefix = "enc:v1:"
+
+var ErrKeyEncryptionKey = errors.New("signing keys are encrypted, the key encryption key is missing or wrong")
+
+/*
+EncryptedKeyStore is a KeyStore which encrypts the private keys with AES-256-GCM before they reach the store it wraps,
+so that reading the keys table is not enough to sign tokens. The id of a key is authenticated along with it,
+so that the encrypted private keys can not be swapped between the keys.
+The keys stored before the encryption was turned on are still read, they are replaced by encrypted keys as they rotate.
+*/
+type EncryptedKeyStore struct {
+	store KeyStore
+	aead  cipher.AEAD
+}
+
+/*
+NewEncryptedKeyStore wraps the store, the key encryption key must be 32 bytes long.
+*/
+func NewEncryptedKeyStore(store KeyStore, key []byte) (*EncryptedKeyStore, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key encryption key must be 32 bytes long, not %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedKeyStore{store: store, aead: aead}, nil
+}
+
+func (s *EncryptedKeyStore) GetKeys() ([]JWTKey, error) {
+	keys, err := s.store.GetKeys()
+
+	if err != nil {
+		return []JWTKey{}, err
+	}
+
+	for i := range keys {
+		keys[i].PrivateKey, err = s.decrypt(keys[i])
+
+		if err != nil {
+			return []JWTKey{}, fmt.Errorf("signing key %s: %w", keys[i].ID, err)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *EncryptedKeyStore) InitKeys(current JWTKey, next JWTKey) error {
+	var err error
+
+	current.PrivateKey, err = s.encrypt(current)
+
+	if err != nil {
+		return err
+	}
+
+	next.PrivateKey, err = s.encrypt(next)
+
+	if err != nil {
+		return err
+	}
+
+	return s.store.InitKeys(current, next)
+}
+
+func (s *EncryptedKeyStore) RotateKeys(next JWTKey, dueBefore time.Time) (bool, error) {
+	var err error
+
+	next.PrivateKey, err = s.encrypt(next)
+
+	if err != nil {
+		return false, err
+	}
+
+	return s.store.RotateKeys(next, dueBefore)
+}
+
+func (s *EncryptedKeyStore) RetireKey(id string) error {
+	return s.store.RetireKey(id)
+}
+
+func (s *EncryptedKeyStore) RetireKeysRotatedBefore(rotatedBefore time.Time) error {
+	return s.store.RetireKeysRotatedBefore(rotatedBefore)
+}
+
+/*
+encrypt returns the private key of the key encrypted, as the prefix followed by the base64 encoded nonce and ciphertext.
+*/
+func (s *EncryptedKeyStore) encrypt(key JWTKey) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+
+	if err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(key.PrivateKey), []byte(key.ID))
+
+	return EncryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/*
+decrypt returns the private key of the key in clear, the keys which were not encrypted are returned as they are.
+*/
+func (s *EncryptedKeyStore) decrypt(key JWTKey) (string, error) {
+	encoded, ok := strings.CutPrefix(key.PrivateKey, EncryptedKeyPrefix)
+
+	if !ok {
+		return key.PrivateKey, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", ErrKeyEncryptionKey
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	privateKey, err := s.aead.Open(nil, nonce, ciphertext, []byte(key.ID))
+
+	if err != nil {
+		return "", ErrKeyEncryptionKey
+	}
+
+	return string(privateKey), nil
+}
@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidInvitation   = errors.New("invalid or expired invitation")
+	ErrInvitationNotActive = errors.New("invitation was already accepted or revoked")
+	ErrInvitationRole      = errors.New("role of the invitation no longer exists")
+)
+
+/*
+Invitation invites a new user to join an organization with a role. The user is only created when the invitee
+accepts it and sets their own password. Only the SHA-256 hash of the token sent to the invitee is stored,
+and a resend replaces it, so that only the last link works.
+*/
+type Invitation struct {
+	GormModel
+	OrganizationID string     `json:"organization_id" gorm:"not null;index"`
+	Username       string     `json:"username" gorm:"not null"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role" gorm:"not null"`
+	InvitedBy      string     `json:"invited_by" gorm:"not null"`
+	TokenHash      string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt      time.Time  `json:"expires_at" gorm:"not null"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the Invitation struct
+func (invitation *Invitation) BeforeCreate(tx *gorm.DB) (err error) {
+	invitation.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertInvitation is a method that inserts an Invitation struct into the database and returns it with its id.
+*/
+func (u *PostgresRepository) InsertInvitation(invitation Invitation) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	err := db.WithContext(ctx).Create(&invitation).Error
+
+	if err != nil {
+		return &Invitation{}, err
+	}
+
+	return &invitation, nil
+}
+
+/*
+GetInvitation is a method that returns the invitation with the id, or an empty one if there is none.
+*/
+func (u *PostgresRepository) GetInvitation(id string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var invitation Invitation
+
+	err := db.WithContext(ctx).Find(&invitation, "id = ?", id).Error
+
+	if err != nil {
+		return &Invitation{}, err
+	}
+
+	return &invitation, nil
+}
+
+/*
+GetPendingInvitations is a method that returns the invitations of the organization which were neither
+accepted nor revoked, newest first. The expired ones are included, so that they can be resent.
+*/
+func (u *PostgresRepository) GetPendingInvitations(orgID string) ([]Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var invitations []Invitation
+
+	err := db.WithContext(ctx).Order("created_at DESC").
+		Find(&invitations, "organization_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", orgID).Error
+
+	if err != nil {
+		return []Invitation{}, err
+	}
+
+	return invitations, nil
+}
+
+/*
+RenewInvitation is a method that replaces the token of a pending invitation and extends it, for a resend.
+It returns ErrInvitationNotActive if the invitation was accepted or revoked meanwhile.
+*/
+func (u *PostgresRepository) RenewInvitation(invitation Invitation, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+		Updates(map[string]any{
+			"token_hash": tokenHash,
+			"expires_at": expiresAt,
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotActive
+	}
+
+	return nil
+}
+
+/*
+RevokeInvitation is a method that revokes a pending invitation, its link can not be used anymore.
+It returns ErrInvitationNotActive if the invitation was accepted or revoked meanwhile.
+*/
+func (u *PostgresRepository) RevokeInvitation(invitation Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotActive
+	}
+
+	return nil
+}
+
+/*
+GetInvitationByToken is a method that returns the pending and unexpired invitation with the token hash.
+It returns ErrInvalidInvitation if there is none.
+*/
+func (u *PostgresRepository) GetInvitationByToken(tokenHash string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var invitation Invitation
+
+	err := db.WithContext(ctx).Find(&invitation, "token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?",
+		tokenHash, time.Now()).Error
+
+	if err != nil {
+		return &Invitation{}, err
+	}
+
+	if invitation.ID == "" {
+		return &Invitation{}, ErrInvalidInvitation
+	}
+
+	return &invitation, nil
+}
+
+/*
+AcceptInvitation is a method that accepts the invitation with the token hash and creates its user with the password.
+The invitation is locked while the user is created, so that it can only be accepted once.
+The email of the invitation, if any, is the verified email of the user, as the link was sent to it.
+It returns ErrInvalidInvitation if no pending and unexpired invitation matches, ErrDuplicateUsername
+or ErrDuplicateEmail if the username or the email was taken since the invitation, and ErrInvitationRole
+if its custom role was deleted since.
+*/
+func (u *PostgresRepository) AcceptInvitation(tokenHash, password string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	hashPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+
+	if err != nil {
+		return &User{}, err
+	}
+
+	var user User
+
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invitation Invitation
+		now := time.Now()
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Find(&invitation, "token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", tokenHash, now).Error
+
+		if err != nil {
+			return err
+		}
+
+		if invitation.ID == "" {
+			return ErrInvalidInvitation
+		}
+
+		// the custom role may have been deleted since the invitation, it is locked so that it can not be until the user has it
+		if _, ok := BuiltInRoles[invitation.Role]; !ok {
+			var role Role
+
+			err = tx.Clauses(clause.Locking{Strength: "SHARE"}).
+				Find(&role, "organization_id = ? AND name = ?", invitation.OrganizationID, invitation.Role).Error
+
+			if err != nil {
+				return err
+			}
+
+			if role.ID == "" {
+				return ErrInvitationRole
+			}
+		}
+
+		user = User{
+			Username:       invitation.Username,
+			Password:       string(hashPassword),
+			Role:           invitation.Role,
+			OrganizationID: invitation.OrganizationID,
+		}
+
+		// the link was sent to the email, so accepting it verifies the email
+		if invitation.Email != "" {
+			user.Email = &invitation.Email
+			user.EmailVerifiedAt = &now
+		}
+
+		err = tx.Create(&user).Error
+
+		if err != nil {
+			return uniqueViolation(err)
+		}
+
+		return tx.Model(&invitation).Update("accepted_at", now).Error
+	})
+
+	if err != nil {
+		return &User{}, err
+	}
+
+	return &user, nil
+}
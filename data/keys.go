@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Status of a JWTKey, a key goes through them in this order
+const (
+	KeyStatusNext     = "next"     // published, will sign tokens after the next rotation
+	KeyStatusCurrent  = "current"  // signs new tokens
+	KeyStatusPrevious = "previous" // only verifies tokens signed before the rotation
+	KeyStatusRetired  = "retired"  // not accepted anymore, private key is deleted
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	ErrKeyInUse    = errors.New("only previous signing keys can be retired")
+)
+
+/*
+KeyStore keeps the ring of keys used to sign and verify the JWT tokens, so that every replica agrees on them.
+
+	PostgresKeyStore ----
+	                     \__ KeyStore
+	                     /
+	MemoryKeyStore ------
+
+There is always one current key signing the new tokens and one next key, which is already published so that
+other services have it in their cache before it starts signing. Rotating makes the next key current and keeps
+the old current key as a previous key, which still verifies the tokens it signed until it is retired.
+*/
+type KeyStore interface {
+	GetKeys() ([]JWTKey, error)
+	InitKeys(current JWTKey, next JWTKey) error
+	RotateKeys(next JWTKey, dueBefore time.Time) (bool, error)
+	RetireKey(id string) error
+	RetireKeysRotatedBefore(rotatedBefore time.Time) error
+}
+
+/*
+JWTKey is a signing key as stored in the database.
+PrivateKey is the PKCS #8 PEM encoded private key, or the base64 encoded secret for HS256.
+*/
+type JWTKey struct {
+	ID          string     `json:"kid" gorm:"primaryKey"`
+	Algorithm   string     `json:"alg" gorm:"not null"`
+	PrivateKey  string     `json:"-"`
+	Status      string     `json:"status" gorm:"not null;index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	RotatedAt   *time.Time `json:"rotated_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+}
+
+type PostgresKeyStore struct {
+	Conn *gorm.DB
+}
+
+func NewPostgresKeyStore(pool *gorm.DB) *PostgresKeyStore {
+	pool.AutoMigrate(&JWTKey{})
+
+	return &PostgresKeyStore{
+		Conn: pool,
+	}
+}
+
+/*
+lockKeys runs fn in a transaction holding a lock on the keys table,
+so that replicas initializing or rotating the keys at the same time do not both do it.
+*/
+func (s *PostgresKeyStore) lockKeys(fn func(tx *gorm.DB) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return s.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("LOCK TABLE jwt_keys IN SHARE ROW EXCLUSIVE MODE").Error
+
+		if err != nil {
+			return err
+		}
+
+		return fn(tx)
+	})
+}
+
+/*
+GetKeys is a method that returns every key which is not retired.
+*/
+func (s *PostgresKeyStore) GetKeys() ([]JWTKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var keys []JWTKey
+
+	err := s.Conn.WithContext(ctx).Model(&JWTKey{}).
+		Where("status != ?", KeyStatusRetired).
+		Order("created_at").
+		Find(&keys).Error
+
+	if err != nil {
+		return []JWTKey{}, err
+	}
+
+	return keys, nil
+}
+
+/*
+InitKeys is a method that stores the first current and next keys, unless there is a current key already.
+*/
+func (s *PostgresKeyStore) InitKeys(current JWTKey, next JWTKey) error {
+	return s.lockKeys(func(tx *gorm.DB) error {
+		var count int64
+
+		err := tx.Model(&JWTKey{}).Where("status = ?", KeyStatusCurrent).Count(&count).Error
+
+		if err != nil || count > 0 {
+			return err
+		}
+
+		now := time.Now()
+		current.Status = KeyStatusCurrent
+		current.ActivatedAt = &now
+		next.Status = KeyStatusNext
+
+		return tx.Create(&[]JWTKey{current, next}).Error
+	})
+}
+
+/*
+RotateKeys is a method that rotates the keys if the current key was activated before dueBefore.
+The next key becomes current, the current key becomes previous and the given key becomes the next key.
+It returns whether the keys were rotated.
+*/
+func (s *PostgresKeyStore) RotateKeys(next JWTKey, dueBefore time.Time) (bool, error) {
+	rotated := false
+
+	err := s.lockKeys(func(tx *gorm.DB) error {
+		var current JWTKey
+
+		err := tx.Model(&JWTKey{}).Find(&current, "status = ?", KeyStatusCurrent).Error
+
+		if err != nil {
+			return err
+		}
+
+		if current.ActivatedAt != nil && !current.ActivatedAt.Before(dueBefore) {
+			return nil
+		}
+
+		now := time.Now()
+
+		err = tx.Model(&JWTKey{}).Where("status = ?", KeyStatusCurrent).
+			Updates(map[string]any{"status": KeyStatusPrevious, "rotated_at": now}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&JWTKey{}).Where("status = ?", KeyStatusNext).
+			Updates(map[string]any{"status": KeyStatusCurrent, "activated_at": now}).Error
+
+		if err != nil {
+			return err
+		}
+
+		next.Status = KeyStatusNext
+		rotated = true
+
+		return tx.Create(&next).Error
+	})
+
+	return rotated, err
+}
+
+/*
+RetireKey is a method that retires a previous key, tokens signed by it are not accepted anymore.
+It returns ErrKeyNotFound if there is no such key and ErrKeyInUse if the key is the current or the next key.
+*/
+func (s *PostgresKeyStore) RetireKey(id string) error {
+	return s.lockKeys(func(tx *gorm.DB) error {
+		var key JWTKey
+
+		err := tx.Model(&JWTKey{}).Find(&key, "id = ? AND status != ?", id, KeyStatusRetired).Error
+
+		if err != nil {
+			return err
+		}
+
+		if key.ID == "" {
+			return ErrKeyNotFound
+		}
+
+		if key.Status != KeyStatusPrevious {
+			return ErrKeyInUse
+		}
+
+		return tx.Model(&JWTKey{}).Where("id = ?", id).
+			Updates(map[string]any{"status": KeyStatusRetired, "retired_at": time.Now(), "private_key": ""}).Error
+	})
+}
+
+/*
+RetireKeysRotatedBefore is a method that retires the previous keys which stopped signing before rotatedBefore,
+once every token they signed is expired.
+*/
+func (s *PostgresKeyStore) RetireKeysRotatedBefore(rotatedBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return s.Conn.WithContext(ctx).Model(&JWTKey{}).
+		Where("status = ? AND rotated_at < ?", KeyStatusPrevious, rotatedBefore).
+		Updates(map[string]any{"status": KeyStatusRetired, "retired_at": time.Now(), "private_key": ""}).Error
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+RecordFailedLogin is a method that counts a failed login of the user and returns the number of failed logins in a row.
+The counter is incremented by the database, so that concurrent failed logins are all counted.
+*/
+func (u *PostgresRepository) RecordFailedLogin(user User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var attempts int
+
+	err := db.WithContext(ctx).Raw(
+		"UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE id = ? RETURNING failed_login_attempts",
+		user.ID,
+	).Scan(&attempts).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+/*
+LockUser is a method that refuses every login of the user until the time.
+*/
+func (u *PostgresRepository) LockUser(user User, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&user).Update("locked_until", until).Error
+}
+
+/*
+ResetFailedLogins is a method that clears the failed logins of the user and unlocks the user.
+*/
+func (u *PostgresRepository) ResetFailedLogins(user User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&user).Updates(map[string]any{
+		"failed_login_attempts": 0,
+		"locked_until":          gorm.Expr("NULL"),
+	}).Error
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+MemoryKeyStore is a KeyStore which keeps the keys in memory.
+It is used for testing and for running a single instance without a database.
+*/
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []JWTKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{}
+}
+
+func (s *MemoryKeyStore) GetKeys() ([]JWTKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []JWTKey{}
+	for _, key := range s.keys {
+		if key.Status != KeyStatusRetired {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryKeyStore) InitKeys(current JWTKey, next JWTKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.Status == KeyStatusCurrent {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	current.Status = KeyStatusCurrent
+	current.CreatedAt = now
+	current.ActivatedAt = &now
+	next.Status = KeyStatusNext
+	next.CreatedAt = now
+
+	s.keys = append(s.keys, current, next)
+	return nil
+}
+
+func (s *MemoryKeyStore) RotateKeys(next JWTKey, dueBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.Status == KeyStatusCurrent && key.ActivatedAt != nil && !key.ActivatedAt.Before(dueBefore) {
+			return false, nil
+		}
+	}
+
+	now := time.Now()
+	for i := range s.keys {
+		switch s.keys[i].Status {
+		case KeyStatusCurrent:
+			s.keys[i].Status = KeyStatusPrevious
+			s.keys[i].RotatedAt = &now
+		case KeyStatusNext:
+			s.keys[i].Status = KeyStatusCurrent
+			s.keys[i].ActivatedAt = &now
+		}
+	}
+
+	next.Status = KeyStatusNext
+	next.CreatedAt = now
+
+	s.keys = append(s.keys, next)
+	return true, nil
+}
+
+func (s *MemoryKeyStore) RetireKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].ID != id || s.keys[i].Status == KeyStatusRetired {
+			continue
+		}
+
+		if s.keys[i].Status != KeyStatusPrevious {
+			return ErrKeyInUse
+		}
+
+		s.retire(i)
+		return nil
+	}
+
+	return ErrKeyNotFound
+}
+
+func (s *MemoryKeyStore) RetireKeysRotatedBefore(rotatedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].Status == KeyStatusPrevious && s.keys[i].RotatedAt.Before(rotatedBefore) {
+			s.retire(i)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryKeyStore) retire(i int) {
+	now := time.Now()
+	s.keys[i].Status = KeyStatusRetired
+	s.keys[i].RetiredAt = &now
+	s.keys[i].PrivateKey = ""
+}
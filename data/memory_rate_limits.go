@@ -0,0 +1,52 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+MemoryRateLimitStore is a RateLimitStore which keeps the buckets in memory.
+It is used for testing and for running a single instance without a database.
+*/
+type MemoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*RateLimitBucket
+	cleanedUpAt time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*RateLimitBucket{},
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(keys []string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.cleanedUpAt) > time.Minute {
+		for bucketKey, bucket := range s.buckets {
+			if now.Sub(bucket.RefilledAt) > rateLimitIdleAfter {
+				delete(s.buckets, bucketKey)
+			}
+		}
+		s.cleanedUpAt = now
+	}
+
+	buckets := make([]*RateLimitBucket, len(keys))
+
+	for i, key := range keys {
+		bucket, ok := s.buckets[key]
+
+		if !ok {
+			bucket = &RateLimitBucket{Key: key, Tokens: float64(limit.Requests), RefilledAt: now}
+			s.buckets[key] = bucket
+		}
+
+		buckets[i] = bucket
+	}
+
+	allowed, retryAfter := takeAll(buckets, limit, now)
+	return allowed, retryAfter, nil
+}
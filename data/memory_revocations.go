@@ -0,0 +1,61 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+MemoryRevocationStore is a RevocationStore which keeps the revocations in memory.
+It is used for testing and for running a single instance without a database.
+*/
+type MemoryRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time // id -> expires at
+	users   map[string]time.Time // user id -> revoked at
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked: map[string]time.Time{},
+		users:   map[string]time.Time{},
+	}
+}
+
+func (s *MemoryRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for revokedID, revokedUntil := range s.revoked {
+		if revokedUntil.Before(now) {
+			delete(s.revoked, revokedID)
+		}
+	}
+
+	s.revoked[id] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeUser(userID string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID] = revokedAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(ids []string, userID string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		if revokedUntil, ok := s.revoked[id]; ok && revokedUntil.After(now) {
+			return true, nil
+		}
+	}
+
+	revokedAt, ok := s.users[userID]
+	return ok && revokedAt.After(issuedAt), nil
+}
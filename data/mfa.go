@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrTOTPAlreadyEnabled is returned when enrolling or confirming the TOTP of a user who already has it enabled
+var ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+
+/*
+RecoveryCode is a single use code which replaces a TOTP code when the user has lost the authenticator.
+Only the SHA-256 hash of the code is stored.
+*/
+type RecoveryCode struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	UserID    string     `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the RecoveryCode struct
+func (code *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	code.ID = uuid.NewString()
+	return nil
+}
+
+/*
+SetTOTPSecret is a method that stores the secret of a TOTP enrollment, which is only enabled once confirmed.
+It returns ErrTOTPAlreadyEnabled if the user already has TOTP enabled.
+*/
+func (u *PostgresRepository) SetTOTPSecret(user User, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND totp_enabled_at IS NULL", user.ID).
+		Update("totp_secret", secret)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+/*
+EnableTOTP is a method that enables the TOTP of the user, marking the step of the confirmation code as used,
+and replaces the recovery codes of the user in a single transaction.
+It returns ErrTOTPAlreadyEnabled if the user already has TOTP enabled.
+*/
+func (u *PostgresRepository) EnableTOTP(user User, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).
+			Where("id = ? AND totp_enabled_at IS NULL", user.ID).
+			Updates(map[string]any{
+				"totp_enabled_at": time.Now(),
+				"totp_last_step":  step,
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrTOTPAlreadyEnabled
+		}
+
+		return replaceRecoveryCodes(tx, user, codeHashes)
+	})
+}
+
+/*
+DisableTOTP is a method that disables the TOTP of the user and deletes its recovery codes.
+*/
+func (u *PostgresRepository) DisableTOTP(user User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+			"totp_secret":     "",
+			"totp_enabled_at": gorm.Expr("NULL"),
+			"totp_last_step":  0,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+/*
+UseTOTPStep is a method that marks the time step of a TOTP code as used and reports whether it was not used yet.
+A code can only be used once, and never after a code of a later step, even by concurrent requests.
+*/
+func (u *PostgresRepository) UseTOTPStep(user User, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+/*
+ReplaceRecoveryCodes is a method that deletes the recovery codes of the user and stores the new ones.
+*/
+func (u *PostgresRepository) ReplaceRecoveryCodes(user User, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, user, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, user User, codeHashes []string) error {
+	err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+
+	if err != nil {
+		return err
+	}
+
+	codes := make([]RecoveryCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = RecoveryCode{UserID: user.ID, CodeHash: hash}
+	}
+
+	return tx.Create(&codes).Error
+}
+
+/*
+ConsumeRecoveryCode is a method that marks the unused recovery code of the user with the hash as used
+and reports whether there was one. A single update does the check and the write, so a code can not be used twice.
+*/
+func (u *PostgresRepository) ConsumeRecoveryCode(user User, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, codeHash).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+/*
+SetRequireAdminMFA is a method that makes MFA mandatory, or not, for the admins of the organization.
+*/
+func (u *PostgresRepository) SetRequireAdminMFA(org Organization, require bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&org).Update("require_admin_mfa", require).Error
+}
@@ -23,7 +23,8 @@ type PostgresRepository struct {
 func NewPostgresRepository(pool *gorm.DB) *PostgresRepository {
 	db = pool
 
-	db.AutoMigrate(&Organization{}, &User{})
+	db.AutoMigrate(&Organization{}, &User{}, &RefreshToken{}, &Role{}, &RoleChange{}, &OneTimeToken{}, &PasswordHistory{}, &RecoveryCode{}, &WebAuthnCredential{}, &Invitation{},
+		&OAuthClient{}, &OAuthConsent{}, &AuthorizationCode{}, &DeviceAuthorization{})
 	populateDatabase()
 
 	return &PostgresRepository{
@@ -39,8 +40,10 @@ type GormModel struct {
 
 type Organization struct {
 	GormModel
-	Name  string `json:"name" gorm:"unique"`
-	Users []User
+	Name            string         `json:"name" gorm:"unique"`
+	PasswordPolicy  PasswordPolicy `json:"password_policy" gorm:"embedded;embeddedPrefix:password_"`
+	RequireAdminMFA bool           `json:"require_admin_mfa" gorm:"column:require_admin_mfa;not null;default:false"`
+	Users           []User
 }
 
 type User struct {
@@ -49,6 +52,18 @@ type User struct {
 	Password       string `json:"-"`
 	Role           string `json:"role" gorm:"not null"`
 	OrganizationID string `json:"organization_id" gorm:"not null"`
+
+	FailedLoginAttempts int        `json:"-" gorm:"not null;default:0"`
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`
+
+	TOTPSecret    string     `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty" gorm:"column:totp_enabled_at"`
+	TOTPLastStep  int64      `json:"-" gorm:"column:totp_last_step;not null;default:0"`
+
+	// the users created before the emails have none, so it is nullable and unique only among the set ones
+	Email           *string    `json:"email,omitempty" gorm:"uniqueIndex"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Profile
 }
 
 /*
@@ -68,14 +83,6 @@ func (user *User) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
-// BeforeDelete hook is used to prevent the deletion of the admin user
-func (user *User) BeforeDelete(tx *gorm.DB) (err error) {
-	if user.Role == "admin" {
-		return errors.New("admin user not allowed to delete")
-	}
-	return
-}
-
 // =====================================================
 
 /*
@@ -114,6 +121,7 @@ func (u *PostgresRepository) GetByID(id string) (*User, error) {
 
 /*
 Insert is a method that inserts a User struct into the database and returns an error.
+It returns ErrDuplicateUsername if the username is already taken.
 */
 func (u *PostgresRepository) Insert(user User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
@@ -130,26 +138,47 @@ func (u *PostgresRepository) Insert(user User) error {
 	err = db.WithContext(ctx).Create(&user).Error
 
 	if err != nil {
-		return err
+		return uniqueViolation(err)
 	}
 
 	return nil
 }
 
+/*
+userRecords returns the models whose rows belong to a user by user_id, and are deleted along with the user.
+The role changes are kept as the audit log of the organization.
+*/
+func userRecords() []any {
+	return []any{&RefreshToken{}, &OneTimeToken{}, &PasswordHistory{}, &RecoveryCode{}, &WebAuthnCredential{},
+		&OAuthConsent{}, &AuthorizationCode{}, &DeviceAuthorization{}}
+}
+
 /*
 Delete is a method that deletes a User struct from the database and returns an error.
+The records of the user are deleted along with it, see userRecords, so that their sessions and links stop working.
+It returns ErrLastAdmin if the user is the last admin of the organization.
 */
 func (u *PostgresRepository) Delete(user User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
 	defer cancel()
 
-	err := db.WithContext(ctx).Model(&User{}).Delete(&user).Error
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := lockUserAndAdmins(tx, user)
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	return nil
+		for _, model := range userRecords() {
+			err = tx.Where("user_id = ?", current.ID).Delete(model).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&current).Error
+	})
 }
 
 /*
@@ -223,11 +252,11 @@ You can see the visual representation of the data in the README.md file
 */
 func populateDatabase() {
 
-	db.Exec("TRUNCATE users, organizations")
+	db.Exec("TRUNCATE users, organizations, refresh_tokens, roles, role_changes, one_time_tokens, password_histories, recovery_codes, webauthn_credentials, invitations, oauth_clients, oauth_consents, oauth_authorization_codes, oauth_device_authorizations")
 
 	orgs := []Organization{
-		{Name: "ORG-1"},
-		{Name: "ORG-2"},
+		{Name: "ORG-1", PasswordPolicy: DefaultPasswordPolicy()},
+		{Name: "ORG-2", PasswordPolicy: DefaultPasswordPolicy()},
 	}
 
 	for _, org := range orgs {
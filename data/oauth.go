@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Grant types a client can be registered for
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// DefaultGrantTypes are the grant types of the clients registered without any
+var DefaultGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+
+var (
+	ErrInvalidAuthorizationCode = errors.New("invalid or expired authorization code")
+	ErrAuthorizationCodeReused  = errors.New("authorization code already used")
+)
+
+/*
+OAuthClient is an application registered by an organization, which gets tokens for the users of the organization
+with the OAuth 2.0 authorization code flow. Its id is the client_id. Confidential clients, like a backend, also
+authenticate with a secret, of which only the SHA-256 hash is stored. Public clients, like a single page app, do not.
+A redirect URI must match one of the registered ones exactly, and only the registered scopes can be asked for.
+A machine client, registered for the client_credentials grant, gets tokens for itself instead of for a user.
+*/
+type OAuthClient struct {
+	GormModel
+	OrganizationID string   `json:"organization_id" gorm:"not null;index"`
+	Name           string   `json:"name" gorm:"not null"`
+	Confidential   bool     `json:"confidential" gorm:"not null;default:false"`
+	SecretHash     string   `json:"-"`
+	RedirectURIs   []string `json:"redirect_uris" gorm:"serializer:json"`
+	Scopes         []string `json:"scopes" gorm:"serializer:json"`
+	GrantTypes     []string `json:"grant_types" gorm:"serializer:json"`
+	CreatedBy      string   `json:"created_by" gorm:"not null"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the OAuthClient struct
+func (client *OAuthClient) BeforeCreate(tx *gorm.DB) (err error) {
+	client.ID = uuid.NewString()
+	return nil
+}
+
+/*
+HasGrantType reports whether the client is registered for the grant type.
+The clients registered before the grant types were recorded have the DefaultGrantTypes.
+*/
+func (client OAuthClient) HasGrantType(grantType string) bool {
+	grantTypes := client.GrantTypes
+	if grantTypes == nil {
+		grantTypes = DefaultGrantTypes
+	}
+
+	for _, granted := range grantTypes {
+		if granted == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+OAuthConsent records the scopes a user agreed to give to a client, so that they are not asked again.
+*/
+type OAuthConsent struct {
+	GormModel
+	UserID   string   `json:"user_id" gorm:"not null;uniqueIndex:idx_oauth_consents_user_client"`
+	ClientID string   `json:"client_id" gorm:"not null;uniqueIndex:idx_oauth_consents_user_client"`
+	Scopes   []string `json:"scopes" gorm:"serializer:json"`
+}
+
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the OAuthConsent struct
+func (consent *OAuthConsent) BeforeCreate(tx *gorm.DB) (err error) {
+	consent.ID = uuid.NewString()
+	return nil
+}
+
+/*
+AuthorizationCode is the short lived code a client exchanges for the tokens of a user. Only the SHA-256 hash
+of the code is stored, along with the PKCE challenge the client must answer and the OpenID Connect nonce to put
+in the ID token. It can only be used once,
+its id is the family of the refresh tokens it is exchanged for, so that a second use can revoke them.
+*/
+type AuthorizationCode struct {
+	GormModel
+	ClientID      string     `json:"client_id" gorm:"not null;index"`
+	UserID        string     `json:"user_id" gorm:"not null;index"`
+	RedirectURI   string     `json:"redirect_uri" gorm:"not null"`
+	Scope         string     `json:"scope" gorm:"not null;default:''"`
+	CodeChallenge string     `json:"-" gorm:"not null"`
+	Nonce         string     `json:"-" gorm:"not null;default:''"`
+	CodeHash      string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt        *time.Time `json:"used_at"`
+}
+
+func (AuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the AuthorizationCode struct
+func (code *AuthorizationCode) BeforeCreate(tx *gorm.DB) (err error) {
+	code.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertOAuthClient is a method that inserts an OAuthClient struct into the database and returns it with its id.
+*/
+func (u *PostgresRepository) InsertOAuthClient(client OAuthClient) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	err := db.WithContext(ctx).Create(&client).Error
+
+	if err != nil {
+		return &OAuthClient{}, err
+	}
+
+	return &client, nil
+}
+
+/*
+GetOAuthClient is a method that returns the client with the id, or an empty one if there is none.
+*/
+func (u *PostgresRepository) GetOAuthClient(id string) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var client OAuthClient
+
+	err := db.WithContext(ctx).Find(&client, "id = ?", id).Error
+
+	if err != nil {
+		return &OAuthClient{}, err
+	}
+
+	return &client, nil
+}
+
+/*
+GetOAuthClients is a method that returns the clients of the organization, oldest first.
+*/
+func (u *PostgresRepository) GetOAuthClients(orgID string) ([]OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var clients []OAuthClient
+
+	err := db.WithContext(ctx).Order("created_at").Find(&clients, "organization_id = ?", orgID).Error
+
+	if err != nil {
+		return []OAuthClient{}, err
+	}
+
+	return clients, nil
+}
+
+/*
+DeleteOAuthClient is a method that deletes the client along with its consents, authorization codes and device authorizations,
+and revokes the refresh tokens issued to it.
+*/
+func (u *PostgresRepository) DeleteOAuthClient(client OAuthClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&RefreshToken{}).
+			Where("client_id = ? AND revoked_at IS NULL", client.ID).
+			Update("revoked_at", time.Now()).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("client_id = ?", client.ID).Delete(&OAuthConsent{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("client_id = ?", client.ID).Delete(&AuthorizationCode{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("client_id = ?", client.ID).Delete(&DeviceAuthorization{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&client).Error
+	})
+}
+
+/*
+GetOAuthConsent is a method that returns the consent of the user to the client, or an empty one if there is none.
+*/
+func (u *PostgresRepository) GetOAuthConsent(userID, clientID string) (*OAuthConsent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var consent OAuthConsent
+
+	err := db.WithContext(ctx).Find(&consent, "user_id = ? AND client_id = ?", userID, clientID).Error
+
+	if err != nil {
+		return &OAuthConsent{}, err
+	}
+
+	return &consent, nil
+}
+
+/*
+SaveOAuthConsent is a method that records the scopes the user agreed to give to the client,
+replacing the ones of a previous consent.
+*/
+func (u *PostgresRepository) SaveOAuthConsent(consent OAuthConsent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(&consent).Error
+}
+
+/*
+InsertAuthorizationCode is a method that inserts an AuthorizationCode struct into the database and returns an error.
+*/
+func (u *PostgresRepository) InsertAuthorizationCode(code AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Create(&code).Error
+}
+
+/*
+ConsumeAuthorizationCode is a method that marks the authorization code with the hash as used and returns it.
+A single update does the check and the write, so a code can not be exchanged twice by concurrent requests.
+It returns ErrAuthorizationCodeReused along with the code if it was already used, and ErrInvalidAuthorizationCode
+if no unexpired code matches.
+*/
+func (u *PostgresRepository) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var codes []AuthorizationCode
+	now := time.Now()
+
+	err := db.WithContext(ctx).Model(&codes).Clauses(clause.Returning{}).
+		Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", codeHash, now).
+		Update("used_at", now).Error
+
+	if err != nil {
+		return &AuthorizationCode{}, err
+	}
+
+	if len(codes) == 1 {
+		return &codes[0], nil
+	}
+
+	var code AuthorizationCode
+
+	err = db.WithContext(ctx).Find(&code, "code_hash = ? AND used_at IS NOT NULL", codeHash).Error
+
+	if err != nil {
+		return &AuthorizationCode{}, err
+	}
+
+	if code.ID != "" {
+		return &code, ErrAuthorizationCodeReused
+	}
+
+	return &AuthorizationCode{}, ErrInvalidAuthorizationCode
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Purposes of the one time tokens, a token can only be consumed for the purpose it was issued for
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeMagicLink     = "magic_link"
+	PurposeEmailVerify   = "email_verification"
+
+	// the challenges of the WebAuthn ceremonies, the user is unknown until the passwordless login is done
+	PurposeWebAuthnRegistration = "webauthn_registration"
+	PurposeWebAuthnLogin        = "webauthn_login"
+	PurposeWebAuthnMFA          = "webauthn_mfa"
+)
+
+// ErrInvalidOneTimeToken is returned when a one time token does not exist, was already used or has expired
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+/*
+OneTimeToken is a short lived token which is sent to a user out of band, like a password reset link.
+Only the SHA-256 hash of the token is stored, and it can only be consumed once, before it expires.
+*/
+type OneTimeToken struct {
+	GormModel
+	UserID    string     `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the OneTimeToken struct
+func (token *OneTimeToken) BeforeCreate(tx *gorm.DB) (err error) {
+	token.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertOneTimeToken is a method that inserts a OneTimeToken struct into the database and returns an error.
+*/
+func (u *PostgresRepository) InsertOneTimeToken(token OneTimeToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Create(&token).Error
+}
+
+/*
+ReplaceOneTimeToken is a method that inserts a OneTimeToken struct into the database, and uses up the unused tokens
+of the same user and purpose in the same transaction, so that only the last token sent to the user is valid.
+*/
+func (u *PostgresRepository) ReplaceOneTimeToken(token OneTimeToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&token).Error
+	})
+}
+
+/*
+GetOneTimeToken is a method that returns the unused and unexpired token of the purpose with the hash, without consuming it.
+It returns ErrInvalidOneTimeToken if there is none.
+*/
+func (u *PostgresRepository) GetOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var token OneTimeToken
+
+	err := db.WithContext(ctx).Find(&token,
+		"token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()).Error
+
+	if err != nil {
+		return &OneTimeToken{}, err
+	}
+
+	if token.ID == "" {
+		return &OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	return &token, nil
+}
+
+/*
+ConsumeOneTimeToken is a method that marks the token with the hash as used and returns it.
+A single update does the check and the write, so a token can not be consumed twice by concurrent requests.
+It returns ErrInvalidOneTimeToken if no unused and unexpired token of the purpose matches.
+*/
+func (u *PostgresRepository) ConsumeOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return consumeOneTimeToken(db.WithContext(ctx), purpose, tokenHash)
+}
+
+/*
+consumeOneTimeToken marks the token with the hash as used and returns it, with the connection or the transaction tx.
+*/
+func consumeOneTimeToken(tx *gorm.DB, purpose, tokenHash string) (*OneTimeToken, error) {
+	var tokens []OneTimeToken
+	now := time.Now()
+
+	err := tx.Model(&tokens).Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("used_at", now).Error
+
+	if err != nil {
+		return &OneTimeToken{}, err
+	}
+
+	if len(tokens) == 0 {
+		return &OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	return &tokens[0], nil
+}
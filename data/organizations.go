@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateOrganization = errors.New("organization name already taken")
+	ErrDuplicateUsername     = errors.New("username already taken")
+	ErrOrganizationNotEmpty  = errors.New("organization still has users")
+)
+
+/*
+OrganizationSummary is an Organization along with the number of its members.
+*/
+type OrganizationSummary struct {
+	Organization
+	MemberCount int64 `json:"member_count"`
+}
+
+/*
+CreateOrganization is a method that inserts an Organization and its first admin User in a single transaction.
+It returns the created Organization with the admin in its Users.
+*/
+func (u *PostgresRepository) CreateOrganization(org Organization, admin User) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	hashPassword, err := bcrypt.GenerateFromPassword([]byte(admin.Password), 12)
+
+	if err != nil {
+		return &Organization{}, err
+	}
+
+	admin.Password = string(hashPassword)
+	admin.Role = "admin"
+
+	if org.PasswordPolicy == (PasswordPolicy{}) {
+		org.PasswordPolicy = DefaultPasswordPolicy()
+	}
+
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("Users").Create(&org).Error
+
+		if err != nil {
+			return err
+		}
+
+		admin.OrganizationID = org.ID
+
+		return tx.Create(&admin).Error
+	})
+
+	if err != nil {
+		return &Organization{}, uniqueViolation(err)
+	}
+
+	org.Users = []User{admin}
+	return &org, nil
+}
+
+/*
+GetOrganizationByID is a method that takes an id and returns an Organization struct and an error.
+If no organization matches, the returned Organization has an empty ID.
+*/
+func (u *PostgresRepository) GetOrganizationByID(id string) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var org Organization
+	err := db.WithContext(ctx).Model(&Organization{}).Find(&org, "id = ?", id).Error
+
+	if err != nil {
+		return &Organization{}, err
+	}
+
+	return &org, nil
+}
+
+/*
+GetOrganizations is a method that returns every Organization with the number of its members.
+*/
+func (u *PostgresRepository) GetOrganizations() ([]OrganizationSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var orgs []OrganizationSummary
+
+	err := db.WithContext(ctx).Model(&Organization{}).
+		Select("organizations.*, (SELECT COUNT(*) FROM users WHERE users.organization_id = organizations.id) AS member_count").
+		Order("organizations.name").
+		Find(&orgs).Error
+
+	if err != nil {
+		return []OrganizationSummary{}, err
+	}
+
+	return orgs, nil
+}
+
+/*
+RenameOrganization is a method that changes the name of an Organization.
+It returns ErrDuplicateOrganization if the name is taken by another organization.
+*/
+func (u *PostgresRepository) RenameOrganization(org Organization, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	err := db.WithContext(ctx).Model(&org).Update("name", name).Error
+
+	if err != nil {
+		return uniqueViolation(err)
+	}
+
+	return nil
+}
+
+/*
+DeleteOrganization is a method that deletes an Organization.
+If the organization still has users, it returns ErrOrganizationNotEmpty, unless cascade is set,
+in which case the users, their records, see userRecords, and their role changes are deleted along with the organization.
+The invitations, custom roles and OAuth clients of the organization are deleted along with it,
+as are the consents, authorization codes and device authorizations of the clients.
+*/
+func (u *PostgresRepository) DeleteOrganization(org Organization, cascade bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var members int64
+
+		err := tx.Model(&User{}).Where("organization_id = ?", org.ID).Count(&members).Error
+
+		if err != nil {
+			return err
+		}
+
+		if members > 0 && !cascade {
+			return ErrOrganizationNotEmpty
+		}
+
+		users := tx.Model(&User{}).Select("id").Where("organization_id = ?", org.ID)
+
+		// the role changes are the audit log of the organization, which goes with it
+		for _, model := range append(userRecords(), &RoleChange{}) {
+			err = tx.Where("user_id IN (?)", users).Delete(model).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		clients := tx.Model(&OAuthClient{}).Select("id").Where("organization_id = ?", org.ID)
+
+		err = tx.Where("client_id IN (?)", clients).Delete(&OAuthConsent{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("client_id IN (?)", clients).Delete(&AuthorizationCode{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("client_id IN (?)", clients).Delete(&DeviceAuthorization{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("organization_id = ?", org.ID).Delete(&OAuthClient{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("organization_id = ?", org.ID).Delete(&Invitation{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("organization_id = ?", org.ID).Delete(&Role{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("organization_id = ?", org.ID).Delete(&User{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&org).Error
+	})
+}
+
+/*
+uniqueViolation translates the unique constraint violations of Postgres to ErrDuplicateOrganization, ErrDuplicateUsername
+and ErrDuplicateEmail.
+Any other error is returned as it is.
+*/
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+
+	switch {
+	case pgErr.TableName == "organizations" && strings.Contains(pgErr.ConstraintName, "name"):
+		return ErrDuplicateOrganization
+	case pgErr.TableName == "users" && strings.Contains(pgErr.ConstraintName, "username"):
+		return ErrDuplicateUsername
+	case pgErr.TableName == "users" && strings.Contains(pgErr.ConstraintName, "email"):
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
+
+/*
+isUniqueViolation reports whether the error is a unique constraint violation of Postgres.
+*/
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxPasswordHistory is the most previous passwords a policy can refuse, older ones are not kept
+const MaxPasswordHistory = 24
+
+/*
+PasswordPolicy is the set of rules the passwords of the users of an organization must follow.
+It is stored in the columns of the organization, prefixed with `password_`.
+The booleans default to false in the columns, as GORM writes the default of a column in place of a false on create,
+the defaults of a new organization come from DefaultPasswordPolicy.
+*/
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length" gorm:"not null;default:8"`
+	MaxLength        int  `json:"max_length" gorm:"not null;default:72"` // bcrypt ignores anything after 72 bytes
+	RequireUpper     bool `json:"require_upper" gorm:"not null;default:false"`
+	RequireLower     bool `json:"require_lower" gorm:"not null;default:false"`
+	RequireDigit     bool `json:"require_digit" gorm:"not null;default:false"`
+	RequireSymbol    bool `json:"require_symbol" gorm:"not null;default:false"`
+	DisallowUsername bool `json:"disallow_username" gorm:"not null;default:false"`
+	History          int  `json:"history" gorm:"not null;default:0"` // number of last passwords which can not be used again
+}
+
+/*
+DefaultPasswordPolicy is the policy of a new organization.
+*/
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:        8,
+		MaxLength:        72,
+		DisallowUsername: true,
+	}
+}
+
+/*
+PasswordHistory is a previous password hash of a user, kept to refuse reusing it.
+*/
+type PasswordHistory struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"not null;index"`
+	Password  string    `json:"-" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the PasswordHistory struct
+func (history *PasswordHistory) BeforeCreate(tx *gorm.DB) (err error) {
+	history.ID = uuid.NewString()
+	return nil
+}
+
+/*
+UpdatePasswordPolicy is a method that replaces the password policy of an organization.
+*/
+func (u *PostgresRepository) UpdatePasswordPolicy(org Organization, policy PasswordPolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	// a map, so that the false and 0 values are written too
+	return db.WithContext(ctx).Model(&org).Updates(map[string]any{
+		"password_min_length":        policy.MinLength,
+		"password_max_length":        policy.MaxLength,
+		"password_require_upper":     policy.RequireUpper,
+		"password_require_lower":     policy.RequireLower,
+		"password_require_digit":     policy.RequireDigit,
+		"password_require_symbol":    policy.RequireSymbol,
+		"password_disallow_username": policy.DisallowUsername,
+		"password_history":           policy.History,
+	}).Error
+}
+
+/*
+PasswordUsedRecently is a method that reports whether the password is the current password of the user
+or one of the previous passwords, up to the last n passwords.
+*/
+func (u *PostgresRepository) PasswordUsedRecently(user User, password string, n int) (bool, error) {
+	if n <= 0 {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	hashes := []string{user.Password}
+	var previous []string
+
+	err := db.WithContext(ctx).Model(&PasswordHistory{}).Where("user_id = ?", user.ID).
+		Order("created_at DESC").Limit(n-1).Pluck("password", &previous).Error
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range append(hashes, previous...) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+		if err == nil {
+			return true, nil
+		}
+
+		if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+/*
+UpdatePassword is a method that hashes the new password of a user and stores it.
+The previous password is kept in the history of the user.
+*/
+func (u *PostgresRepository) UpdatePassword(user User, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	hashPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+
+	if err != nil {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return updatePassword(tx, user, string(hashPassword))
+	})
+}
+
+/*
+ResetPassword is a method that consumes the password reset token with the hash and stores the new password of its user,
+in one transaction, so that the token is only used up once the password is changed.
+It returns ErrInvalidOneTimeToken if the token was used or has expired in the meantime.
+*/
+func (u *PostgresRepository) ResetPassword(tokenHash, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	hashPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+
+	if err != nil {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		token, err := consumeOneTimeToken(tx, PurposePasswordReset, tokenHash)
+
+		if err != nil {
+			return err
+		}
+
+		return updatePassword(tx, User{GormModel: GormModel{ID: token.UserID}}, string(hashPassword))
+	})
+}
+
+/*
+updatePassword keeps the current password of the user in the history and replaces it with the hash, in the transaction tx.
+*/
+func updatePassword(tx *gorm.DB, user User, hashPassword string) error {
+	err := keepPasswordHistory(tx, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&user).Update("password", hashPassword).Error
+}
+
+/*
+keepPasswordHistory adds the current password hash of the user to the history and drops the ones
+older than MaxPasswordHistory, it runs in the transaction changing the password.
+*/
+func keepPasswordHistory(tx *gorm.DB, userID string) error {
+	var current User
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&current, "id = ?", userID).Error
+
+	if err != nil {
+		return err
+	}
+
+	if current.ID == "" {
+		return nil
+	}
+
+	err = tx.Create(&PasswordHistory{UserID: current.ID, Password: current.Password}).Error
+
+	if err != nil {
+		return err
+	}
+
+	// the current password is one of the history, so one less is kept
+	kept := tx.Model(&PasswordHistory{}).Select("id").Where("user_id = ?", current.ID).
+		Order("created_at DESC").Limit(MaxPasswordHistory - 1)
+
+	return tx.Where("user_id = ? AND id NOT IN (?)", current.ID, kept).Delete(&PasswordHistory{}).Error
+}
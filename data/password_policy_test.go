@@ -0,0 +1,46 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+/*
+Testing the password policy written when creating an organization
+
+	-> The false values are written as false, rather than replaced by a default of the column
+*/
+func Test_PasswordPolicyCreate(t *testing.T) {
+	dryRun, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+
+	if err != nil {
+		t.Fatalf("Failed to open dry run database: %s", err.Error())
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.DisallowUsername = false
+
+	org := Organization{Name: "org", PasswordPolicy: policy}
+	statement := dryRun.Omit("Users").Create(&org).Statement
+
+	columns := regexp.MustCompile(`\(([^)]*)\) VALUES`).FindStringSubmatch(statement.SQL.String())
+
+	if columns == nil {
+		t.Fatalf("FAILED: Unexpected insert %s", statement.SQL.String())
+	}
+
+	for i, column := range strings.Split(columns[1], ",") {
+		if column == `"password_disallow_username"` {
+			if statement.Vars[i] != false {
+				t.Errorf("FAILED: Expected false get %v", statement.Vars[i])
+			}
+			return
+		}
+	}
+
+	t.Errorf("FAILED: Expected password_disallow_username in %s", statement.SQL.String())
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateEmail is returned when the email of a user is already the one of another user
+var ErrDuplicateEmail = errors.New("email already taken")
+
+/*
+Profile is what the users tell about themselves. The columns default to empty, so that the users
+created before the profiles stay valid.
+*/
+type Profile struct {
+	DisplayName string `json:"display_name" gorm:"not null;default:''"`
+	AvatarURL   string `json:"avatar_url" gorm:"not null;default:''"`
+	Locale      string `json:"locale" gorm:"not null;default:''"`
+	Timezone    string `json:"timezone" gorm:"not null;default:''"`
+}
+
+/*
+UpdateProfile is a method that replaces the profile of the user.
+*/
+func (u *PostgresRepository) UpdateProfile(user User, profile Profile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"display_name": profile.DisplayName,
+		"avatar_url":   profile.AvatarURL,
+		"locale":       profile.Locale,
+		"timezone":     profile.Timezone,
+	}).Error
+}
+
+/*
+SetEmail is a method that changes the email of the user, which is unverified until VerifyEmail.
+An empty email removes it. It returns ErrDuplicateEmail if another user has the email.
+*/
+func (u *PostgresRepository) SetEmail(user User, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var value any
+	if email != "" {
+		value = email
+	}
+
+	err := db.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"email":             value,
+		"email_verified_at": nil,
+	}).Error
+
+	if err != nil {
+		return uniqueViolation(err)
+	}
+
+	return nil
+}
+
+/*
+VerifyEmail is a method that marks the email of the user as verified, and reports whether it did.
+It does not if the email of the user is no longer the verified one, so that an old link can not verify a new email.
+*/
+func (u *PostgresRepository) VerifyEmail(user User, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND email = ? AND email_verified_at IS NULL", user.ID, email).
+		Update("email_verified_at", time.Now())
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// buckets which have not been used for this long are deleted, they would be full again anyway
+const rateLimitIdleAfter = 24 * time.Hour
+
+/*
+RateLimitStore keeps a token bucket per key, like a client IP or a username, to throttle the requests.
+
+	PostgresRateLimitStore ----
+	                           \__ RateLimitStore
+	                           /
+	MemoryRateLimitStore ------
+
+The Postgres store is shared by every replica, so that a client is throttled the same whichever replica it hits.
+*/
+type RateLimitStore interface {
+	Take(keys []string, limit RateLimit, now time.Time) (bool, time.Duration, error)
+}
+
+/*
+RateLimit allows Requests requests per Period. The bucket holds up to Requests tokens and refills continuously,
+so a burst of Requests requests is allowed, and after that one request every Period/Requests.
+*/
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"not null;index"`
+}
+
+/*
+refill adds the tokens for the time since the bucket was last refilled.
+*/
+func (bucket *RateLimitBucket) refill(limit RateLimit, now time.Time) {
+	if elapsed := now.Sub(bucket.RefilledAt); elapsed > 0 {
+		bucket.Tokens = math.Min(float64(limit.Requests), bucket.Tokens+float64(elapsed)/float64(limit.perToken()))
+		bucket.RefilledAt = now
+	}
+}
+
+/*
+wait returns how long until the bucket has a token, 0 if it has one now.
+*/
+func (bucket *RateLimitBucket) wait(limit RateLimit) time.Duration {
+	if bucket.Tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - bucket.Tokens) * float64(limit.perToken()))
+}
+
+// perToken is the time it takes to refill a token
+func (limit RateLimit) perToken() time.Duration {
+	return limit.Period / time.Duration(limit.Requests)
+}
+
+/*
+takeAll refills the buckets and takes a token out of every one of them, only if every one of them has a token,
+so that a refused request does not use up the buckets it was allowed by. If the request is refused,
+it returns how long until every bucket has a token again.
+*/
+func takeAll(buckets []*RateLimitBucket, limit RateLimit, now time.Time) (bool, time.Duration) {
+	var retryAfter time.Duration
+
+	for _, bucket := range buckets {
+		bucket.refill(limit, now)
+
+		if wait := bucket.wait(limit); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, bucket := range buckets {
+		bucket.Tokens--
+	}
+
+	return true, 0
+}
+
+type PostgresRateLimitStore struct {
+	Conn *gorm.DB
+
+	mu          sync.Mutex
+	cleanedUpAt time.Time
+}
+
+func NewPostgresRateLimitStore(pool *gorm.DB) *PostgresRateLimitStore {
+	pool.AutoMigrate(&RateLimitBucket{})
+
+	return &PostgresRateLimitStore{
+		Conn: pool,
+	}
+}
+
+/*
+Take is a method that takes a token from the bucket of every key, and returns whether the request is allowed.
+If it is not, no token is taken, and it also returns how long until the next request is allowed.
+The buckets are locked in the order of their keys until the tokens are taken, so that concurrent requests
+on every replica are all counted without deadlocking.
+*/
+func (s *PostgresRateLimitStore) Take(keys []string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var allowed bool
+	var retryAfter time.Duration
+
+	keys = append([]string{}, keys...)
+	sort.Strings(keys)
+
+	err := s.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		buckets := make([]*RateLimitBucket, len(keys))
+
+		for i, key := range keys {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&RateLimitBucket{Key: key, Tokens: float64(limit.Requests), RefilledAt: now}).Error
+
+			if err != nil {
+				return err
+			}
+
+			buckets[i] = &RateLimitBucket{}
+
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(buckets[i], "key = ?", key).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		allowed, retryAfter = takeAll(buckets, limit, now)
+
+		for _, bucket := range buckets {
+			err := tx.Save(bucket).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	s.cleanUp(now)
+
+	return allowed, retryAfter, nil
+}
+
+// cleanUp deletes the idle buckets, at most once a minute
+func (s *PostgresRateLimitStore) cleanUp(now time.Time) {
+	s.mu.Lock()
+
+	if now.Sub(s.cleanedUpAt) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+
+	s.cleanedUpAt = now
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	s.Conn.WithContext(ctx).Where("refilled_at < ?", now.Add(-rateLimitIdleAfter)).Delete(&RateLimitBucket{})
+}
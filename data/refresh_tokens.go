@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+/*
+RefreshToken is a long lived, server side stored token which is exchanged for a new access token.
+Only the SHA-256 hash of the token is stored. Every rotation creates a new token in the same family,
+so that the whole family can be revoked when an already used token is presented again.
+The refresh tokens issued to an OAuth client carry its id and the granted scope, the ones of a login session have neither.
+*/
+type RefreshToken struct {
+	GormModel
+	UserID    string     `json:"user_id" gorm:"not null;index"`
+	FamilyID  string     `json:"family_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	ClientID  string     `json:"client_id,omitempty" gorm:"not null;default:'';index"`
+	Scope     string     `json:"scope,omitempty" gorm:"not null;default:''"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the RefreshToken struct
+func (token *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	token.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertRefreshToken is a method that inserts a RefreshToken struct into the database and returns an error.
+*/
+func (u *PostgresRepository) InsertRefreshToken(token RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Create(&token).Error
+}
+
+/*
+GetRefreshToken is a method that takes the hash of a refresh token and returns a RefreshToken struct and an error.
+If no token matches, the returned RefreshToken has an empty ID.
+*/
+func (u *PostgresRepository) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var token RefreshToken
+	err := db.WithContext(ctx).Model(&RefreshToken{}).Find(&token, "token_hash = ?", tokenHash).Error
+
+	if err != nil {
+		return &RefreshToken{}, err
+	}
+
+	return &token, nil
+}
+
+/*
+RotateRefreshToken is a method that marks the old refresh token as used and inserts the next one in a single transaction.
+It returns ErrRefreshTokenReused if the old token was already used or revoked, which happens when two requests race with the same token.
+*/
+func (u *PostgresRepository) RotateRefreshToken(old RefreshToken, next RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", old.ID).
+			Update("used_at", time.Now())
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		return tx.Create(&next).Error
+	})
+}
+
+/*
+RevokeRefreshTokenFamily is a method that revokes every refresh token belonging to the given family.
+*/
+func (u *PostgresRepository) RevokeRefreshTokenFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+/*
+GetActiveRefreshTokens is a method that returns the refresh tokens of the user which can still be used.
+As every rotation uses up the previous token, there is one active token for each session of the user.
+*/
+func (u *PostgresRepository) GetActiveRefreshTokens(userID string) ([]RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var tokens []RefreshToken
+
+	err := db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("user_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at").
+		Find(&tokens).Error
+
+	if err != nil {
+		return []RefreshToken{}, err
+	}
+
+	return tokens, nil
+}
+
+/*
+RevokeUserRefreshTokens is a method that revokes every refresh token of the user.
+*/
+func (u *PostgresRepository) RevokeUserRefreshTokens(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
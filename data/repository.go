@@ -1,5 +1,7 @@
 package data
 
+import "time"
+
 /*
 	Repository Method to make our handlers testable by mocking database.
 
@@ -19,4 +21,76 @@ type Repository interface {
 	Insert(user User) error
 	Delete(user User) error
 	PasswordMatch(plainTextPassword string, user User) (bool, error)
+
+	InsertRefreshToken(token RefreshToken) error
+	GetRefreshToken(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(old RefreshToken, next RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	GetActiveRefreshTokens(userID string) ([]RefreshToken, error)
+	RevokeUserRefreshTokens(userID string) error
+
+	CreateOrganization(org Organization, admin User) (*Organization, error)
+	GetOrganizationByID(id string) (*Organization, error)
+	GetOrganizations() ([]OrganizationSummary, error)
+	RenameOrganization(org Organization, name string) error
+	DeleteOrganization(org Organization, cascade bool) error
+	UpdatePasswordPolicy(org Organization, policy PasswordPolicy) error
+
+	GetRole(orgID, name string) (*Role, error)
+	GetRoles(orgID string) ([]Role, error)
+	CreateRole(role Role) error
+	DeleteRole(role Role) error
+	UpdateUserRole(user User, role string, changedBy User) (*RoleChange, error)
+
+	InsertOneTimeToken(token OneTimeToken) error
+	ReplaceOneTimeToken(token OneTimeToken) error
+	GetOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error)
+	ConsumeOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error)
+	UpdatePassword(user User, password string) error
+	ResetPassword(tokenHash, password string) error
+	PasswordUsedRecently(user User, password string, n int) (bool, error)
+
+	RecordFailedLogin(user User) (int, error)
+	LockUser(user User, until time.Time) error
+	ResetFailedLogins(user User) error
+
+	SetTOTPSecret(user User, secret string) error
+	EnableTOTP(user User, step int64, codeHashes []string) error
+	DisableTOTP(user User) error
+	UseTOTPStep(user User, step int64) (bool, error)
+	ReplaceRecoveryCodes(user User, codeHashes []string) error
+	ConsumeRecoveryCode(user User, codeHash string) (bool, error)
+	SetRequireAdminMFA(org Organization, require bool) error
+
+	InsertWebAuthnCredential(credential WebAuthnCredential) error
+	GetWebAuthnCredential(credentialID string) (*WebAuthnCredential, error)
+	GetWebAuthnCredentials(userID string) ([]WebAuthnCredential, error)
+	UseWebAuthnCredential(credential WebAuthnCredential, signCount uint32) (bool, error)
+	DeleteWebAuthnCredential(credential WebAuthnCredential) error
+
+	InsertInvitation(invitation Invitation) (*Invitation, error)
+	GetInvitation(id string) (*Invitation, error)
+	GetPendingInvitations(orgID string) ([]Invitation, error)
+	RenewInvitation(invitation Invitation, tokenHash string, expiresAt time.Time) error
+	RevokeInvitation(invitation Invitation) error
+	GetInvitationByToken(tokenHash string) (*Invitation, error)
+	AcceptInvitation(tokenHash, password string) (*User, error)
+
+	UpdateProfile(user User, profile Profile) error
+	SetEmail(user User, email string) error
+	VerifyEmail(user User, email string) (bool, error)
+
+	InsertOAuthClient(client OAuthClient) (*OAuthClient, error)
+	GetOAuthClient(id string) (*OAuthClient, error)
+	GetOAuthClients(orgID string) ([]OAuthClient, error)
+	DeleteOAuthClient(client OAuthClient) error
+	GetOAuthConsent(userID, clientID string) (*OAuthConsent, error)
+	SaveOAuthConsent(consent OAuthConsent) error
+	InsertAuthorizationCode(code AuthorizationCode) error
+	ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error)
+
+	InsertDeviceAuthorization(authorization DeviceAuthorization) error
+	GetDeviceAuthorization(userCodeHash string) (*DeviceAuthorization, error)
+	DecideDeviceAuthorization(authorization DeviceAuthorization, user User, scope string, approved bool) error
+	PollDeviceAuthorization(deviceCodeHash, clientID string) (*DeviceAuthorization, error)
 }
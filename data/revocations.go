@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+RevocationStore keeps track of access tokens which must not be accepted anymore, even though they are not expired yet.
+
+	PostgresRevocationStore ----
+	                            \__ RevocationStore
+	                            /
+	MemoryRevocationStore ------
+
+Single tokens and whole sessions are revoked by their id (the `jti` or the `sid` claim) until they would expire on their own.
+All the tokens of a user are revoked at once by storing the time of the revocation, every token issued before it is rejected.
+*/
+type RevocationStore interface {
+	Revoke(id string, expiresAt time.Time) error
+	RevokeUser(userID string, revokedAt time.Time) error
+	IsRevoked(ids []string, userID string, issuedAt time.Time) (bool, error)
+}
+
+type RevokedToken struct {
+	ID        string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+type UserRevocation struct {
+	UserID    string    `gorm:"primaryKey"`
+	RevokedAt time.Time `gorm:"not null"`
+}
+
+type PostgresRevocationStore struct {
+	Conn *gorm.DB
+}
+
+func NewPostgresRevocationStore(pool *gorm.DB) *PostgresRevocationStore {
+	pool.AutoMigrate(&RevokedToken{}, &UserRevocation{})
+
+	return &PostgresRevocationStore{
+		Conn: pool,
+	}
+}
+
+/*
+Revoke is a method that revokes a token or a session by its id until expiresAt.
+Revocations which are already past their expiry are cleaned up at the same time.
+*/
+func (s *PostgresRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return s.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+		}).Create(&RevokedToken{ID: id, ExpiresAt: expiresAt}).Error
+	})
+}
+
+/*
+RevokeUser is a method that revokes every token of the user which was issued before revokedAt.
+*/
+func (s *PostgresRevocationStore) RevokeUser(userID string, revokedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return s.Conn.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_at"}),
+	}).Create(&UserRevocation{UserID: userID, RevokedAt: revokedAt}).Error
+}
+
+/*
+IsRevoked is a method that reports whether any of the ids is revoked, or the user revoked all of its tokens after issuedAt.
+*/
+func (s *PostgresRevocationStore) IsRevoked(ids []string, userID string, issuedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var count int64
+	err := s.Conn.WithContext(ctx).Model(&RevokedToken{}).
+		Where("id IN ? AND expires_at > ?", ids, time.Now()).
+		Count(&count).Error
+
+	if err != nil {
+		return false, err
+	}
+
+	if count > 0 {
+		return true, nil
+	}
+
+	err = s.Conn.WithContext(ctx).Model(&UserRevocation{}).
+		Where("user_id = ? AND revoked_at > ?", userID, issuedAt).
+		Count(&count).Error
+
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permissions which can be granted to a role
+const (
+	PermUsersRead       = "users:read"
+	PermUsersCreate     = "users:create"
+	PermUsersDelete     = "users:delete"
+	PermUsersUpdateRole = "users:update_role"
+	PermUsersUnlock     = "users:unlock"
+	PermSessionsManage  = "sessions:manage"
+	PermRolesManage     = "roles:manage"
+
+	PermPasswordPolicyManage = "password_policy:manage"
+	PermMFAPolicyManage      = "mfa_policy:manage"
+	PermClientsManage        = "clients:manage"
+)
+
+// Permissions is the list of every permission, in the order they are documented
+var Permissions = []string{
+	PermUsersRead,
+	PermUsersCreate,
+	PermUsersDelete,
+	PermUsersUpdateRole,
+	PermUsersUnlock,
+	PermSessionsManage,
+	PermRolesManage,
+	PermPasswordPolicyManage,
+	PermMFAPolicyManage,
+	PermClientsManage,
+}
+
+/*
+BuiltInRoles are the roles every organization has, they can not be changed or deleted.
+*/
+var BuiltInRoles = map[string][]string{
+	AdminRole: Permissions,
+	"member":  {PermUsersRead},
+}
+
+// AdminRole is the built in role which an organization must always have a user with
+const AdminRole = "admin"
+
+var (
+	ErrDuplicateRole = errors.New("role already exists")
+	ErrRoleInUse     = errors.New("role is still assigned to users")
+	ErrLastAdmin     = errors.New("organization must keep at least one admin")
+)
+
+/*
+Role is a named set of permissions. User.Role is the name of a built in role or of a custom role of the organization.
+*/
+type Role struct {
+	GormModel
+	OrganizationID string   `json:"organization_id" gorm:"not null;uniqueIndex:idx_roles_org_name"`
+	Name           string   `json:"name" gorm:"not null;uniqueIndex:idx_roles_org_name"`
+	Permissions    []string `json:"permissions" gorm:"serializer:json"`
+	BuiltIn        bool     `json:"built_in" gorm:"-"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the Role struct
+func (role *Role) BeforeCreate(tx *gorm.DB) (err error) {
+	role.ID = uuid.NewString()
+	return nil
+}
+
+/*
+RoleChange records who changed the role of a user, and from which role to which.
+*/
+type RoleChange struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	UserID      string    `json:"user_id" gorm:"not null;index"`
+	OldRole     string    `json:"old_role" gorm:"not null"`
+	NewRole     string    `json:"new_role" gorm:"not null"`
+	ChangedByID string    `json:"changed_by_id" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the RoleChange struct
+func (change *RoleChange) BeforeCreate(tx *gorm.DB) (err error) {
+	change.ID = uuid.NewString()
+	return nil
+}
+
+/*
+HasPermission reports whether the role grants the permission.
+*/
+func (role Role) HasPermission(permission string) bool {
+	for _, granted := range role.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+builtInRole returns the built in role with the name, ok is false if there is none.
+*/
+func builtInRole(orgID, name string) (Role, bool) {
+	permissions, ok := BuiltInRoles[name]
+
+	if !ok {
+		return Role{}, false
+	}
+
+	return Role{OrganizationID: orgID, Name: name, Permissions: permissions, BuiltIn: true}, true
+}
+
+/*
+builtInRoles returns every built in role, sorted by name.
+*/
+func builtInRoles(orgID string) []Role {
+	roles := []Role{}
+
+	for name := range BuiltInRoles {
+		role, _ := builtInRole(orgID, name)
+		roles = append(roles, role)
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+/*
+GetRole is a method that returns the built in or custom role of the organization with the name.
+If no role matches, the returned Role has an empty Name.
+*/
+func (u *PostgresRepository) GetRole(orgID, name string) (*Role, error) {
+	if role, ok := builtInRole(orgID, name); ok {
+		return &role, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var role Role
+	err := db.WithContext(ctx).Model(&Role{}).Find(&role, "organization_id = ? AND name = ?", orgID, name).Error
+
+	if err != nil {
+		return &Role{}, err
+	}
+
+	return &role, nil
+}
+
+/*
+GetRoles is a method that returns the built in roles followed by the custom roles of the organization.
+*/
+func (u *PostgresRepository) GetRoles(orgID string) ([]Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var roles []Role
+	err := db.WithContext(ctx).Model(&Role{}).Order("name").Find(&roles, "organization_id = ?", orgID).Error
+
+	if err != nil {
+		return []Role{}, err
+	}
+
+	return append(builtInRoles(orgID), roles...), nil
+}
+
+/*
+CreateRole is a method that inserts a custom role of an organization.
+It returns ErrDuplicateRole if the organization already has a role with the same name, or if it is a built in role.
+*/
+func (u *PostgresRepository) CreateRole(role Role) error {
+	if _, ok := BuiltInRoles[role.Name]; ok {
+		return ErrDuplicateRole
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	err := db.WithContext(ctx).Create(&role).Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateRole
+		}
+		return err
+	}
+
+	return nil
+}
+
+/*
+DeleteRole is a method that deletes a custom role of an organization.
+It returns ErrRoleInUse if some users of the organization still have the role.
+*/
+func (u *PostgresRepository) DeleteRole(role Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var users int64
+
+		// locked before counting, so that an invitation being accepted with the role is counted
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&Role{}, "id = ?", role.ID).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&User{}).Where("organization_id = ? AND role = ?", role.OrganizationID, role.Name).Count(&users).Error
+
+		if err != nil {
+			return err
+		}
+
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		return tx.Delete(&role).Error
+	})
+}
+
+/*
+UpdateUserRole is a method that changes the role of a user and records the change made by changedBy.
+It returns ErrLastAdmin if the user is the last admin of the organization and the new role is not admin.
+*/
+func (u *PostgresRepository) UpdateUserRole(user User, role string, changedBy User) (*RoleChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var change RoleChange
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := lockUserAndAdmins(tx, user)
+
+		// the last admin can still be given the admin role again
+		if errors.Is(err, ErrLastAdmin) && role == AdminRole {
+			err = nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&current).Update("role", role).Error
+
+		if err != nil {
+			return err
+		}
+
+		change = RoleChange{
+			UserID:      current.ID,
+			OldRole:     current.Role,
+			NewRole:     role,
+			ChangedByID: changedBy.ID,
+		}
+
+		return tx.Create(&change).Error
+	})
+
+	if err != nil {
+		return &RoleChange{}, err
+	}
+
+	return &change, nil
+}
+
+/*
+lockUserAndAdmins locks the admins of the organization of the user and then the user until the end of the transaction,
+and returns the user as stored. The admins are locked first and in the order of their ids, so that concurrent removals
+of admins wait for each other instead of deadlocking, and two admins can not be removed at the same time.
+It returns ErrLastAdmin along with the user if the user is the last admin of the organization.
+*/
+func lockUserAndAdmins(tx *gorm.DB, user User) (User, error) {
+	var admins []string
+	var current User
+
+	err := tx.Model(&User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND role = ?", user.OrganizationID, AdminRole).
+		Order("id").Pluck("id", &admins).Error
+
+	if err != nil {
+		return current, err
+	}
+
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&current, "id = ?", user.ID).Error
+
+	if err != nil {
+		return current, err
+	}
+
+	if current.Role == AdminRole && len(admins) <= 1 {
+		return current, ErrLastAdmin
+	}
+
+	return current, nil
+}
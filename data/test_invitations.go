@@ -0,0 +1,145 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking Invitations
+======================
+The invitations are kept in memory, the users created by accepting them are not.
+*/
+
+func (tr *PostgresTestRepository) InsertInvitation(invitation Invitation) (*Invitation, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	invitation.ID = uuid.NewString()
+	invitation.CreatedAt = time.Now()
+	tr.invitations[invitation.ID] = invitation
+	return &invitation, nil
+}
+
+func (tr *PostgresTestRepository) GetInvitation(id string) (*Invitation, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	invitation := tr.invitations[id]
+	return &invitation, nil
+}
+
+func (tr *PostgresTestRepository) GetPendingInvitations(orgID string) ([]Invitation, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	invitations := []Invitation{}
+	for _, invitation := range tr.invitations {
+		if invitation.OrganizationID == orgID && invitation.AcceptedAt == nil && invitation.RevokedAt == nil {
+			invitations = append(invitations, invitation)
+		}
+	}
+
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].CreatedAt.After(invitations[j].CreatedAt) })
+	return invitations, nil
+}
+
+func (tr *PostgresTestRepository) RenewInvitation(invitation Invitation, tokenHash string, expiresAt time.Time) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	stored, ok := tr.invitations[invitation.ID]
+
+	if !ok || stored.AcceptedAt != nil || stored.RevokedAt != nil {
+		return ErrInvitationNotActive
+	}
+
+	stored.TokenHash = tokenHash
+	stored.ExpiresAt = expiresAt
+	tr.invitations[invitation.ID] = stored
+	return nil
+}
+
+func (tr *PostgresTestRepository) RevokeInvitation(invitation Invitation) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	stored, ok := tr.invitations[invitation.ID]
+
+	if !ok || stored.AcceptedAt != nil || stored.RevokedAt != nil {
+		return ErrInvitationNotActive
+	}
+
+	now := time.Now()
+	stored.RevokedAt = &now
+	tr.invitations[invitation.ID] = stored
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetInvitationByToken(tokenHash string) (*Invitation, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	invitation, ok := tr.pendingInvitation(tokenHash)
+
+	if !ok {
+		return &Invitation{}, ErrInvalidInvitation
+	}
+
+	return &invitation, nil
+}
+
+func (tr *PostgresTestRepository) AcceptInvitation(tokenHash, password string) (*User, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	invitation, ok := tr.pendingInvitation(tokenHash)
+
+	if !ok {
+		return &User{}, ErrInvalidInvitation
+	}
+
+	if invitation.Username == "test-username" {
+		return &User{}, ErrDuplicateUsername
+	}
+
+	if _, ok := BuiltInRoles[invitation.Role]; !ok {
+		if _, ok := tr.roles[invitation.OrganizationID+"/"+invitation.Role]; !ok {
+			return &User{}, ErrInvitationRole
+		}
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	tr.invitations[invitation.ID] = invitation
+
+	user := User{
+		Username:       invitation.Username,
+		Password:       password,
+		Role:           invitation.Role,
+		OrganizationID: invitation.OrganizationID,
+	}
+	user.ID = uuid.NewString()
+
+	if invitation.Email != "" {
+		user.Email = &invitation.Email
+		user.EmailVerifiedAt = &now
+	}
+
+	return &user, nil
+}
+
+// pendingInvitation returns the unexpired invitation with the token hash which was neither accepted nor revoked
+func (tr *PostgresTestRepository) pendingInvitation(tokenHash string) (Invitation, bool) {
+	for _, invitation := range tr.invitations {
+		if invitation.TokenHash == tokenHash && invitation.AcceptedAt == nil && invitation.RevokedAt == nil &&
+			invitation.ExpiresAt.After(time.Now()) {
+			return invitation, true
+		}
+	}
+
+	return Invitation{}, false
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"time"
+)
+
+/*
+=======================
+Mocking Lockout
+======================
+The failed logins and the locks are kept in memory, keyed by user id.
+*/
+
+func (tr *PostgresTestRepository) RecordFailedLogin(user User) (int, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.failedLogins[user.ID]++
+	return tr.failedLogins[user.ID], nil
+}
+
+func (tr *PostgresTestRepository) LockUser(user User, until time.Time) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.lockedUntil[user.ID] = until
+	return nil
+}
+
+func (tr *PostgresTestRepository) ResetFailedLogins(user User) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.failedLogins, user.ID)
+	delete(tr.lockedUntil, user.ID)
+	return nil
+}
+
+// withState sets the failed logins, the lock, the TOTP and the profile of the mocked user
+func (tr *PostgresTestRepository) withState(user *User) *User {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	user.FailedLoginAttempts = tr.failedLogins[user.ID]
+
+	if until, ok := tr.lockedUntil[user.ID]; ok {
+		user.LockedUntil = &until
+	}
+
+	if totp, ok := tr.totp[user.ID]; ok {
+		user.TOTPSecret = totp.TOTPSecret
+		user.TOTPEnabledAt = totp.TOTPEnabledAt
+		user.TOTPLastStep = totp.TOTPLastStep
+	}
+
+	if profile, ok := tr.profiles[user.ID]; ok {
+		user.Email = profile.Email
+		user.EmailVerifiedAt = profile.EmailVerifiedAt
+		user.Profile = profile.Profile
+	}
+
+	return user
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"time"
+)
+
+/*
+=======================
+Mocking MFA
+======================
+The TOTP fields and the recovery codes are kept in memory, keyed by user id.
+*/
+
+func (tr *PostgresTestRepository) SetTOTPSecret(user User, secret string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	totp := tr.totp[user.ID]
+
+	if totp.TOTPEnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	totp.TOTPSecret = secret
+	tr.totp[user.ID] = totp
+	return nil
+}
+
+func (tr *PostgresTestRepository) EnableTOTP(user User, step int64, codeHashes []string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	totp := tr.totp[user.ID]
+
+	if totp.TOTPEnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	now := time.Now()
+	totp.TOTPEnabledAt = &now
+	totp.TOTPLastStep = step
+	tr.totp[user.ID] = totp
+
+	tr.replaceRecoveryCodes(user, codeHashes)
+	return nil
+}
+
+func (tr *PostgresTestRepository) DisableTOTP(user User) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.totp, user.ID)
+	delete(tr.recoveryCodes, user.ID)
+	return nil
+}
+
+func (tr *PostgresTestRepository) UseTOTPStep(user User, step int64) (bool, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	totp := tr.totp[user.ID]
+
+	if totp.TOTPLastStep >= step {
+		return false, nil
+	}
+
+	totp.TOTPLastStep = step
+	tr.totp[user.ID] = totp
+	return true, nil
+}
+
+func (tr *PostgresTestRepository) ReplaceRecoveryCodes(user User, codeHashes []string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.replaceRecoveryCodes(user, codeHashes)
+	return nil
+}
+
+func (tr *PostgresTestRepository) replaceRecoveryCodes(user User, codeHashes []string) {
+	codes := map[string]bool{}
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+
+	tr.recoveryCodes[user.ID] = codes
+}
+
+func (tr *PostgresTestRepository) ConsumeRecoveryCode(user User, codeHash string) (bool, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	used, ok := tr.recoveryCodes[user.ID][codeHash]
+
+	if !ok || used {
+		return false, nil
+	}
+
+	tr.recoveryCodes[user.ID][codeHash] = true
+	return true, nil
+}
+
+func (tr *PostgresTestRepository) SetRequireAdminMFA(org Organization, require bool) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	summary := tr.orgs[org.ID]
+	summary.RequireAdminMFA = require
+	tr.orgs[org.ID] = summary
+	return nil
+}
@@ -1,16 +1,57 @@
 package data
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type PostgresTestRepository struct {
 	Conn *gorm.DB
+
+	mu            sync.Mutex
+	refreshTokens map[string]RefreshToken        // keyed by token hash
+	orgs          map[string]OrganizationSummary // keyed by id
+	roles         map[string]Role                // custom roles, keyed by organization id and name
+	oneTimeTokens map[string]OneTimeToken        // keyed by token hash
+	failedLogins  map[string]int                 // keyed by user id
+	lockedUntil   map[string]time.Time           // keyed by user id
+	totp          map[string]User                // the TOTP fields, keyed by user id
+	recoveryCodes map[string]map[string]bool     // used or not, keyed by user id and code hash
+	credentials   map[string]WebAuthnCredential  // keyed by credential id
+	invitations   map[string]Invitation          // keyed by id
+	profiles      map[string]User                // the email and profile fields, keyed by user id
+	oauthClients  map[string]OAuthClient         // keyed by id
+	consents      map[string]OAuthConsent        // keyed by user id and client id
+	codes         map[string]AuthorizationCode   // keyed by code hash
+	devices       map[string]DeviceAuthorization // keyed by device code hash
 }
 
 func NewPostgresTestRepository(pool *gorm.DB) *PostgresTestRepository {
+	testOrg := OrganizationSummary{MemberCount: 1}
+	testOrg.ID = "test-org-1"
+	testOrg.Name = "test-org"
+	testOrg.PasswordPolicy = DefaultPasswordPolicy()
+
 	return &PostgresTestRepository{
-		Conn: pool,
+		Conn:          pool,
+		refreshTokens: map[string]RefreshToken{},
+		orgs:          map[string]OrganizationSummary{testOrg.ID: testOrg},
+		roles:         map[string]Role{},
+		oneTimeTokens: map[string]OneTimeToken{},
+		failedLogins:  map[string]int{},
+		lockedUntil:   map[string]time.Time{},
+		totp:          map[string]User{},
+		recoveryCodes: map[string]map[string]bool{},
+		credentials:   map[string]WebAuthnCredential{},
+		invitations:   map[string]Invitation{},
+		profiles:      map[string]User{},
+		oauthClients:  map[string]OAuthClient{},
+		consents:      map[string]OAuthConsent{},
+		codes:         map[string]AuthorizationCode{},
+		devices:       map[string]DeviceAuthorization{},
 	}
 }
 
@@ -21,17 +62,101 @@ Mocking Database
 */
 
 func (tr *PostgresTestRepository) GetByUsername(username string) (*User, error) {
+	if username == "unknown-user" {
+		return &User{}, nil
+	}
+
+	// a member of its own, so that locking it does not lock the other tests out
+	if username == "lockout-user" {
+		user := User{
+			Username:       username,
+			Password:       "test-password",
+			Role:           "member",
+			OrganizationID: "test-org-1",
+		}
+		user.ID = "lockout-user-id"
+		return tr.withState(&user), nil
+	}
+
+	// a member of its own, so that enabling its TOTP does not ask the other tests for a code
+	if username == "mfa-user" {
+		user := User{
+			Username:       username,
+			Password:       "test-password",
+			Role:           "member",
+			OrganizationID: "test-org-1",
+		}
+		user.ID = "mfa-user-id"
+		return tr.withState(&user), nil
+	}
+
+	// a member of its own, so that registering its passkeys does not ask the other tests for them
+	if username == "passkey-user" {
+		user := User{
+			Username:       username,
+			Password:       "test-password",
+			Role:           "member",
+			OrganizationID: "test-org-1",
+		}
+		user.ID = "passkey-user-id"
+		return tr.withState(&user), nil
+	}
+
+	// the single admin of the mocked organization
+	if username == "last-admin" {
+		user := User{
+			Username:       username,
+			Password:       "test-password",
+			Role:           AdminRole,
+			OrganizationID: "test-org-1",
+		}
+		user.ID = "last-admin-id"
+		return &user, nil
+	}
+
+	// with a verified email, which the password reset and login links are sent to
+	email := "test-username@example.com"
+	verifiedAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	user := User{
-		Username:       "test-username",
-		Password:       "test-password",
-		Role:           "member",
-		OrganizationID: "test-org-1",
+		Username:        "test-username",
+		Password:        "test-password",
+		Role:            "member",
+		OrganizationID:  "test-org-1",
+		Email:           &email,
+		EmailVerifiedAt: &verifiedAt,
 	}
 	user.ID = "random-test-id"
-	return &user, nil
+	return tr.withState(&user), nil
 }
 
 func (tr *PostgresTestRepository) GetByID(id string) (*User, error) {
+	// the user returned by GetByUsername is a member, every other id is an admin
+	if id == "random-test-id" {
+		return tr.GetByUsername("test-username")
+	}
+
+	if id == "mfa-user-id" {
+		return tr.GetByUsername("mfa-user")
+	}
+
+	if id == "passkey-user-id" {
+		return tr.GetByUsername("passkey-user")
+	}
+
+	// the users of the custom role-manager and user-deleter roles, which the tests create
+	if id == "role-manager-id" || id == "user-deleter-id" {
+		name := strings.TrimSuffix(id, "-id")
+		user := User{
+			Username:       name,
+			Password:       "test-password",
+			Role:           name,
+			OrganizationID: "test-org-1",
+		}
+		user.ID = id
+		return tr.withState(&user), nil
+	}
+
 	user := User{
 		Username:       "test-username",
 		Password:       "test-password",
@@ -39,19 +164,41 @@ func (tr *PostgresTestRepository) GetByID(id string) (*User, error) {
 		OrganizationID: "test-org-1",
 	}
 	user.ID = id
-	return &user, nil
+	return tr.withState(&user), nil
 }
 
 func (tr *PostgresTestRepository) Insert(user User) error {
+	if user.Username == "test-username" {
+		return ErrDuplicateUsername
+	}
 	return nil
 }
 
 func (tr *PostgresTestRepository) Delete(user User) error {
+	if user.ID == "last-admin-id" {
+		return ErrLastAdmin
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for hash, token := range tr.refreshTokens {
+		if token.UserID == user.ID {
+			delete(tr.refreshTokens, hash)
+		}
+	}
+
+	for hash, token := range tr.oneTimeTokens {
+		if token.UserID == user.ID {
+			delete(tr.oneTimeTokens, hash)
+		}
+	}
+
 	return nil
 }
 
 func (tr *PostgresTestRepository) PasswordMatch(plainTextPassword string, user User) (bool, error) {
-	return true, nil
+	return plainTextPassword != "wrong-password", nil
 }
 
 func (tr *PostgresTestRepository) GetAllOtherUsersInOrg(user User) ([]User, error) {
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking OAuth
+======================
+The clients, the consents, the authorization codes and the device authorizations are kept in memory,
+so that the flows can be tested end to end.
+*/
+
+func (tr *PostgresTestRepository) InsertOAuthClient(client OAuthClient) (*OAuthClient, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	client.ID = uuid.NewString()
+	client.CreatedAt = time.Now()
+	tr.oauthClients[client.ID] = client
+	return &client, nil
+}
+
+func (tr *PostgresTestRepository) GetOAuthClient(id string) (*OAuthClient, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	client := tr.oauthClients[id]
+	return &client, nil
+}
+
+func (tr *PostgresTestRepository) GetOAuthClients(orgID string) ([]OAuthClient, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	clients := []OAuthClient{}
+	for _, client := range tr.oauthClients {
+		if client.OrganizationID == orgID {
+			clients = append(clients, client)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (tr *PostgresTestRepository) DeleteOAuthClient(client OAuthClient) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range tr.refreshTokens {
+		if token.ClientID == client.ID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			tr.refreshTokens[hash] = token
+		}
+	}
+
+	for key, consent := range tr.consents {
+		if consent.ClientID == client.ID {
+			delete(tr.consents, key)
+		}
+	}
+
+	for hash, code := range tr.codes {
+		if code.ClientID == client.ID {
+			delete(tr.codes, hash)
+		}
+	}
+
+	for hash, device := range tr.devices {
+		if device.ClientID == client.ID {
+			delete(tr.devices, hash)
+		}
+	}
+
+	delete(tr.oauthClients, client.ID)
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetOAuthConsent(userID, clientID string) (*OAuthConsent, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	consent := tr.consents[userID+" "+clientID]
+	return &consent, nil
+}
+
+func (tr *PostgresTestRepository) SaveOAuthConsent(consent OAuthConsent) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	consent.ID = uuid.NewString()
+	tr.consents[consent.UserID+" "+consent.ClientID] = consent
+	return nil
+}
+
+func (tr *PostgresTestRepository) InsertAuthorizationCode(code AuthorizationCode) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	code.ID = uuid.NewString()
+	tr.codes[code.CodeHash] = code
+	return nil
+}
+
+func (tr *PostgresTestRepository) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	code, ok := tr.codes[codeHash]
+	now := time.Now()
+
+	if ok && code.UsedAt != nil {
+		return &code, ErrAuthorizationCodeReused
+	}
+
+	if !ok || !code.ExpiresAt.After(now) {
+		return &AuthorizationCode{}, ErrInvalidAuthorizationCode
+	}
+
+	code.UsedAt = &now
+	tr.codes[codeHash] = code
+	return &code, nil
+}
+
+func (tr *PostgresTestRepository) InsertDeviceAuthorization(authorization DeviceAuthorization) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	authorization.ID = uuid.NewString()
+	tr.devices[authorization.DeviceCodeHash] = authorization
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetDeviceAuthorization(userCodeHash string) (*DeviceAuthorization, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for _, device := range tr.devices {
+		if device.UserCodeHash == userCodeHash && device.ExpiresAt.After(time.Now()) &&
+			device.ApprovedAt == nil && device.DeniedAt == nil {
+			return &device, nil
+		}
+	}
+
+	return &DeviceAuthorization{}, ErrInvalidUserCode
+}
+
+func (tr *PostgresTestRepository) DecideDeviceAuthorization(authorization DeviceAuthorization, user User, scope string, approved bool) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	device, ok := tr.devices[authorization.DeviceCodeHash]
+	now := time.Now()
+
+	if !ok || !device.ExpiresAt.After(now) || device.ApprovedAt != nil || device.DeniedAt != nil {
+		return ErrDeviceAuthorizationDecided
+	}
+
+	device.UserID = user.ID
+
+	if approved {
+		device.Scope = scope
+		device.ApprovedAt = &now
+	} else {
+		device.DeniedAt = &now
+	}
+
+	tr.devices[authorization.DeviceCodeHash] = device
+	return nil
+}
+
+func (tr *PostgresTestRepository) PollDeviceAuthorization(deviceCodeHash, clientID string) (*DeviceAuthorization, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	device, ok := tr.devices[deviceCodeHash]
+
+	if !ok || device.ClientID != clientID {
+		return &DeviceAuthorization{}, ErrInvalidDeviceCode
+	}
+
+	now := time.Now()
+	err := devicePollError(device, now)
+
+	switch err {
+	case ErrInvalidDeviceCode, ErrDeviceCodeExpired:
+		return &DeviceAuthorization{}, err
+	case ErrDeviceSlowDown:
+		device.Interval += deviceSlowDownStep
+	case nil:
+		device.UsedAt = &now
+	}
+
+	device.LastPolledAt = &now
+	tr.devices[deviceCodeHash] = device
+
+	if err != nil {
+		return &DeviceAuthorization{}, err
+	}
+
+	return &device, nil
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking One Time Tokens
+======================
+One time tokens are kept in memory so that they can be consumed end to end.
+*/
+
+func (tr *PostgresTestRepository) InsertOneTimeToken(token OneTimeToken) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	token.ID = uuid.NewString()
+	tr.oneTimeTokens[token.TokenHash] = token
+	return nil
+}
+
+func (tr *PostgresTestRepository) ReplaceOneTimeToken(token OneTimeToken) error {
+	tr.mu.Lock()
+	now := time.Now()
+
+	for hash, other := range tr.oneTimeTokens {
+		if other.UserID == token.UserID && other.Purpose == token.Purpose && other.UsedAt == nil {
+			other.UsedAt = &now
+			tr.oneTimeTokens[hash] = other
+		}
+	}
+
+	tr.mu.Unlock()
+	return tr.InsertOneTimeToken(token)
+}
+
+func (tr *PostgresTestRepository) GetOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	token, ok := tr.oneTimeTokens[tokenHash]
+
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return &OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	return &token, nil
+}
+
+func (tr *PostgresTestRepository) ConsumeOneTimeToken(purpose, tokenHash string) (*OneTimeToken, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	token, ok := tr.oneTimeTokens[tokenHash]
+	now := time.Now()
+
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+		return &OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	token.UsedAt = &now
+	tr.oneTimeTokens[tokenHash] = token
+	return &token, nil
+}
+
+func (tr *PostgresTestRepository) UpdatePassword(user User, password string) error {
+	return nil
+}
+
+func (tr *PostgresTestRepository) ResetPassword(tokenHash, password string) error {
+	_, err := tr.ConsumeOneTimeToken(PurposePasswordReset, tokenHash)
+	return err
+}
+
+func (tr *PostgresTestRepository) PasswordUsedRecently(user User, password string, n int) (bool, error) {
+	// the mocked users have always used "recent-password" before
+	return n > 0 && password == "recent-password", nil
+}
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking Organizations
+======================
+Organizations are kept in memory, the mocked users all belong to "test-org-1".
+The username of the mocked users, "test-username", is always taken.
+*/
+
+func (tr *PostgresTestRepository) CreateOrganization(org Organization, admin User) (*Organization, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.orgNameTaken(org.Name, "") {
+		return &Organization{}, ErrDuplicateOrganization
+	}
+
+	if admin.Username == "test-username" {
+		return &Organization{}, ErrDuplicateUsername
+	}
+
+	org.ID = uuid.NewString()
+	org.PasswordPolicy = DefaultPasswordPolicy()
+	admin.ID = uuid.NewString()
+	admin.Role = "admin"
+	admin.OrganizationID = org.ID
+	org.Users = []User{admin}
+
+	tr.orgs[org.ID] = OrganizationSummary{Organization: Organization{GormModel: org.GormModel, Name: org.Name, PasswordPolicy: org.PasswordPolicy}, MemberCount: 1}
+	return &org, nil
+}
+
+func (tr *PostgresTestRepository) GetOrganizationByID(id string) (*Organization, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	org := tr.orgs[id].Organization
+	return &org, nil
+}
+
+func (tr *PostgresTestRepository) GetOrganizations() ([]OrganizationSummary, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	orgs := []OrganizationSummary{}
+	for _, org := range tr.orgs {
+		orgs = append(orgs, org)
+	}
+
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+func (tr *PostgresTestRepository) RenameOrganization(org Organization, name string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.orgNameTaken(name, org.ID) {
+		return ErrDuplicateOrganization
+	}
+
+	summary := tr.orgs[org.ID]
+	summary.Name = name
+	tr.orgs[org.ID] = summary
+	return nil
+}
+
+func (tr *PostgresTestRepository) DeleteOrganization(org Organization, cascade bool) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.orgs[org.ID].MemberCount > 0 && !cascade {
+		return ErrOrganizationNotEmpty
+	}
+
+	delete(tr.orgs, org.ID)
+	return nil
+}
+
+func (tr *PostgresTestRepository) UpdatePasswordPolicy(org Organization, policy PasswordPolicy) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	summary := tr.orgs[org.ID]
+	summary.PasswordPolicy = policy
+	tr.orgs[org.ID] = summary
+	return nil
+}
+
+func (tr *PostgresTestRepository) orgNameTaken(name, exceptID string) bool {
+	for id, org := range tr.orgs {
+		if org.Name == name && id != exceptID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package data
+
+import "time"
+
+/*
+=======================
+Mocking Profiles
+======================
+The email and the profile of the mocked users are kept in memory, "taken@example.com" is another user's email.
+*/
+
+func (tr *PostgresTestRepository) UpdateProfile(user User, profile Profile) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	stored := tr.profiles[user.ID]
+	stored.Profile = profile
+	tr.profiles[user.ID] = stored
+	return nil
+}
+
+func (tr *PostgresTestRepository) SetEmail(user User, email string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if email == "taken@example.com" {
+		return ErrDuplicateEmail
+	}
+
+	stored := tr.profiles[user.ID]
+	stored.Email = nil
+	stored.EmailVerifiedAt = nil
+
+	if email != "" {
+		stored.Email = &email
+	}
+
+	tr.profiles[user.ID] = stored
+	return nil
+}
+
+func (tr *PostgresTestRepository) VerifyEmail(user User, email string) (bool, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	stored := tr.profiles[user.ID]
+
+	if stored.Email == nil || *stored.Email != email || stored.EmailVerifiedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	stored.EmailVerifiedAt = &now
+	tr.profiles[user.ID] = stored
+	return true, nil
+}
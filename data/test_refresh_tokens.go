@@ -0,0 +1,90 @@
+package data
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking Refresh Tokens
+======================
+Refresh tokens are kept in memory so that rotation and reuse detection can be tested end to end.
+*/
+
+func (tr *PostgresTestRepository) InsertRefreshToken(token RefreshToken) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	token.ID = uuid.NewString()
+	tr.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	token := tr.refreshTokens[tokenHash]
+	return &token, nil
+}
+
+func (tr *PostgresTestRepository) RotateRefreshToken(old RefreshToken, next RefreshToken) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	current, ok := tr.refreshTokens[old.TokenHash]
+	if !ok || current.UsedAt != nil || current.RevokedAt != nil {
+		return ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	current.UsedAt = &now
+	tr.refreshTokens[old.TokenHash] = current
+
+	next.ID = uuid.NewString()
+	tr.refreshTokens[next.TokenHash] = next
+	return nil
+}
+
+func (tr *PostgresTestRepository) RevokeRefreshTokenFamily(familyID string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range tr.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			tr.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetActiveRefreshTokens(userID string) ([]RefreshToken, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tokens := []RefreshToken{}
+	for _, token := range tr.refreshTokens {
+		if token.UserID == userID && token.UsedAt == nil && token.RevokedAt == nil && token.ExpiresAt.After(time.Now()) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (tr *PostgresTestRepository) RevokeUserRefreshTokens(userID string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range tr.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			tr.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
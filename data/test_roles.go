@@ -0,0 +1,84 @@
+package data
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking Roles
+======================
+Custom roles are kept in memory, the mocked users only have built in roles.
+*/
+
+func (tr *PostgresTestRepository) GetRole(orgID, name string) (*Role, error) {
+	if role, ok := builtInRole(orgID, name); ok {
+		return &role, nil
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	role := tr.roles[orgID+"/"+name]
+	return &role, nil
+}
+
+func (tr *PostgresTestRepository) GetRoles(orgID string) ([]Role, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	roles := []Role{}
+	for _, role := range tr.roles {
+		if role.OrganizationID == orgID {
+			roles = append(roles, role)
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return append(builtInRoles(orgID), roles...), nil
+}
+
+func (tr *PostgresTestRepository) CreateRole(role Role) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	key := role.OrganizationID + "/" + role.Name
+
+	if _, ok := tr.roles[key]; ok {
+		return ErrDuplicateRole
+	}
+
+	if _, ok := BuiltInRoles[role.Name]; ok {
+		return ErrDuplicateRole
+	}
+
+	role.ID = uuid.NewString()
+	tr.roles[key] = role
+	return nil
+}
+
+func (tr *PostgresTestRepository) DeleteRole(role Role) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.roles, role.OrganizationID+"/"+role.Name)
+	return nil
+}
+
+func (tr *PostgresTestRepository) UpdateUserRole(user User, role string, changedBy User) (*RoleChange, error) {
+	// the mocked organization has a single admin
+	if user.ID == "last-admin-id" && role != AdminRole {
+		return &RoleChange{}, ErrLastAdmin
+	}
+
+	change := RoleChange{
+		ID:          uuid.NewString(),
+		UserID:      user.ID,
+		OldRole:     user.Role,
+		NewRole:     role,
+		ChangedByID: changedBy.ID,
+	}
+	return &change, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+=======================
+Mocking WebAuthn
+======================
+The passkeys are kept in memory, keyed by credential id.
+*/
+
+func (tr *PostgresTestRepository) InsertWebAuthnCredential(credential WebAuthnCredential) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if _, ok := tr.credentials[credential.CredentialID]; ok {
+		return ErrDuplicateCredential
+	}
+
+	credential.ID = uuid.NewString()
+	credential.CreatedAt = time.Now()
+	tr.credentials[credential.CredentialID] = credential
+	return nil
+}
+
+func (tr *PostgresTestRepository) GetWebAuthnCredential(credentialID string) (*WebAuthnCredential, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	credential := tr.credentials[credentialID]
+	return &credential, nil
+}
+
+func (tr *PostgresTestRepository) GetWebAuthnCredentials(userID string) ([]WebAuthnCredential, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	credentials := []WebAuthnCredential{}
+	for _, credential := range tr.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+	return credentials, nil
+}
+
+func (tr *PostgresTestRepository) UseWebAuthnCredential(credential WebAuthnCredential, signCount uint32) (bool, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	stored, ok := tr.credentials[credential.CredentialID]
+
+	if !ok || (stored.SignCount >= int64(signCount) && (stored.SignCount != 0 || signCount != 0)) {
+		return false, nil
+	}
+
+	now := time.Now()
+	stored.SignCount = int64(signCount)
+	stored.LastUsedAt = &now
+	tr.credentials[credential.CredentialID] = stored
+	return true, nil
+}
+
+func (tr *PostgresTestRepository) DeleteWebAuthnCredential(credential WebAuthnCredential) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.credentials, credential.CredentialID)
+	return nil
+}
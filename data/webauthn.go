@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrDuplicateCredential is returned when a passkey is registered twice
+var ErrDuplicateCredential = errors.New("passkey already registered")
+
+/*
+WebAuthnCredential is a passkey of a user, registered with WebAuthn. The public key is stored as the COSE key
+given by the authenticator, and the signature counter of the authenticator is kept to detect cloned passkeys.
+*/
+type WebAuthnCredential struct {
+	GormModel
+	UserID       string     `json:"-" gorm:"not null;index"`
+	CredentialID string     `json:"credential_id" gorm:"not null;uniqueIndex"`
+	PublicKey    []byte     `json:"-" gorm:"not null"`
+	SignCount    int64      `json:"sign_count" gorm:"not null;default:0"`
+	Name         string     `json:"name"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// BeforeCreate hook is used to generate a UUID for the ID field of the WebAuthnCredential struct
+func (credential *WebAuthnCredential) BeforeCreate(tx *gorm.DB) (err error) {
+	credential.ID = uuid.NewString()
+	return nil
+}
+
+/*
+InsertWebAuthnCredential is a method that inserts a WebAuthnCredential struct into the database.
+It returns ErrDuplicateCredential if the passkey is already registered.
+*/
+func (u *PostgresRepository) InsertWebAuthnCredential(credential WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	err := db.WithContext(ctx).Create(&credential).Error
+
+	if isUniqueViolation(err) {
+		return ErrDuplicateCredential
+	}
+
+	return err
+}
+
+/*
+GetWebAuthnCredential is a method that returns the passkey with the credential id, or an empty one if there is none.
+*/
+func (u *PostgresRepository) GetWebAuthnCredential(credentialID string) (*WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var credential WebAuthnCredential
+
+	err := db.WithContext(ctx).Find(&credential, "credential_id = ?", credentialID).Error
+
+	if err != nil {
+		return &WebAuthnCredential{}, err
+	}
+
+	return &credential, nil
+}
+
+/*
+GetWebAuthnCredentials is a method that returns the passkeys of the user, oldest first.
+*/
+func (u *PostgresRepository) GetWebAuthnCredentials(userID string) ([]WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	var credentials []WebAuthnCredential
+
+	err := db.WithContext(ctx).Order("created_at").Find(&credentials, "user_id = ?", userID).Error
+
+	if err != nil {
+		return []WebAuthnCredential{}, err
+	}
+
+	return credentials, nil
+}
+
+/*
+UseWebAuthnCredential is a method that records a use of the passkey with the signature counter of the authenticator,
+and reports whether the counter is valid. The counter must increase with every use, unless the authenticator does
+not count at all and it stays 0. A counter which does not increase means the passkey may have been cloned.
+The check and the write are a single update, so that concurrent uses can not both pass with the same counter.
+*/
+func (u *PostgresRepository) UseWebAuthnCredential(credential WebAuthnCredential, signCount uint32) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result := db.WithContext(ctx).Model(&WebAuthnCredential{}).
+		Where("id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))", credential.ID, signCount, signCount).
+		Updates(map[string]any{
+			"sign_count":   signCount,
+			"last_used_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+/*
+DeleteWebAuthnCredential is a method that deletes the passkey.
+*/
+func (u *PostgresRepository) DeleteWebAuthnCredential(credential WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Delete(&credential).Error
+}